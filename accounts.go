@@ -0,0 +1,554 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newID returns a short random identifier for accounts and mutes.
+func newID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		fatal("Failed to generate ID", "error", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// assignAccountIDs gives every account a stable ID for the
+// /api/accounts/{id}/... endpoints. It reports whether the config changed.
+func assignAccountIDs() bool {
+	changed := false
+	for i := range config.Accounts {
+		if config.Accounts[i].ID == "" {
+			config.Accounts[i].ID = newID()
+			changed = true
+		}
+	}
+	return changed
+}
+
+func findAccountByID(id string) *AccountConfig {
+	for i := range config.Accounts {
+		if config.Accounts[i].ID == id {
+			return &config.Accounts[i]
+		}
+	}
+	return nil
+}
+
+// accountIDs returns the IDs of the configured accounts. Background loops
+// and timers keep IDs rather than *AccountConfig, because adding or deleting
+// an account moves the others around in config.Accounts.
+func accountIDs() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	ids := make([]string, len(config.Accounts))
+	for i := range config.Accounts {
+		ids[i] = config.Accounts[i].ID
+	}
+	return ids
+}
+
+// withAccount calls f with the account while holding the accounts lock, so
+// that it cannot be moved or deleted meanwhile. It reports whether the
+// account still exists.
+func withAccount(id string, f func(acc *AccountConfig)) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	acc := findAccountByID(id)
+	if acc == nil {
+		return false
+	}
+	f(acc)
+	return true
+}
+
+func findAccountByEmail(email string) *AccountConfig {
+	for i := range config.Accounts {
+		if config.Accounts[i].Email == email {
+			return &config.Accounts[i]
+		}
+	}
+	return nil
+}
+
+// isPaused reports whether scheduled checks are suspended, either until
+// resumed or until a snooze expires.
+func (acc *AccountConfig) isPaused() bool {
+	acc.mu.RLock()
+	defer acc.mu.RUnlock()
+	return acc.Paused || (acc.SnoozeUntil != nil && time.Now().Before(*acc.SnoozeUntil))
+}
+
+// checkIfActive runs a scheduled check unless the account is paused. An
+// expired snooze is cleared first so the account shows as running again.
+func checkIfActive(acc *AccountConfig) {
+	acc.mu.Lock()
+	expired := acc.SnoozeUntil != nil && !time.Now().Before(*acc.SnoozeUntil)
+	if expired {
+		acc.SnoozeUntil = nil
+	}
+	acc.mu.Unlock()
+
+	if expired {
+		acc.logger().Info("Snooze ended")
+		if err := saveConfig(); err != nil {
+			slog.Error("Failed to save config", "error", err)
+		}
+		publishEvent(eventMonitorResumed, acc.Email, map[string]interface{}{"paused": false})
+	}
+
+	if acc.isPaused() {
+		return
+	}
+	checkAccount(acc)
+}
+
+func accountFromRequest(w http.ResponseWriter, r *http.Request) *AccountConfig {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	acc := findAccountByID(r.PathValue("id"))
+	if acc == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Account not found"})
+		return nil
+	}
+	return acc
+}
+
+// handleAccountCheck checks one account synchronously, regardless of whether
+// it is paused, and returns the messages that matched during the check.
+func handleAccountCheck(w http.ResponseWriter, r *http.Request) {
+	acc := accountFromRequest(w, r)
+	if acc == nil {
+		return
+	}
+
+	start := time.Now()
+	err := checkAccount(acc)
+
+	acc.mu.RLock()
+	result := checkResult{UnreadCount: acc.unreadCount, Error: acc.lastError}
+	if !acc.lastCheckTime.IsZero() {
+		result.LastCheck = acc.lastCheckTime.Format("15:04:05")
+	}
+	acc.mu.RUnlock()
+
+	matches := notificationsSince(acc.Email, start)
+	message := fmt.Sprintf("%d new matching messages", len(matches))
+	if err != nil {
+		message = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      err == nil,
+		"message":      message,
+		"unread_count": result.UnreadCount,
+		"last_check":   result.LastCheck,
+		"matches":      matches,
+	})
+}
+
+func setPaused(acc *AccountConfig, paused bool, until *time.Time) error {
+	acc.mu.Lock()
+	acc.Paused = paused
+	acc.SnoozeUntil = until
+	acc.mu.Unlock()
+	return saveConfig()
+}
+
+func handleAccountPause(w http.ResponseWriter, r *http.Request) {
+	acc := accountFromRequest(w, r)
+	if acc == nil {
+		return
+	}
+
+	if err := setPaused(acc, true, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	acc.logger().Info("Monitor paused")
+	publishEvent(eventMonitorPaused, acc.Email, map[string]interface{}{"paused": true})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Account paused"})
+}
+
+func handleAccountResume(w http.ResponseWriter, r *http.Request) {
+	acc := accountFromRequest(w, r)
+	if acc == nil {
+		return
+	}
+
+	if err := setPaused(acc, false, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	acc.logger().Info("Monitor resumed")
+	publishEvent(eventMonitorResumed, acc.Email, map[string]interface{}{"paused": false})
+	go checkAccount(acc)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Account resumed"})
+}
+
+// parseDuration is time.ParseDuration plus whole days ("3d") and weeks
+// ("1w").
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// parseUntil accepts an RFC 3339 time or a duration such as "2h" or "1w".
+func parseUntil(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if d, err := parseDuration(s); err == nil {
+		return time.Now().Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (want an RFC 3339 time or a duration such as 2h or 1w)", s)
+	}
+	return t, nil
+}
+
+func handleAccountSnooze(w http.ResponseWriter, r *http.Request) {
+	acc := accountFromRequest(w, r)
+	if acc == nil {
+		return
+	}
+
+	until, err := parseUntil(r.URL.Query().Get("until"))
+	if err == nil && !until.After(time.Now()) {
+		err = fmt.Errorf("until must be in the future")
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+
+	if err := setPaused(acc, false, &until); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	acc.logger().Info("Monitor snoozed", "until", until.Format(time.RFC3339))
+	publishEvent(eventMonitorPaused, acc.Email, map[string]interface{}{"snooze_until": until})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"message":      "Account snoozed until " + until.Local().Format("Jan 2 15:04"),
+		"snooze_until": until,
+	})
+}
+
+// testAccountConnection connects with the stored credentials and returns a
+// short description for the dashboard.
+func testAccountConnection(acc *AccountConfig) (string, error) {
+	if isLocalProtocol(acc.Protocol) {
+		message, err := testLocalSource(acc.Protocol, acc.Path)
+		if err != nil {
+			return "", fmt.Errorf("Local source check failed: %v", err)
+		}
+		return message, nil
+	}
+
+	password, err := getPassword(acc.Email)
+	if err != nil {
+		return "", fmt.Errorf("failed to get password from keyring: %v", err)
+	}
+
+	switch acc.Protocol {
+	case "jmap":
+		count, err := testJMAPConnection(acc.Server, acc.Port, acc.AuthType, acc.Username, password)
+		if err != nil {
+			return "", fmt.Errorf("JMAP connection failed: %v", err)
+		}
+		return fmt.Sprintf("✅ JMAP connection successful! Found %d mailboxes", count), nil
+	case "pop3":
+		if err := testPOP3Connection(acc.Server, acc.Port, acc.Username, password); err != nil {
+			return "", fmt.Errorf("POP3 connection failed: %v", err)
+		}
+		return "✅ POP3 connection successful!", nil
+	}
+
+	c, err := connectToIMAP(acc)
+	if err != nil {
+		return "", fmt.Errorf("Connection failed: %v", err)
+	}
+	defer c.Logout()
+	return fmt.Sprintf("✅ IMAP connected successfully! Found %d folders", len(listFolders(c))), nil
+}
+
+// handleAccountTest tests a saved account, so the dashboard does not need the
+// password again.
+func handleAccountTest(w http.ResponseWriter, r *http.Request) {
+	acc := accountFromRequest(w, r)
+	if acc == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	message, err := testAccountConnection(acc)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": message})
+}
+
+// accountUpdate is the editable part of an account, as sent by the edit
+// modal. An empty email or protocol keeps the current one.
+type accountUpdate struct {
+	ID                string   `json:"id"` // preferred over Index
+	Index             int      `json:"index"`
+	Email             string   `json:"email"`
+	Protocol          string   `json:"protocol"`
+	Server            string   `json:"server"`
+	Port              int      `json:"port"`
+	Username          string   `json:"username"`
+	Password          string   `json:"password"`
+	Path              string   `json:"path"`
+	AuthType          string   `json:"auth_type"`
+	JMAPPush          bool     `json:"jmap_push"`
+	CheckInterval     int      `json:"check_interval"`
+	FolderMode        string   `json:"folder_mode"`
+	IncludeFolders    []string `json:"include_folders"`
+	ExcludeFolders    []string `json:"exclude_folders"`
+	IncludeKeyword    []string `json:"include_keyword"`
+	ExcludeKeyword    []string `json:"exclude_keyword"`
+	IncludeEmail      []string `json:"include_email"`
+	ExcludeEmail      []string `json:"exclude_email"`
+	Label             string   `json:"label"`
+	TitleTemplate     string   `json:"title_template"`
+	BodyTemplate      string   `json:"body_template"`
+	ShowSnippet       bool     `json:"show_snippet"`
+	DetectCodes       bool     `json:"detect_codes"`
+	NormalizePlusTags bool     `json:"normalize_plus_tags"`
+	FilterSets        []string `json:"filter_sets"`
+	SieveScript       string   `json:"sieve_script"`
+	WebmailURL        string   `json:"webmail_url"`
+}
+
+func currentAccountSettings(acc *AccountConfig) accountUpdate {
+	acc.mu.RLock()
+	defer acc.mu.RUnlock()
+	return accountUpdate{
+		Email:             acc.Email,
+		Protocol:          acc.Protocol,
+		Server:            acc.Server,
+		Port:              acc.Port,
+		Username:          acc.Username,
+		Path:              acc.Path,
+		AuthType:          acc.AuthType,
+		JMAPPush:          acc.JMAPPush,
+		CheckInterval:     acc.CheckInterval,
+		FolderMode:        acc.FolderMode,
+		IncludeFolders:    acc.IncludeFolders,
+		ExcludeFolders:    acc.ExcludeFolders,
+		IncludeKeyword:    acc.IncludeKeyword,
+		ExcludeKeyword:    acc.ExcludeKeyword,
+		IncludeEmail:      acc.IncludeEmail,
+		ExcludeEmail:      acc.ExcludeEmail,
+		Label:             acc.Label,
+		TitleTemplate:     acc.TitleTemplate,
+		BodyTemplate:      acc.BodyTemplate,
+		ShowSnippet:       acc.ShowSnippet,
+		DetectCodes:       acc.DetectCodes,
+		NormalizePlusTags: acc.NormalizePlusTags,
+		FilterSets:        acc.FilterSets,
+		SieveScript:       acc.SieveScript,
+		WebmailURL:        acc.WebmailURL,
+	}
+}
+
+func (u accountUpdate) apply(acc *AccountConfig) {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	acc.Email = u.Email
+	acc.Protocol = u.Protocol
+	acc.Server = u.Server
+	acc.Port = u.Port
+	acc.Username = u.Username
+	acc.Path = u.Path
+	acc.AuthType = u.AuthType
+	acc.JMAPPush = u.JMAPPush
+	acc.CheckInterval = u.CheckInterval
+	acc.FolderMode = u.FolderMode
+	acc.IncludeFolders = u.IncludeFolders
+	acc.ExcludeFolders = u.ExcludeFolders
+	acc.IncludeKeyword = u.IncludeKeyword
+	acc.ExcludeKeyword = u.ExcludeKeyword
+	acc.IncludeEmail = u.IncludeEmail
+	acc.ExcludeEmail = u.ExcludeEmail
+	acc.Label = u.Label
+	acc.TitleTemplate = u.TitleTemplate
+	acc.BodyTemplate = u.BodyTemplate
+	acc.ShowSnippet = u.ShowSnippet
+	acc.DetectCodes = u.DetectCodes
+	acc.NormalizePlusTags = u.NormalizePlusTags
+	acc.FilterSets = u.FilterSets
+	acc.SieveScript = u.SieveScript
+	acc.WebmailURL = u.WebmailURL
+}
+
+// undoStack holds the steps that put things back when a later step of an
+// account update fails. They run in reverse order.
+type undoStack []func()
+
+func (s *undoStack) push(f func()) {
+	*s = append(*s, f)
+}
+
+func (s undoStack) run() {
+	for i := len(s) - 1; i >= 0; i-- {
+		s[i]()
+	}
+}
+
+// accountStateFiles are the notification history and JMAP sync state files,
+// which are named after the account email.
+func accountStateFiles(email string) []string {
+	return []string{
+		filepath.Join(historyDir, sanitizeFilename(email)+".json"),
+		filepath.Join(historyDir, sanitizeFilename(email)+"-jmap.json"),
+	}
+}
+
+// updateAccount applies u to acc. A new email or protocol moves the keyring
+// secret and the history and sync state along with it. If any step fails,
+// the steps already taken are undone and the account keeps its old settings.
+// The caller stops the monitor before and restarts it afterwards.
+func updateAccount(acc *AccountConfig, u accountUpdate) error {
+	old := currentAccountSettings(acc)
+	if u.Email == "" {
+		u.Email = old.Email
+	}
+	if u.Protocol == "" {
+		u.Protocol = old.Protocol
+	}
+	renamed := u.Email != old.Email
+	wasLocal, local := isLocalProtocol(old.Protocol), isLocalProtocol(u.Protocol)
+
+	if renamed {
+		for i := range config.Accounts {
+			if &config.Accounts[i] != acc && strings.EqualFold(config.Accounts[i].Email, u.Email) {
+				return fmt.Errorf("an account for %s already exists", u.Email)
+			}
+		}
+	}
+
+	var undo undoStack
+	fail := func(err error) error {
+		undo.run()
+		return err
+	}
+
+	if !local {
+		secret := u.Password
+		if secret == "" && wasLocal {
+			return fmt.Errorf("a password is required to switch to %s", strings.ToUpper(u.Protocol))
+		}
+		if secret == "" && renamed {
+			s, err := getPassword(old.Email)
+			if err != nil {
+				return fmt.Errorf("failed to get password from keyring: %v", err)
+			}
+			secret = s
+		}
+		if secret != "" {
+			previous, err := getPassword(u.Email)
+			hadPrevious := err == nil
+			if err := setPassword(u.Email, secret); err != nil {
+				return fail(fmt.Errorf("failed to store password in keyring: %v", err))
+			}
+			undo.push(func() {
+				if hadPrevious {
+					setPassword(u.Email, previous)
+				} else {
+					deletePassword(u.Email)
+				}
+			})
+		}
+	}
+
+	if renamed {
+		oldFiles, newFiles := accountStateFiles(old.Email), accountStateFiles(u.Email)
+		for i := range oldFiles {
+			src, dst := oldFiles[i], newFiles[i]
+			if _, err := os.Stat(src); os.IsNotExist(err) {
+				continue
+			}
+			if err := os.Rename(src, dst); err != nil {
+				return fail(fmt.Errorf("failed to move %s: %v", filepath.Base(src), err))
+			}
+			undo.push(func() { os.Rename(dst, src) })
+		}
+	}
+
+	// The sync position belongs to the old source. That includes the JMAP
+	// state on disk, which checkNewEmailsJMAP reloads whenever the one in
+	// memory is empty.
+	if u.Protocol != old.Protocol || u.Path != old.Path || u.Server != old.Server || u.Username != old.Username {
+		stateFile := accountStateFiles(u.Email)[1]
+		if data, err := os.ReadFile(stateFile); err == nil {
+			if err := os.Remove(stateFile); err != nil {
+				return fail(fmt.Errorf("failed to reset %s: %v", filepath.Base(stateFile), err))
+			}
+			undo.push(func() { os.WriteFile(stateFile, data, 0644) })
+		}
+
+		acc.mu.Lock()
+		offset, unread, modTime, jmapState := acc.mboxOffset, acc.mboxUnread, acc.mboxModTime, acc.jmapState
+		acc.mboxOffset, acc.mboxUnread, acc.mboxModTime, acc.jmapState = 0, 0, time.Time{}, ""
+		acc.mu.Unlock()
+		undo.push(func() {
+			acc.mu.Lock()
+			acc.mboxOffset, acc.mboxUnread, acc.mboxModTime, acc.jmapState = offset, unread, modTime, jmapState
+			acc.mu.Unlock()
+		})
+	}
+
+	u.apply(acc)
+	undo.push(func() { old.apply(acc) })
+
+	if err := saveConfig(); err != nil {
+		return fail(fmt.Errorf("failed to save config: %v", err))
+	}
+
+	if !wasLocal && (renamed || local) {
+		if err := deletePassword(old.Email); err != nil {
+			slog.Warn("Failed to delete password from keyring", "account", old.Email, "error", err)
+		}
+	}
+	if renamed {
+		renameNotificationAccount(old.Email, u.Email)
+		slog.Info("Account renamed", "from", old.Email, "to", u.Email)
+	}
+	if u.Protocol != old.Protocol {
+		acc.logger().Info("Account protocol changed", "from", old.Protocol, "to", u.Protocol)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+// useTempAppDir points the config file and the notification history at a
+// temporary directory for the duration of the test.
+func useTempAppDir(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	savedConfigFile, savedHistoryDir, savedAccounts := configFile, historyDir, config.Accounts
+	configFile = filepath.Join(dir, "config.json")
+	historyDir = filepath.Join(dir, "notification_history")
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		configFile, historyDir, config.Accounts = savedConfigFile, savedHistoryDir, savedAccounts
+	})
+}
+
+func TestUpdateAccountResetsJMAPState(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(u *accountUpdate)
+		reset  bool
+	}{
+		{"label", func(u *accountUpdate) { u.Label = "Work" }, false},
+		{"server", func(u *accountUpdate) { u.Server = "https://jmap.example.net" }, true},
+		{"username", func(u *accountUpdate) { u.Username = "other" }, true},
+		{"protocol", func(u *accountUpdate) { u.Protocol = "imap"; u.Server = "imap.example.com" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempAppDir(t)
+			config.Accounts = []AccountConfig{{
+				Email:    "jmap@example.com",
+				Protocol: "jmap",
+				Server:   "https://jmap.example.com",
+				Username: "jmap@example.com",
+			}}
+			acc := &config.Accounts[0]
+			if err := saveJMAPState(acc, "s1"); err != nil {
+				t.Fatal(err)
+			}
+			acc.jmapState = "s1"
+
+			u := currentAccountSettings(acc)
+			tt.change(&u)
+			if err := updateAccount(acc, u); err != nil {
+				t.Fatal(err)
+			}
+
+			want := "s1"
+			if tt.reset {
+				want = ""
+			}
+			if acc.jmapState != want {
+				t.Errorf("in-memory state = %q, want %q", acc.jmapState, want)
+			}
+			if got := loadJMAPState(acc); got != want {
+				t.Errorf("saved state = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestUpdateAccountRestoresJMAPStateOnFailure(t *testing.T) {
+	useTempAppDir(t)
+	config.Accounts = []AccountConfig{{Email: "jmap@example.com", Protocol: "jmap", Server: "https://jmap.example.com"}}
+	acc := &config.Accounts[0]
+	if err := saveJMAPState(acc, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	acc.jmapState = "s1"
+
+	// saveConfig fails, so the update is undone.
+	configFile = filepath.Join(t.TempDir(), "missing", "config.json")
+	u := currentAccountSettings(acc)
+	u.Server = "https://jmap.example.net"
+	if err := updateAccount(acc, u); err == nil {
+		t.Fatal("updateAccount succeeded without a config file")
+	}
+
+	if acc.Server != "https://jmap.example.com" || acc.jmapState != "s1" {
+		t.Errorf("account not restored: server %q, state %q", acc.Server, acc.jmapState)
+	}
+	if got := loadJMAPState(acc); got != "s1" {
+		t.Errorf("saved state = %q, want s1", got)
+	}
+}
+
+func TestWithAccountAfterDelete(t *testing.T) {
+	useTempAppDir(t)
+	keyring.MockInit()
+	config.Accounts = nil
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		config.Accounts = append(config.Accounts, AccountConfig{
+			ID:       newID(),
+			Email:    email,
+			Protocol: "maildir",
+			stopChan: make(chan bool, 1),
+		})
+	}
+	config.Accounts[2].heldNotifications = []heldNotification{{Subject: "held for c"}}
+	ids := accountIDs()
+
+	w := httptest.NewRecorder()
+	handleDeleteAccount(w, httptest.NewRequest("POST", "/api/accounts/delete", strings.NewReader(`{"index": 1}`)))
+	if w.Code != 200 {
+		t.Fatalf("delete failed: %d %s", w.Code, w.Body)
+	}
+
+	if withAccount(ids[1], func(*AccountConfig) {}) {
+		t.Error("deleted account still found")
+	}
+	var email, subject string
+	if !withAccount(ids[2], func(acc *AccountConfig) {
+		email = acc.Email
+		subject = acc.heldNotifications[0].Subject
+	}) {
+		t.Fatal("account c not found after deleting b")
+	}
+	if email != "c@example.com" || subject != "held for c" {
+		t.Errorf("ID of c resolves to %s holding %q", email, subject)
+	}
+}
+
+func TestUpdateAccountByIDAfterDelete(t *testing.T) {
+	useTempAppDir(t)
+	keyring.MockInit()
+	config.Accounts = nil
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		config.Accounts = append(config.Accounts, AccountConfig{
+			ID:            newID(),
+			Email:         email,
+			Protocol:      "maildir",
+			Path:          t.TempDir(),
+			CheckInterval: 60,
+			Paused:        true,
+			stopChan:      make(chan bool, 1),
+		})
+	}
+	c := config.Accounts[2]
+
+	w := httptest.NewRecorder()
+	handleDeleteAccount(w, httptest.NewRequest("POST", "/api/accounts/delete", strings.NewReader(`{"index": 0}`)))
+	if w.Code != 200 {
+		t.Fatalf("delete failed: %d %s", w.Code, w.Body)
+	}
+
+	update := func(u accountUpdate) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(u)
+		w := httptest.NewRecorder()
+		handleUpdateAccount(w, httptest.NewRequest("POST", "/api/accounts/update", bytes.NewReader(body)))
+		return w
+	}
+
+	// The dashboard still has c at index 2.
+	u := currentAccountSettings(&c)
+	u.ID, u.Index, u.Label = c.ID, 2, "Updated"
+	if w := update(u); w.Code != 200 {
+		t.Fatalf("update by ID failed: %d %s", w.Code, w.Body)
+	}
+	defer func() { config.Accounts[1].stopChan <- true }()
+	if config.Accounts[1].Label != "Updated" || config.Accounts[0].Label != "" {
+		t.Errorf("labels = %q, %q, want b unchanged and c updated", config.Accounts[0].Label, config.Accounts[1].Label)
+	}
+
+	u.ID = ""
+	if w := update(u); w.Code != http.StatusNotFound {
+		t.Errorf("update of a stale index = %d, want 404", w.Code)
+	}
+}
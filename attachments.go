@@ -0,0 +1,423 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message"
+	"github.com/knadh/go-pop3"
+)
+
+const (
+	maxCalendarBytes = 64 * 1024
+	// POP3 reads multipart messages as far as this many body lines to find
+	// their parts, so large attachments are never downloaded in full.
+	pop3PartsLines = 2000
+)
+
+// Headers Sieve scripts can test for what the MIME structure says, e.g.
+// header :matches "x-email-monitor-attachment" "*.pdf". There is one value
+// per attachment; the invite header holds the iTIP method (REQUEST, CANCEL,
+// ...) and exists for every message with a calendar part.
+const (
+	attachmentHeaderName     = "X-Email-Monitor-Attachment"
+	attachmentTypeHeaderName = "X-Email-Monitor-Attachment-Type"
+	inviteHeaderName         = "X-Email-Monitor-Invite"
+)
+
+type attachmentInfo struct {
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+	Size int64  `json:"size"` // decoded bytes, estimated from BODYSTRUCTURE for IMAP
+}
+
+// messageParts is what the MIME structure says about a message.
+type messageParts struct {
+	Attachments    []attachmentInfo
+	Size           int64 // the whole message
+	Calendar       bool  // has a text/calendar part
+	CalendarMethod string
+	Invite         *calendarInvite // parsed from the calendar part, if it was read
+}
+
+// calendarInvite is the first event of an iCalendar part.
+type calendarInvite struct {
+	Method    string    `json:"method,omitempty"` // REQUEST, CANCEL, REPLY, ...
+	Summary   string    `json:"summary,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	AllDay    bool      `json:"all_day,omitempty"`
+	Location  string    `json:"location,omitempty"`
+	Organizer string    `json:"organizer,omitempty"`
+}
+
+var icsUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func (inv *calendarInvite) String() string {
+	var parts []string
+	if inv.Method == "CANCEL" {
+		parts = append(parts, "Cancelled")
+	}
+	if !inv.Start.IsZero() {
+		start := inv.Start.Local()
+		when := start.Format("Mon 2 Jan 15:04")
+		switch {
+		case inv.AllDay:
+			when = inv.Start.Format("Mon 2 Jan") + " (all day)"
+		case inv.End.IsZero():
+		case inv.End.Local().YearDay() == start.YearDay() && inv.End.Year() == start.Year():
+			when += "–" + inv.End.Local().Format("15:04")
+		default:
+			when += " – " + inv.End.Local().Format("Mon 2 Jan 15:04")
+		}
+		parts = append(parts, when)
+	}
+	if inv.Summary != "" {
+		parts = append(parts, inv.Summary)
+	}
+	if inv.Location != "" {
+		parts = append(parts, "@ "+inv.Location)
+	}
+	if inv.Organizer != "" {
+		parts = append(parts, "organizer "+inv.Organizer)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// attachmentNames lists the attachments by name, or by type when unnamed.
+func (p messageParts) attachmentNames() []string {
+	names := make([]string, len(p.Attachments))
+	for i, a := range p.Attachments {
+		names[i] = a.Name
+		if names[i] == "" {
+			names[i] = a.Type
+		}
+	}
+	return names
+}
+
+// headerValues returns the values of the synthetic headers above, and false
+// for any other header.
+func (p *messageParts) headerValues(name string) ([]string, bool) {
+	switch {
+	case strings.EqualFold(name, attachmentHeaderName):
+	case strings.EqualFold(name, attachmentTypeHeaderName):
+	case strings.EqualFold(name, inviteHeaderName):
+	default:
+		return nil, false
+	}
+	if p == nil {
+		return nil, true
+	}
+
+	var values []string
+	switch {
+	case strings.EqualFold(name, attachmentHeaderName):
+		values = p.attachmentNames()
+	case strings.EqualFold(name, attachmentTypeHeaderName):
+		for _, a := range p.Attachments {
+			values = append(values, a.Type)
+		}
+	case p.Calendar:
+		values = []string{p.CalendarMethod}
+	}
+	return values, true
+}
+
+func isPartsHeader(name string) bool {
+	_, ok := (*messageParts)(nil).headerValues(name)
+	return ok
+}
+
+// matchAttachmentType reports whether an attachment matches a MIME type
+// ("application/pdf"), a type glob ("image/*") or a file name glob ("*.pdf",
+// or ".pdf" for the extension).
+func matchAttachmentType(pattern string, a attachmentInfo) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if strings.HasPrefix(pattern, ".") {
+		pattern = "*" + pattern
+	}
+	if strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, strings.ToLower(a.Type))
+		return ok
+	}
+	ok, _ := path.Match(pattern, strings.ToLower(a.Name))
+	return ok
+}
+
+func isCalendarType(mediaType string) bool {
+	return mediaType == "text/calendar" || mediaType == "application/ics"
+}
+
+// imapCalendarPart is a calendar part to fetch once a message has matched.
+type imapCalendarPart struct {
+	index int // into the matches
+	uid   uint32
+	path  []int
+	part  *imap.BodyStructure
+}
+
+// imapMessageParts reads the attachments from BODYSTRUCTURE and returns the
+// path of the first calendar part, if any.
+func imapMessageParts(bs *imap.BodyStructure, size uint32) (messageParts, []int, *imap.BodyStructure) {
+	parts := messageParts{Size: int64(size)}
+	if bs == nil {
+		return parts, nil, nil
+	}
+
+	var calendarPath []int
+	var calendarPart *imap.BodyStructure
+	bs.Walk(func(partPath []int, part *imap.BodyStructure) bool {
+		mediaType := strings.ToLower(part.MIMEType + "/" + part.MIMESubType)
+		if strings.HasPrefix(mediaType, "multipart/") {
+			return true
+		}
+
+		if isCalendarType(mediaType) && calendarPart == nil {
+			parts.Calendar = true
+			parts.CalendarMethod = strings.ToUpper(part.Params["method"])
+			calendarPath, calendarPart = partPath, part
+		}
+
+		name, _ := part.Filename()
+		disposition := strings.ToLower(part.Disposition)
+		if disposition == "attachment" || (name != "" && part.Id == "") || mediaType == "message/rfc822" {
+			if name == "" && part.Envelope != nil {
+				name = part.Envelope.Subject + ".eml"
+			}
+			size := int64(part.Size)
+			if strings.EqualFold(part.Encoding, "base64") {
+				size = size * 3 / 4
+			}
+			parts.Attachments = append(parts.Attachments, attachmentInfo{Name: name, Type: mediaType, Size: size})
+		}
+		// An attached message's own attachments belong to it.
+		return false
+	})
+	return parts, calendarPath, calendarPart
+}
+
+// fetchIMAPInvites reads the calendar parts of matched messages with
+// BODY.PEEK, so they stay unread.
+func fetchIMAPInvites(c *client.Client, matches []matchedMessage, parts []imapCalendarPart) {
+	for _, p := range parts {
+		section := &imap.BodySectionName{
+			BodyPartName: imap.BodyPartName{Path: p.path},
+			Peek:         true,
+			Partial:      []int{0, maxCalendarBytes},
+		}
+		seqset := new(imap.SeqSet)
+		seqset.AddNum(p.uid)
+
+		messages := make(chan *imap.Message, 1)
+		if err := c.UidFetch(seqset, []imap.FetchItem{section.FetchItem()}, messages); err != nil {
+			continue
+		}
+		msg := <-messages
+		if msg == nil {
+			continue
+		}
+
+		var h message.Header
+		h.Set("Content-Type", "text/calendar; charset="+p.part.Params["charset"])
+		h.Set("Content-Transfer-Encoding", p.part.Encoding)
+		if body := msg.GetBody(section); body != nil {
+			matches[p.index].Parts.Invite = readCalendar(h, body)
+		}
+	}
+}
+
+// readCalendar decodes a calendar part and parses its first event.
+func readCalendar(h message.Header, body io.Reader) *calendarInvite {
+	e, err := message.New(h, body)
+	if e == nil || (err != nil && !message.IsUnknownCharset(err)) {
+		return nil
+	}
+	data, _ := io.ReadAll(io.LimitReader(e.Body, maxCalendarBytes))
+	return parseCalendar(string(data))
+}
+
+// messageContent walks a parsed message once and returns its first text
+// part, like messageText, and what its parts say. The entity is consumed.
+func messageContent(e *message.Entity) (string, messageParts) {
+	var parts messageParts
+	var plainText, htmlText string
+	dec := new(mime.WordDecoder)
+
+	e.Walk(func(_ []int, part *message.Entity, err error) error {
+		if err != nil && !message.IsUnknownCharset(err) {
+			return nil
+		}
+		mediaType, params, _ := part.Header.ContentType()
+		if mediaType == "" {
+			mediaType = "text/plain"
+		}
+		if strings.HasPrefix(mediaType, "multipart/") {
+			return nil
+		}
+
+		disposition, dispositionParams, _ := part.Header.ContentDisposition()
+		name := dispositionParams["filename"]
+		if name == "" {
+			name = params["name"]
+		}
+		if decoded, err := dec.DecodeHeader(name); err == nil {
+			name = decoded
+		}
+		attachment := disposition == "attachment" || (name != "" && part.Header.Get("Content-Id") == "") || mediaType == "message/rfc822"
+
+		switch {
+		case isCalendarType(mediaType) && !parts.Calendar:
+			parts.Calendar = true
+			parts.CalendarMethod = strings.ToUpper(params["method"])
+			data, _ := io.ReadAll(io.LimitReader(part.Body, maxCalendarBytes))
+			parts.Invite = parseCalendar(string(data))
+			if attachment {
+				parts.Attachments = append(parts.Attachments, attachmentInfo{Name: name, Type: mediaType, Size: int64(len(data))})
+			}
+		case attachment:
+			// The part may be cut off by TOP; count what was received.
+			size, _ := io.Copy(io.Discard, part.Body)
+			parts.Attachments = append(parts.Attachments, attachmentInfo{Name: name, Type: mediaType, Size: size})
+		case mediaType == "text/plain" && plainText == "":
+			data, _ := io.ReadAll(io.LimitReader(part.Body, snippetFetchBytes*2))
+			plainText = string(data)
+		case mediaType == "text/html" && htmlText == "":
+			data, _ := io.ReadAll(io.LimitReader(part.Body, snippetFetchBytes*2))
+			htmlText = string(data)
+		}
+		return nil
+	})
+
+	if parts.Invite != nil && parts.CalendarMethod == "" {
+		parts.CalendarMethod = parts.Invite.Method
+	}
+	if plainText != "" {
+		return plainText, parts
+	}
+	return stripHTML(htmlText), parts
+}
+
+// pop3MessageParts reads the MIME structure of message i. Single-part
+// messages are described by their header alone; multipart ones are read
+// with TOP as far as pop3PartsLines, so parts that start after that are
+// missed. Nothing is deleted.
+func pop3MessageParts(c *pop3.Conn, i int, header message.Header, size int64) messageParts {
+	mediaType, params, _ := header.ContentType()
+	disposition, _, _ := header.ContentDisposition()
+	if !strings.HasPrefix(mediaType, "multipart/") && !isCalendarType(mediaType) && disposition != "attachment" {
+		return messageParts{Size: size}
+	}
+
+	e, err := c.Top(i, pop3PartsLines)
+	if err != nil || e == nil {
+		parts := messageParts{Size: size}
+		if isCalendarType(mediaType) {
+			parts.Calendar = true
+			parts.CalendarMethod = strings.ToUpper(params["method"])
+		}
+		return parts
+	}
+	_, parts := messageContent(e)
+	parts.Size = size
+	return parts
+}
+
+// parseCalendar returns the first VEVENT of an iCalendar object, or nil.
+func parseCalendar(data string) *calendarInvite {
+	data = strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(data)
+
+	inv := &calendarInvite{}
+	inEvent, found := false, false
+	for _, line := range strings.Split(data, "\n") {
+		name, params, value := parseICSLine(strings.TrimRight(line, "\r"))
+		switch {
+		case name == "METHOD" && !inEvent:
+			inv.Method = strings.ToUpper(value)
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			inEvent, found = true, true
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			return inv
+		case !inEvent:
+		case name == "SUMMARY":
+			inv.Summary = icsUnescaper.Replace(value)
+		case name == "LOCATION":
+			inv.Location = icsUnescaper.Replace(value)
+		case name == "DTSTART":
+			inv.Start, inv.AllDay = icsTime(params, value)
+		case name == "DTEND":
+			inv.End, _ = icsTime(params, value)
+		case name == "ORGANIZER":
+			address := value
+			if len(address) > 7 && strings.EqualFold(address[:7], "mailto:") {
+				address = address[7:]
+			}
+			inv.Organizer = address
+			if cn := params["CN"]; cn != "" {
+				inv.Organizer = fmt.Sprintf("%s <%s>", cn, address)
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	return inv
+}
+
+// parseICSLine splits `NAME;PARAM=value;PARAM="quoted":value`.
+func parseICSLine(line string) (string, map[string]string, string) {
+	var fields []string
+	quoted := false
+	colon, start := -1, 0
+	for i := 0; i < len(line) && colon < 0; i++ {
+		switch {
+		case line[i] == '"':
+			quoted = !quoted
+		case line[i] == ';' && !quoted:
+			fields = append(fields, line[start:i])
+			start = i + 1
+		case line[i] == ':' && !quoted:
+			fields = append(fields, line[start:i])
+			colon = i
+		}
+	}
+	if colon < 0 {
+		return "", nil, ""
+	}
+
+	params := make(map[string]string)
+	for _, f := range fields[1:] {
+		if k, v, ok := strings.Cut(f, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return strings.ToUpper(fields[0]), params, line[colon+1:]
+}
+
+// icsTime parses a DATE or DATE-TIME value. Floating times and unknown
+// TZIDs, such as Windows zone names, are read as local time.
+func icsTime(params map[string]string, value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, time.Local)
+		return t, err == nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, _ := time.Parse("20060102T150405Z", value)
+		return t, false
+	}
+	loc := time.Local
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, _ := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/knadh/go-pop3"
+)
+
+func TestParseCalendar(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want *calendarInvite
+	}{
+		{
+			name: "request",
+			data: "BEGIN:VCALENDAR\r\nMETHOD:request\r\nBEGIN:VEVENT\r\n" +
+				"SUMMARY:Planning\\, Q3\r\n" +
+				"LOCATION:Room 4B\r\n" +
+				"DTSTART:20251021T120000Z\r\n" +
+				"DTEND:20251021T130000Z\r\n" +
+				"ORGANIZER;CN=\"Doe, Jane\":mailto:jane@example.com\r\n" +
+				"END:VEVENT\r\nEND:VCALENDAR\r\n",
+			want: &calendarInvite{
+				Method:    "REQUEST",
+				Summary:   "Planning, Q3",
+				Location:  "Room 4B",
+				Start:     time.Date(2025, 10, 21, 12, 0, 0, 0, time.UTC),
+				End:       time.Date(2025, 10, 21, 13, 0, 0, 0, time.UTC),
+				Organizer: "Doe, Jane <jane@example.com>",
+			},
+		},
+		{
+			name: "folded lines and all-day event",
+			data: "BEGIN:VCALENDAR\nMETHOD:CANCEL\nBEGIN:VEVENT\nSUMMARY:Team\n  offsite\n" +
+				"DTSTART;VALUE=DATE:20251224\nORGANIZER:MAILTO:boss@example.com\nEND:VEVENT\n",
+			want: &calendarInvite{
+				Method:    "CANCEL",
+				Summary:   "Team offsite",
+				Start:     time.Date(2025, 12, 24, 0, 0, 0, 0, time.Local),
+				AllDay:    true,
+				Organizer: "boss@example.com",
+			},
+		},
+		{
+			name: "only the first event",
+			data: "BEGIN:VEVENT\nSUMMARY:First\nEND:VEVENT\nBEGIN:VEVENT\nSUMMARY:Second\nEND:VEVENT\n",
+			want: &calendarInvite{Summary: "First"},
+		},
+		{
+			name: "no event",
+			data: "BEGIN:VCALENDAR\nMETHOD:PUBLISH\nBEGIN:VTODO\nSUMMARY:Task\nEND:VTODO\nEND:VCALENDAR\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseCalendar(tt.data)
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("parseCalendar = %+v, want %+v", got, tt.want)
+			}
+			if got == nil {
+				return
+			}
+			if got.Method != tt.want.Method || got.Summary != tt.want.Summary || got.Location != tt.want.Location ||
+				got.Organizer != tt.want.Organizer || got.AllDay != tt.want.AllDay ||
+				!got.Start.Equal(tt.want.Start) || !got.End.Equal(tt.want.End) {
+				t.Errorf("parseCalendar = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestICSTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	tests := []struct {
+		line       string
+		want       time.Time
+		wantAllDay bool
+	}{
+		{"DTSTART:20251021T120000Z", time.Date(2025, 10, 21, 12, 0, 0, 0, time.UTC), false},
+		{"DTSTART;TZID=Europe/Berlin:20251021T120000", time.Date(2025, 10, 21, 12, 0, 0, 0, berlin), false},
+		{"DTSTART;TZID=\"W. Europe Standard Time\":20251021T120000", time.Date(2025, 10, 21, 12, 0, 0, 0, time.Local), false},
+		{"DTSTART:20251021T120000", time.Date(2025, 10, 21, 12, 0, 0, 0, time.Local), false},
+		{"DTSTART;VALUE=DATE:20251021", time.Date(2025, 10, 21, 0, 0, 0, 0, time.Local), true},
+		{"DTSTART:20251021", time.Date(2025, 10, 21, 0, 0, 0, 0, time.Local), true},
+	}
+	for _, tt := range tests {
+		_, params, value := parseICSLine(tt.line)
+		got, allDay := icsTime(params, value)
+		if !got.Equal(tt.want) || allDay != tt.wantAllDay {
+			t.Errorf("icsTime(%q) = %v, %v, want %v, %v", tt.line, got, allDay, tt.want, tt.wantAllDay)
+		}
+	}
+}
+
+// pipeDialer hands go-pop3 one end of an in-memory connection.
+type pipeDialer struct{ conn net.Conn }
+
+func (d pipeDialer) Dial(network, address string) (net.Conn, error) { return d.conn, nil }
+
+func TestPOP3MessagePartsUsesTop(t *testing.T) {
+	const msg = "From: a@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n" +
+		"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=report.pdf\r\n\r\nJVBERi0x\r\n" +
+		"--b--\r\n"
+
+	serverConn, clientConn := net.Pipe()
+	commands := make(chan string, 10)
+	go func() {
+		defer serverConn.Close()
+		r := bufio.NewReader(serverConn)
+		fmt.Fprint(serverConn, "+OK ready\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimRight(line, "\r\n")
+			commands <- cmd
+			switch {
+			case cmd == fmt.Sprintf("TOP 1 %d", pop3PartsLines):
+				fmt.Fprintf(serverConn, "+OK\r\n%s.\r\n", msg)
+			case cmd == "QUIT":
+				fmt.Fprint(serverConn, "+OK bye\r\n")
+				return
+			default:
+				fmt.Fprint(serverConn, "-ERR not allowed\r\n")
+			}
+		}
+	}()
+
+	c, err := pop3.New(pop3.Opt{Host: "pop.example.com", Port: 110, Dialer: pipeDialer{clientConn}}).NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header message.Header
+	header.Set("Content-Type", "multipart/mixed; boundary=b")
+	parts := pop3MessageParts(c, 1, header, 4096)
+	c.Quit()
+	close(commands)
+
+	for cmd := range commands {
+		if strings.HasPrefix(cmd, "RETR") {
+			t.Errorf("message downloaded with %s", cmd)
+		}
+	}
+	if names := parts.attachmentNames(); len(names) != 1 || names[0] != "report.pdf" {
+		t.Errorf("attachments = %v, want [report.pdf]", names)
+	}
+	if parts.Size != 4096 {
+		t.Errorf("size = %d", parts.Size)
+	}
+}
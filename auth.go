@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	sessionCookieName = "email_monitor_session"
+	csrfHeaderName    = "X-CSRF-Token"
+)
+
+var (
+	apiToken   string
+	sessions   = map[string]string{} // session ID -> CSRF token
+	sessionsMu sync.Mutex
+)
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		fatal("Failed to generate random token", "error", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func apiTokenFile() string {
+	return filepath.Join(appDir, "api_token")
+}
+
+// loadAPIToken reads the per-install API token, creating it on first run. The
+// file is only readable by the current user.
+func loadAPIToken() error {
+	data, err := os.ReadFile(apiTokenFile())
+	if err == nil && len(strings.TrimSpace(string(data))) >= 32 {
+		apiToken = strings.TrimSpace(string(data))
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read API token: %v", err)
+	}
+
+	apiToken = randomToken()
+	if err := os.WriteFile(apiTokenFile(), []byte(apiToken+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write API token: %v", err)
+	}
+	slog.Info("Created API token", "path", apiTokenFile())
+	return nil
+}
+
+func tokensEqual(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// dashboardAuthURL is opened from the tray; it exchanges the API token for a
+// session cookie and redirects to the dashboard.
+func dashboardAuthURL() string {
+	return webServerURL + "/auth?token=" + apiToken
+}
+
+// allowedHost rejects requests whose Host header is not the loopback address
+// we listen on, which defeats DNS rebinding. In LAN mode any host name is
+// accepted; every request still has to be authenticated.
+func allowedHost(host string) bool {
+	if webSettings().AllowLAN {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, h := range []string{"127.0.0.1", "localhost", "[::1]"} {
+		if host == fmt.Sprintf("%s:%d", h, webServerPort) {
+			return true
+		}
+	}
+	return host == strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(webServerURL, "http://"), "https://"))
+}
+
+// allowedOrigin only accepts requests made by pages served from this host.
+func allowedOrigin(origin, host string) bool {
+	return strings.EqualFold(origin, webSettings().scheme()+"://"+host)
+}
+
+func sessionCSRFToken(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return "", false
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	csrf, ok := sessions[cookie.Value]
+	return csrf, ok
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requireAuth guards every route. API clients authenticate with
+// "Authorization: Bearer <token>"; the dashboard uses the session cookie plus
+// an X-CSRF-Token header on anything that changes state.
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host) {
+			http.Error(w, "Invalid Host header", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && !allowedOrigin(origin, r.Host) {
+			http.Error(w, "Invalid Origin header", http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Path == "/auth" {
+			handleAuth(w, r)
+			return
+		}
+
+		if tokensEqual(bearerToken(r), apiToken) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if csrf, ok := sessionCSRFToken(r); ok {
+			if !isSafeMethod(r.Method) && !tokensEqual(r.Header.Get(csrfHeaderName), csrf) {
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="email-monitor"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Unauthorized"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `<!DOCTYPE html><html><head><meta charset="UTF-8"><title>Email Monitor</title></head>
+<body style="font-family: sans-serif; padding: 40px; color: #333;">
+<h2>🔒 Not signed in</h2>
+<p>Open the dashboard from the <strong>Open Dashboard</strong> item in the Email Monitor tray menu.</p>
+</body></html>`)
+	})
+}
+
+func handleAuth(w http.ResponseWriter, r *http.Request) {
+	if !tokensEqual(r.URL.Query().Get("token"), apiToken) {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID := randomToken()
+	sessionsMu.Lock()
+	sessions[sessionID] = randomToken()
+	sessionsMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Redirect so the token does not stay in the address bar.
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
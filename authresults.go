@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// authHeaderName is a header Sieve scripts can test for the verdict, e.g.
+// header :is "x-email-monitor-auth" "suspicious". Its values are the status
+// followed by the warnings.
+const authHeaderName = "X-Email-Monitor-Auth"
+
+// TrustSettings configures the sender checks behind the phishing warnings.
+type TrustSettings struct {
+	// TrustedSenders are "Name <address>", addresses, @domain or *.domain.
+	// Mail claiming to be from them must pass DMARC, and their names and
+	// domains are protected against impersonation.
+	TrustedSenders []string `json:"trusted_senders,omitempty"`
+	// AuthservIDs limits Authentication-Results to the ones added by these
+	// servers. By default only the topmost header is used.
+	AuthservIDs []string `json:"authserv_ids,omitempty"`
+	// ARCSealers are the domains (d= of ARC-Seal) trusted to report how mail
+	// they forwarded, such as mailing lists, was authenticated.
+	ARCSealers []string `json:"arc_sealers,omitempty"`
+}
+
+// authVerdict is how far the From address of a message can be believed.
+type authVerdict struct {
+	SPF      string   `json:"spf,omitempty"`
+	DKIM     string   `json:"dkim,omitempty"`
+	DMARC    string   `json:"dmarc,omitempty"`
+	ARC      bool     `json:"arc,omitempty"` // results from the first ARC hop
+	Status   string   `json:"status"`        // "verified", "unverified" or "suspicious"
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type trustedSender struct {
+	name    string
+	pattern string // a sender pattern, see matchSender
+	domain  string
+}
+
+var (
+	nameAddressPattern = regexp.MustCompile(`(?i)[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}`)
+
+	// confusables maps characters that look like ASCII letters onto them.
+	confusables = strings.NewReplacer(
+		"а", "a", "е", "e", "о", "o", "р", "p", "с", "c", "у", "y", "х", "x",
+		"і", "i", "ј", "j", "ѕ", "s", "ԁ", "d", "һ", "h", "ԛ", "q", "ԝ", "w",
+		"α", "a", "ο", "o", "ρ", "p", "ν", "v", "κ", "k", "ι", "i",
+		"ɡ", "g", "ł", "l", "ı", "i",
+		"0", "o", "1", "l", "i", "l", "rn", "m", "vv", "w", "-", "",
+	)
+)
+
+func validateTrustSettings(t *TrustSettings) error {
+	if t == nil {
+		return nil
+	}
+	for _, entry := range t.TrustedSenders {
+		if _, ok := parseTrustedSender(entry); !ok {
+			return fmt.Errorf("invalid trusted sender %q", entry)
+		}
+	}
+	return nil
+}
+
+func parseTrustedSender(entry string) (trustedSender, bool) {
+	entry = strings.TrimSpace(entry)
+	switch {
+	case strings.HasPrefix(entry, "@") && len(entry) > 1:
+		return trustedSender{pattern: entry, domain: strings.ToLower(entry[1:])}, true
+	case strings.HasPrefix(entry, "*.") && len(entry) > 2:
+		return trustedSender{pattern: entry, domain: strings.ToLower(entry[2:])}, true
+	}
+	addr, err := mail.ParseAddress(entry)
+	if err != nil {
+		return trustedSender{}, false
+	}
+	return trustedSender{
+		name:    addr.Name,
+		pattern: addr.Address,
+		domain:  senderDomain(strings.ToLower(addr.Address)),
+	}, true
+}
+
+func trustedSenders() []trustedSender {
+	if config.Trust == nil {
+		return nil
+	}
+	var senders []trustedSender
+	for _, entry := range config.Trust.TrustedSenders {
+		if t, ok := parseTrustedSender(entry); ok {
+			senders = append(senders, t)
+		}
+	}
+	return senders
+}
+
+// assessSender checks the From address against the Authentication-Results
+// the receiving server added and against the trusted senders.
+func assessSender(in filterInput) authVerdict {
+	var v authVerdict
+	results, arc := authResults(in.Header)
+	v.SPF, v.DKIM, v.DMARC, v.ARC = results["spf"], results["dkim"], results["dmarc"], arc
+
+	switch {
+	case v.DMARC == "fail":
+		v.Warnings = append(v.Warnings, "DMARC fail")
+	case v.DMARC != "pass" && v.SPF == "fail" && v.DKIM != "pass":
+		v.Warnings = append(v.Warnings, "SPF fail")
+	case v.DMARC != "pass" && v.DKIM == "fail" && v.SPF != "pass":
+		v.Warnings = append(v.Warnings, "DKIM fail")
+	}
+
+	if len(in.Senders) > 0 {
+		// The first sender is the From address when there is one.
+		v.Warnings = append(v.Warnings, senderWarnings(in.Senders[0], v.DMARC == "pass")...)
+	}
+
+	switch {
+	case len(v.Warnings) > 0:
+		v.Status = "suspicious"
+	case v.DMARC == "pass":
+		v.Status = "verified"
+	default:
+		v.Status = "unverified"
+	}
+	return v
+}
+
+func senderWarnings(from senderAddress, verified bool) []string {
+	var warnings []string
+	address := strings.ToLower(from.Address)
+	domain := senderDomain(address)
+
+	if shown := nameAddressPattern.FindString(from.Name); shown != "" && !strings.EqualFold(shown, address) {
+		warnings = append(warnings, "display name shows "+shown)
+	}
+
+	trusted := trustedSenders()
+	domainTrusted := false
+	for _, t := range trusted {
+		if domain != "" && (domain == t.domain || strings.HasSuffix(domain, "."+t.domain)) {
+			domainTrusted = true
+		}
+		if matchSender(t.pattern, senderAddress{Address: address}, true) {
+			if !verified {
+				warnings = append(warnings, "claims trusted sender "+address+" without a DMARC pass")
+			}
+			return warnings
+		}
+	}
+
+	for _, t := range trusted {
+		if t.name != "" && strings.EqualFold(strings.TrimSpace(from.Name), t.name) {
+			warnings = append(warnings, "uses the name of trusted sender "+t.name)
+			break
+		}
+	}
+	if domain != "" && !domainTrusted {
+		for _, t := range trusted {
+			if lookalikeDomain(domain, t.domain) {
+				warnings = append(warnings, "lookalike of "+t.domain)
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// authResults returns the method results of the Authentication-Results
+// header added by the receiving server. When DMARC did not pass but the
+// server validated an ARC chain whose first hop was sealed by a trusted
+// sealer, the results recorded by that hop are used instead, so mail
+// forwarded by a mailing list is judged by how it arrived there.
+func authResults(header func(string) []string) (map[string]string, bool) {
+	if header == nil {
+		return nil, false
+	}
+	var results map[string]string
+	for _, value := range header("Authentication-Results") {
+		id, r := parseAuthResults(value)
+		if config.Trust != nil && len(config.Trust.AuthservIDs) > 0 && !containsFold(config.Trust.AuthservIDs, id) {
+			continue
+		}
+		results = r
+		break
+	}
+	if results["dmarc"] == "pass" || results["arc"] != "pass" || config.Trust == nil || len(config.Trust.ARCSealers) == 0 {
+		return results, false
+	}
+
+	first := 0
+	var arcResults map[string]string
+	for _, value := range header("ARC-Authentication-Results") {
+		instance, rest, _ := strings.Cut(value, ";")
+		n, ok := arcInstance(instance)
+		if ok && (first == 0 || n < first) {
+			first = n
+			_, arcResults = parseAuthResults(rest)
+		}
+	}
+	if arcResults == nil {
+		return results, false
+	}
+	for _, seal := range header("ARC-Seal") {
+		tags := headerTags(seal)
+		if n, ok := arcInstance("i=" + tags["i"]); ok && n == first && containsFold(config.Trust.ARCSealers, tags["d"]) {
+			return arcResults, true
+		}
+	}
+	return results, false
+}
+
+func arcInstance(tag string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "i=")))
+	return n, err == nil && n > 0
+}
+
+// headerTags parses a "tag=value; tag=value" list such as ARC-Seal.
+func headerTags(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(value, ";") {
+		if tag, v, ok := strings.Cut(part, "="); ok {
+			tags[strings.ToLower(strings.TrimSpace(tag))] = strings.Join(strings.Fields(v), "")
+		}
+	}
+	return tags
+}
+
+// parseAuthResults parses an Authentication-Results value (RFC 8601) into
+// the authserv-id and the result of each method. A passing DKIM signature
+// wins over failing ones.
+func parseAuthResults(value string) (string, map[string]string) {
+	parts := strings.Split(stripHeaderComments(value), ";")
+	id := ""
+	if fields := strings.Fields(parts[0]); len(fields) > 0 {
+		id = strings.ToLower(fields[0])
+	}
+
+	results := make(map[string]string)
+	for _, part := range parts[1:] {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		method, result, ok := strings.Cut(strings.ToLower(fields[0]), "=")
+		if !ok {
+			continue
+		}
+		method, _, _ = strings.Cut(method, "/")
+		if results[method] == "pass" {
+			continue
+		}
+		results[method] = result
+	}
+	return id, results
+}
+
+// stripHeaderComments removes (comments), which may nest, outside quoted
+// strings.
+func stripHeaderComments(s string) string {
+	var b strings.Builder
+	depth := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			if depth == 0 {
+				b.WriteString(s[i : i+2])
+			}
+			i++
+			continue
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+			continue
+		case c == ')' && !quoted && depth > 0:
+			depth--
+			continue
+		}
+		if depth == 0 {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookalikeDomain reports whether domain imitates trusted: the same letters
+// after decoding punycode and folding confusable characters, one typo away,
+// or the trusted domain used as a subdomain ("example.com.evil.net").
+func lookalikeDomain(domain, trusted string) bool {
+	if domain == trusted || trusted == "" {
+		return false
+	}
+	if strings.HasPrefix(domain, trusted+".") {
+		return true
+	}
+
+	a, b := domainSkeleton(domain), domainSkeleton(trusted)
+	if a == b {
+		return true
+	}
+
+	// Look for a typo in the labels the trusted domain has, without the
+	// top-level domain, so mail.paypall.com imitates paypal.com. Short names
+	// are too close to each other to tell typos apart.
+	labels := strings.Split(a, ".")
+	if n := strings.Count(b, ".") + 1; len(labels) > n {
+		labels = labels[len(labels)-n:]
+	}
+	a = strings.Join(labels, ".")
+	if i := strings.LastIndex(a, "."); i >= 0 {
+		a = a[:i]
+	}
+	if i := strings.LastIndex(b, "."); i >= 0 {
+		b = b[:i]
+	}
+	return utf8.RuneCountInString(b) >= 5 && editDistance(a, b) <= 1
+}
+
+func domainSkeleton(domain string) string {
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if strings.HasPrefix(label, "xn--") {
+			if decoded, ok := punycodeDecode(label[4:]); ok {
+				labels[i] = decoded
+			}
+		}
+	}
+	return confusables.Replace(strings.ToLower(strings.Join(labels, ".")))
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// adjacent characters between a and b.
+func editDistance(a, b string) int {
+	r, s := []rune(a), []rune(b)
+	d := make([][]int, len(r)+1)
+	for i := range d {
+		d[i] = make([]int, len(s)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(r); i++ {
+		for j := 1; j <= len(s); j++ {
+			cost := 1
+			if r[i-1] == s[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && r[i-1] == s[j-2] && r[i-2] == s[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(r)][len(s)]
+}
+
+// punycodeDecode decodes the part of an IDN label after "xn--" (RFC 3492).
+func punycodeDecode(s string) (string, bool) {
+	const (
+		base        = 36
+		tmin        = 1
+		tmax        = 26
+		initialBias = 72
+		initialN    = 128
+	)
+
+	var output []rune
+	if pos := strings.LastIndex(s, "-"); pos >= 0 {
+		for _, r := range s[:pos] {
+			if r >= utf8.RuneSelf {
+				return "", false
+			}
+			output = append(output, r)
+		}
+		s = s[pos+1:]
+	}
+
+	n, i, bias := initialN, 0, initialBias
+	for k := 0; k < len(s); {
+		oldi, w := i, 1
+		for j := base; ; j += base {
+			if k >= len(s) {
+				return "", false
+			}
+			c := s[k]
+			k++
+			var digit int
+			switch {
+			case c >= 'a' && c <= 'z':
+				digit = int(c - 'a')
+			case c >= 'A' && c <= 'Z':
+				digit = int(c - 'A')
+			case c >= '0' && c <= '9':
+				digit = int(c-'0') + 26
+			default:
+				return "", false
+			}
+			i += digit * w
+			t := min(max(j-bias, tmin), tmax)
+			if digit < t {
+				break
+			}
+			w *= base - t
+			if i > 1<<30 || w > 1<<30 {
+				return "", false
+			}
+		}
+		bias = punycodeAdapt(i-oldi, len(output)+1, oldi == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > utf8.MaxRune {
+			return "", false
+		}
+		output = append(output[:i], append([]rune{rune(n)}, output[i:]...)...)
+		i++
+	}
+	return string(output), true
+}
+
+func punycodeAdapt(delta, numPoints int, first bool) int {
+	const (
+		base = 36
+		tmin = 1
+		tmax = 26
+		skew = 38
+		damp = 700
+	)
+	if first {
+		delta /= damp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((base-tmin)*tmax)/2 {
+		delta /= base - tmin
+		k += base
+	}
+	return k + (base-tmin+1)*delta/(delta+skew)
+}
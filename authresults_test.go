@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAuthResults(t *testing.T) {
+	tests := []struct {
+		value   string
+		id      string
+		results map[string]string
+	}{
+		{
+			"mx.google.com; dkim=pass header.i=@example.com; spf=pass (google.com: domain of a@example.com designates 192.0.2.1 as permitted sender) smtp.mailfrom=a@example.com; dmarc=pass (p=REJECT sp=REJECT dis=NONE) header.from=example.com",
+			"mx.google.com",
+			map[string]string{"dkim": "pass", "spf": "pass", "dmarc": "pass"},
+		},
+		{
+			"MX.Example.NET 1; dkim=fail; dkim=pass header.d=example.com; dkim=fail",
+			"mx.example.net",
+			map[string]string{"dkim": "pass"},
+		},
+		{
+			"mx.example.net; spf=softfail (comment; with (nested) semicolon) smtp.mailfrom=x; dmarc=fail",
+			"mx.example.net",
+			map[string]string{"spf": "softfail", "dmarc": "fail"},
+		},
+		{
+			"mx.example.net; auth=pass smtp.auth=\"user (not a comment)\"; iprev=pass/foo",
+			"mx.example.net",
+			map[string]string{"auth": "pass", "iprev": "pass/foo"},
+		},
+		{"mx.example.net; none", "mx.example.net", map[string]string{}},
+	}
+	for _, tt := range tests {
+		id, results := parseAuthResults(tt.value)
+		if id != tt.id || !reflect.DeepEqual(results, tt.results) {
+			t.Errorf("parseAuthResults(%q) = %q, %v, want %q, %v", tt.value, id, results, tt.id, tt.results)
+		}
+	}
+}
+
+func TestPunycodeDecode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"mnchen-3ya", "münchen", true},
+		{"bcher-kva", "bücher", true},
+		{"pple-43d", "аpple", true},
+		{"l-7sba6dbr", "раураl", true},
+		{"tda", "ü", true},
+		{"wgv71a119e", "日本語", true},
+		{"abc-!!", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := punycodeDecode(tt.in)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("punycodeDecode(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLookalikeDomain(t *testing.T) {
+	tests := []struct {
+		domain, trusted string
+		want            bool
+	}{
+		{"paypal.com", "paypal.com", false},
+		{"paypa1.com", "paypal.com", true},
+		{"paypall.com", "paypal.com", true},
+		{"mail.paypall.com", "paypal.com", true},
+		{"pyapal.com", "paypal.com", true},
+		{"xn--pypal-4ve.com", "paypal.com", true}, // Cyrillic а
+		{"paypal.com.evil.net", "paypal.com", true},
+		{"pay-pal.com", "paypal.com", true},
+		{"rnicrosoft.com", "microsoft.com", true},
+		{"paypal.net", "paypal.com", true},
+		{"ebay.com", "paypal.com", false},
+		{"bing.com", "ibm.com", false},
+		{"ibn.com", "ibm.com", false},
+		{"github.com", "gitlab.com", false},
+		{"example.com", "", false},
+	}
+	for _, tt := range tests {
+		if got := lookalikeDomain(tt.domain, tt.trusted); got != tt.want {
+			t.Errorf("lookalikeDomain(%q, %q) = %v, want %v", tt.domain, tt.trusted, got, tt.want)
+		}
+	}
+}
+
+func TestAssessSender(t *testing.T) {
+	saved := config.Trust
+	t.Cleanup(func() { config.Trust = saved })
+	config.Trust = &TrustSettings{
+		TrustedSenders: []string{"PayPal <service@paypal.com>", "@example.com"},
+		ARCSealers:     []string{"lists.example.org"},
+	}
+
+	tests := []struct {
+		name     string
+		from     string
+		header   textproto.MIMEHeader
+		status   string
+		warnings []string
+	}{
+		{
+			name:   "verified",
+			from:   "PayPal <service@paypal.com>",
+			header: textproto.MIMEHeader{"Authentication-Results": {"mx.example.net; dmarc=pass"}},
+			status: "verified",
+		},
+		{
+			name:     "trusted address without DMARC",
+			from:     "PayPal <service@paypal.com>",
+			header:   textproto.MIMEHeader{"Authentication-Results": {"mx.example.net; spf=none"}},
+			status:   "suspicious",
+			warnings: []string{"claims trusted sender service@paypal.com without a DMARC pass"},
+		},
+		{
+			name:     "SPF fail",
+			from:     "a@other.org",
+			header:   textproto.MIMEHeader{"Authentication-Results": {"mx.example.net; spf=fail; dkim=none"}},
+			status:   "suspicious",
+			warnings: []string{"SPF fail"},
+		},
+		{
+			name:     "only the topmost header counts",
+			from:     "a@other.org",
+			header:   textproto.MIMEHeader{"Authentication-Results": {"mx.example.net; dmarc=fail", "forged.example; dmarc=pass"}},
+			status:   "suspicious",
+			warnings: []string{"DMARC fail"},
+		},
+		{
+			name:     "trusted name and lookalike domain",
+			from:     "PayPal <service@paypa1.com>",
+			status:   "suspicious",
+			warnings: []string{"uses the name of trusted sender PayPal", "lookalike of paypal.com"},
+		},
+		{
+			name:     "address in the display name",
+			from:     `"service@paypal.com" <x@other.org>`,
+			status:   "suspicious",
+			warnings: []string{"display name shows service@paypal.com"},
+		},
+		{
+			name: "ARC from a trusted sealer",
+			from: "a@example.com",
+			header: textproto.MIMEHeader{
+				"Authentication-Results":     {"mx.example.net; dmarc=fail; arc=pass"},
+				"Arc-Authentication-Results": {"i=2; lists.example.org; dmarc=fail", "i=1; lists.example.org; dmarc=pass"},
+				"Arc-Seal":                   {"i=2; a=rsa-sha256; d=other.net; cv=pass", "i=1; a=rsa-sha256; d=lists.example.org; cv=none"},
+			},
+			status: "verified",
+		},
+		{
+			name: "ARC from an unknown sealer",
+			from: "a@example.com",
+			header: textproto.MIMEHeader{
+				"Authentication-Results":     {"mx.example.net; dmarc=fail; arc=pass"},
+				"Arc-Authentication-Results": {"i=1; lists.evil.net; dmarc=pass"},
+				"Arc-Seal":                   {"i=1; d=lists.evil.net; cv=none"},
+			},
+			status:   "suspicious",
+			warnings: []string{"DMARC fail", "claims trusted sender a@example.com without a DMARC pass"},
+		},
+		{
+			name:   "no results",
+			from:   "a@other.org",
+			status: "unverified",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := textproto.MIMEHeader{"From": {tt.from}}
+			for k, v := range tt.header {
+				header[k] = v
+			}
+			in := filterInput{
+				Senders: headerSenders(header.Get),
+				Header:  header.Values,
+			}
+			v := assessSender(in)
+			if v.Status != tt.status || strings.Join(v.Warnings, "|") != strings.Join(tt.warnings, "|") {
+				t.Errorf("assessSender = %s %q, want %s %q", v.Status, v.Warnings, tt.status, tt.warnings)
+			}
+		})
+	}
+}
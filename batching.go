@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type BatchSettings struct {
+	Threshold int      `json:"threshold"`         // summarize when more than this many matches arrive together
+	Window    int      `json:"window,omitempty"`  // seconds to collect matches before deciding, 0 means per check
+	Folders   []string `json:"folders,omitempty"` // empty means every folder
+	Digest    string   `json:"digest,omitempty"`  // "hourly" or "daily" to gather summaries into a digest
+}
+
+func validateBatchSettings(b *BatchSettings) error {
+	if b == nil {
+		return nil
+	}
+	if b.Threshold < 0 || b.Window < 0 {
+		return fmt.Errorf("threshold and window must not be negative")
+	}
+	switch b.Digest {
+	case "", "hourly", "daily":
+	default:
+		return fmt.Errorf("invalid digest %q (expected \"hourly\" or \"daily\")", b.Digest)
+	}
+	return nil
+}
+
+func batchingEnabled(acc *AccountConfig, folder string) bool {
+	if acc.Batching == nil {
+		return false
+	}
+	if len(acc.Batching.Folders) == 0 {
+		return true
+	}
+	for _, f := range acc.Batching.Folders {
+		if f == folder {
+			return true
+		}
+	}
+	return false
+}
+
+func notifyMatches(acc *AccountConfig, folder string, matches []matchedMessage) {
+	matches = dropMuted(acc, matches)
+	if len(matches) == 0 {
+		return
+	}
+
+	if !batchingEnabled(acc, folder) {
+		for _, m := range matches {
+			showNotification(acc, m)
+		}
+		return
+	}
+
+	// Critical messages, one-time codes and suspicious messages are never
+	// folded into a summary or digest.
+	var batched []matchedMessage
+	for _, m := range matches {
+		if priorityOrder[m.Priority] >= priorityOrder["critical"] || m.Code != "" || m.Auth.Status == "suspicious" {
+			showNotification(acc, m)
+		} else {
+			batched = append(batched, m)
+		}
+	}
+	matches = batched
+	if len(matches) == 0 {
+		return
+	}
+
+	if acc.Batching.Window <= 0 {
+		flushBatch(acc, folder, matches)
+		return
+	}
+
+	acc.mu.Lock()
+	if acc.pendingBatches == nil {
+		acc.pendingBatches = make(map[string][]matchedMessage)
+	}
+	_, waiting := acc.pendingBatches[folder]
+	acc.pendingBatches[folder] = append(acc.pendingBatches[folder], matches...)
+	acc.mu.Unlock()
+
+	if !waiting {
+		id := acc.ID
+		time.AfterFunc(time.Duration(acc.Batching.Window)*time.Second, func() {
+			withAccount(id, func(acc *AccountConfig) {
+				acc.mu.Lock()
+				pending := acc.pendingBatches[folder]
+				delete(acc.pendingBatches, folder)
+				acc.mu.Unlock()
+
+				flushBatch(acc, folder, pending)
+			})
+		})
+	}
+}
+
+func flushBatch(acc *AccountConfig, folder string, matches []matchedMessage) {
+	if len(matches) <= acc.Batching.Threshold {
+		for _, m := range matches {
+			showNotification(acc, m)
+		}
+		return
+	}
+
+	if acc.Batching.Digest != "" {
+		acc.mu.Lock()
+		acc.digestQueue = append(acc.digestQueue, matches...)
+		acc.mu.Unlock()
+
+		for _, m := range matches {
+			recordNotification(acc, m, "digest")
+		}
+		acc.logger().Info("Queued messages for digest", "folder", folder, "count", len(matches), "digest", acc.Batching.Digest)
+		return
+	}
+
+	summary := fmt.Sprintf("%d new in %s from %d senders", len(matches), folder, countSenders(matches))
+	acc.logger().Info("Batched notification", "folder", folder, "summary", summary)
+
+	for _, m := range matches {
+		recordNotification(acc, m, "batched")
+	}
+
+	if holdNotification(acc, folder, "", nil, summary, "normal") {
+		return
+	}
+
+	title := fmt.Sprintf("📧 %s [%s]", acc.Email, folder)
+	message := fmt.Sprintf("%s\nDetails: %s", summary, dashboardLink(acc.Email, folder))
+	sendDesktopNotification(acc, title, message, "normal")
+}
+
+func countSenders(matches []matchedMessage) int {
+	senders := make(map[string]bool)
+	for _, m := range matches {
+		senders[strings.ToLower(m.Sender)] = true
+	}
+	return len(senders)
+}
+
+func dashboardLink(account, folder string) string {
+	q := url.Values{}
+	q.Set("account", account)
+	if folder != "" {
+		q.Set("folder", folder)
+	}
+	return webServerURL + "/?" + q.Encode() + "#notifications"
+}
+
+func digestInterval(digest string) time.Duration {
+	if digest == "daily" {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+func sendDigest(acc *AccountConfig) {
+	if acc.Batching == nil || acc.Batching.Digest == "" {
+		return
+	}
+
+	if isQuietTime(acc, time.Now()) {
+		return
+	}
+
+	acc.mu.Lock()
+	if acc.lastDigest.IsZero() {
+		acc.lastDigest = time.Now()
+	}
+	if time.Since(acc.lastDigest) < digestInterval(acc.Batching.Digest) || len(acc.digestQueue) == 0 {
+		acc.mu.Unlock()
+		return
+	}
+	queue := acc.digestQueue
+	acc.digestQueue = nil
+	acc.lastDigest = time.Now()
+	acc.mu.Unlock()
+
+	byFolder := make(map[string]int)
+	for _, m := range queue {
+		byFolder[m.Folder]++
+	}
+	folders := make([]string, 0, len(byFolder))
+	for f := range byFolder {
+		folders = append(folders, f)
+	}
+	sort.Slice(folders, func(i, j int) bool { return byFolder[folders[i]] > byFolder[folders[j]] })
+
+	var lines []string
+	for i, f := range folders {
+		if i == 5 {
+			lines = append(lines, fmt.Sprintf("...and %d more folders", len(folders)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d in %s", byFolder[f], f))
+	}
+	lines = append(lines, "Details: "+dashboardLink(acc.Email, ""))
+
+	acc.logger().Info("Digest notification", "messages", len(queue), "folders", len(folders), "senders", countSenders(queue))
+
+	title := fmt.Sprintf("📬 %s - %s digest: %d new from %d senders", acc.Email, acc.Batching.Digest, len(queue), countSenders(queue))
+	sendDesktopNotification(acc, title, strings.Join(lines, "\n"), "normal")
+}
+
+func runDigestLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, id := range accountIDs() {
+			withAccount(id, sendDigest)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const discoveryTimeout = 10 * time.Second
+
+// dnsResolver is the subset of *net.Resolver used by discovery, so that tests
+// can substitute canned answers.
+type dnsResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+type accountDiscoverer struct {
+	resolver dnsResolver
+	http     *http.Client
+}
+
+var defaultDiscoverer = &accountDiscoverer{
+	resolver: net.DefaultResolver,
+	http:     &http.Client{Timeout: 5 * time.Second},
+}
+
+type discoveredServer struct {
+	Protocol string `json:"protocol"`
+	Server   string `json:"server"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Source   string `json:"source"` // "autoconfig", "srv", "guess", "mx" or "autoconfig-http"
+	Score    int    `json:"score"`
+}
+
+// autoconfigXML is the part of Mozilla's config-v1.1.xml format we use.
+type autoconfigXML struct {
+	Providers []struct {
+		Incoming []struct {
+			Type       string `xml:"type,attr"`
+			Hostname   string `xml:"hostname"`
+			Port       int    `xml:"port"`
+			SocketType string `xml:"socketType"`
+			Username   string `xml:"username"`
+		} `xml:"incomingServer"`
+	} `xml:"emailProvider"`
+}
+
+func splitEmail(email string) (string, string, bool) {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", "", false
+	}
+	return email[:at], strings.ToLower(email[at+1:]), true
+}
+
+// validDomain rejects domains that would change the meaning of the URLs
+// they are put into, such as "evil.net/x" or "evil.net:8080".
+func validDomain(domain string) bool {
+	if len(domain) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, r := range label {
+			if r <= ' ' || r == 0x7f || strings.ContainsRune(`/\:?#@[]%`, r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// discover returns candidate settings for email, best first. Every source is
+// queried concurrently; failures of individual sources are not errors.
+func (d *accountDiscoverer) discover(ctx context.Context, email string) ([]discoveredServer, error) {
+	local, domain, ok := splitEmail(email)
+	if !ok || !validDomain(domain) {
+		return nil, fmt.Errorf("invalid email address")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+
+	var (
+		mu         sync.Mutex
+		candidates []discoveredServer
+		wg         sync.WaitGroup
+	)
+	add := func(found []discoveredServer) {
+		mu.Lock()
+		candidates = append(candidates, found...)
+		mu.Unlock()
+	}
+
+	// Anyone on the path can answer the plain HTTP request, and the
+	// password is sent to the server it names, so it ranks below every
+	// other source.
+	query := "?emailaddress=" + url.QueryEscape(email)
+	for _, a := range []struct {
+		url    string
+		source string
+		score  int
+	}{
+		{"https://autoconfig." + domain + "/mail/config-v1.1.xml" + query, "autoconfig", 300},
+		{"https://" + domain + "/.well-known/autoconfig/mail/config-v1.1.xml" + query, "autoconfig", 300},
+		{"http://autoconfig." + domain + "/mail/config-v1.1.xml" + query, "autoconfig-http", 30},
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			add(d.autoconfig(ctx, a.url, a.source, a.score, email, local, domain))
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		add(d.srvRecords(ctx, email, domain))
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		add(d.guesses(ctx, email, domain, "guess", 0))
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		add(d.mxGuesses(ctx, email, domain))
+	}()
+
+	wg.Wait()
+	return rankCandidates(candidates), nil
+}
+
+func (d *accountDiscoverer) autoconfig(ctx context.Context, u, source string, score int, email, local, domain string) []discoveredServer {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var cfg autoconfigXML
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&cfg); err != nil {
+		return nil
+	}
+
+	placeholders := strings.NewReplacer(
+		"%EMAILADDRESS%", email,
+		"%EMAILLOCALPART%", local,
+		"%EMAILDOMAIN%", domain,
+	)
+
+	var found []discoveredServer
+	for _, p := range cfg.Providers {
+		for i, in := range p.Incoming {
+			// Connections are always made over implicit TLS.
+			if (in.Type != "imap" && in.Type != "pop3") || !strings.EqualFold(in.SocketType, "SSL") {
+				continue
+			}
+			found = append(found, discoveredServer{
+				Protocol: in.Type,
+				Server:   placeholders.Replace(in.Hostname),
+				Port:     in.Port,
+				Username: placeholders.Replace(in.Username),
+				Source:   source,
+				// The file lists servers in the provider's order of preference.
+				Score: score - i,
+			})
+		}
+	}
+	return found
+}
+
+func (d *accountDiscoverer) srvRecords(ctx context.Context, email, domain string) []discoveredServer {
+	var found []discoveredServer
+	for _, s := range []struct {
+		service, protocol string
+		score             int
+	}{
+		{"imaps", "imap", 220},
+		{"jmap", "jmap", 210},
+		{"pop3s", "pop3", 200},
+	} {
+		_, records, err := d.resolver.LookupSRV(ctx, s.service, "tcp", domain)
+		if err != nil {
+			continue
+		}
+		// LookupSRV sorts by priority and weight.
+		for i, r := range records {
+			target := strings.TrimSuffix(r.Target, ".")
+			// A target of "." means the service is decidedly not available.
+			if target == "" {
+				break
+			}
+			found = append(found, discoveredServer{
+				Protocol: s.protocol,
+				Server:   target,
+				Port:     int(r.Port),
+				Username: email,
+				Source:   "srv",
+				Score:    s.score - i,
+			})
+		}
+	}
+	return found
+}
+
+// guesses tries the usual hostnames under domain. penalty is subtracted from
+// their scores.
+func (d *accountDiscoverer) guesses(ctx context.Context, email, domain, source string, penalty int) []discoveredServer {
+	guesses := []discoveredServer{
+		{Protocol: "imap", Server: "imap." + domain, Port: 993, Score: 120},
+		{Protocol: "imap", Server: "mail." + domain, Port: 993, Score: 115},
+		{Protocol: "pop3", Server: "pop." + domain, Port: 995, Score: 100},
+		{Protocol: "pop3", Server: "pop3." + domain, Port: 995, Score: 95},
+		{Protocol: "pop3", Server: "mail." + domain, Port: 995, Score: 90},
+	}
+
+	var (
+		mu    sync.Mutex
+		found []discoveredServer
+		wg    sync.WaitGroup
+	)
+	for _, g := range guesses {
+		wg.Add(1)
+		go func(g discoveredServer) {
+			defer wg.Done()
+			if addrs, err := d.resolver.LookupHost(ctx, g.Server); err != nil || len(addrs) == 0 {
+				return
+			}
+			g.Username = email
+			g.Source = source
+			g.Score -= penalty
+			mu.Lock()
+			found = append(found, g)
+			mu.Unlock()
+		}(g)
+	}
+	wg.Wait()
+	return found
+}
+
+// mxGuesses falls back to the provider that receives the domain's mail:
+// a domain whose MX is aspmx.l.example.net gets the guesses for
+// example.net, ranked below the guesses for the domain itself.
+func (d *accountDiscoverer) mxGuesses(ctx context.Context, email, domain string) []discoveredServer {
+	records, err := d.resolver.LookupMX(ctx, domain)
+	if err != nil || len(records) == 0 {
+		return nil
+	}
+	// LookupMX sorts by preference.
+	provider := registeredDomain(strings.TrimSuffix(records[0].Host, "."))
+	if provider == "" || provider == registeredDomain(domain) || !validDomain(provider) {
+		return nil
+	}
+	return d.guesses(ctx, email, provider, "mx", 50)
+}
+
+// registeredDomain guesses the domain a host belongs to from its last two
+// labels, or three for second-level registries such as co.uk.
+func registeredDomain(host string) string {
+	labels := strings.Split(strings.ToLower(host), ".")
+	n := 2
+	if len(labels) > 2 && len(labels[len(labels)-2]) <= 3 && len(labels[len(labels)-1]) == 2 {
+		n = 3
+	}
+	if len(labels) < n {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// rankCandidates drops duplicates, keeping the best-scored entry, and sorts
+// the rest best first.
+func rankCandidates(candidates []discoveredServer) []discoveredServer {
+	best := make(map[string]discoveredServer)
+	for _, c := range candidates {
+		if c.Server == "" || c.Port <= 0 {
+			continue
+		}
+		key := fmt.Sprintf("%s|%s|%d", c.Protocol, strings.ToLower(c.Server), c.Port)
+		if prev, ok := best[key]; !ok || c.Score > prev.Score {
+			best[key] = c
+		}
+	}
+
+	result := make([]discoveredServer, 0, len(best))
+	for _, c := range best {
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Server < result[j].Server
+	})
+	return result
+}
+
+func handleDiscover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	candidates, err := defaultDiscoverer.discover(r.Context(), email)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	if len(candidates) == 0 {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    false,
+			"candidates": candidates,
+			"message":    "No settings found, please enter them manually",
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"candidates": candidates,
+		"message":    fmt.Sprintf("Found %d possible server settings", len(candidates)),
+	})
+}
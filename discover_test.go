@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeResolver answers from canned SRV, MX and host records.
+type fakeResolver struct {
+	srv   map[string][]*net.SRV // by service
+	mx    map[string][]*net.MX  // by domain
+	hosts map[string]bool
+}
+
+func (r fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if records, ok := r.srv[service]; ok {
+		return "", records, nil
+	}
+	return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if records, ok := r.mx[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if r.hosts[host] {
+		return []string{"192.0.2.1"}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// cannedTransport serves the given bodies by URL without the query, and 404
+// for everything else.
+type cannedTransport map[string]string
+
+func (t cannedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := *req.URL
+	u.RawQuery = ""
+	body, ok := t[u.String()]
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+const testAutoconfig = `<?xml version="1.0"?>
+<clientConfig version="1.1">
+  <emailProvider id="example.com">
+    <incomingServer type="imap">
+      <hostname>imap.provider.net</hostname>
+      <port>993</port>
+      <socketType>SSL</socketType>
+      <username>%EMAILLOCALPART%</username>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>starttls.provider.net</hostname>
+      <port>143</port>
+      <socketType>STARTTLS</socketType>
+      <username>%EMAILADDRESS%</username>
+    </incomingServer>
+    <incomingServer type="pop3">
+      <hostname>pop.provider.net</hostname>
+      <port>995</port>
+      <socketType>SSL</socketType>
+      <username>%EMAILADDRESS%</username>
+    </incomingServer>
+  </emailProvider>
+</clientConfig>`
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name      string
+		email     string
+		resolver  fakeResolver
+		responses cannedTransport
+		want      []string
+	}{
+		{
+			name:  "SRV records in priority order",
+			email: "user@example.com",
+			resolver: fakeResolver{
+				srv: map[string][]*net.SRV{
+					"imaps": {{Target: "imap1.example.com.", Port: 993}, {Target: "imap2.example.com.", Port: 993}},
+					"pop3s": {{Target: ".", Port: 0}},
+					"jmap":  {{Target: "jmap.example.com.", Port: 443}},
+				},
+				hosts: map[string]bool{"imap.example.com": true},
+			},
+			want: []string{
+				"srv imap imap1.example.com:993 user@example.com",
+				"srv imap imap2.example.com:993 user@example.com",
+				"srv jmap jmap.example.com:443 user@example.com",
+				"guess imap imap.example.com:993 user@example.com",
+			},
+		},
+		{
+			name:  "autoconfig over HTTPS first, only implicit TLS",
+			email: "user@example.com",
+			resolver: fakeResolver{
+				srv:   map[string][]*net.SRV{"imaps": {{Target: "imap.example.com.", Port: 993}}},
+				hosts: map[string]bool{"mail.example.com": true},
+			},
+			responses: cannedTransport{
+				"https://example.com/.well-known/autoconfig/mail/config-v1.1.xml": testAutoconfig,
+			},
+			want: []string{
+				"autoconfig imap imap.provider.net:993 user",
+				"autoconfig pop3 pop.provider.net:995 user@example.com",
+				"srv imap imap.example.com:993 user@example.com",
+				"guess imap mail.example.com:993 user@example.com",
+				"guess pop3 mail.example.com:995 user@example.com",
+			},
+		},
+		{
+			name:  "autoconfig over HTTP last",
+			email: "user@example.com",
+			resolver: fakeResolver{
+				hosts: map[string]bool{"imap.example.com": true},
+				mx:    map[string][]*net.MX{"example.com": {{Host: "mx.mailhost.net.", Pref: 10}}},
+			},
+			responses: cannedTransport{
+				"http://autoconfig.example.com/mail/config-v1.1.xml": testAutoconfig,
+			},
+			want: []string{
+				"guess imap imap.example.com:993 user@example.com",
+				"autoconfig-http imap imap.provider.net:993 user",
+				"autoconfig-http pop3 pop.provider.net:995 user@example.com",
+			},
+		},
+		{
+			name:  "MX fallback",
+			email: "user@example.com",
+			resolver: fakeResolver{
+				mx: map[string][]*net.MX{"example.com": {
+					{Host: "aspmx.l.mailhost.net.", Pref: 1},
+					{Host: "alt1.other.org.", Pref: 5},
+				}},
+				hosts: map[string]bool{"imap.mailhost.net": true, "pop.mailhost.net": true, "imap.other.org": true},
+			},
+			want: []string{
+				"mx imap imap.mailhost.net:993 user@example.com",
+				"mx pop3 pop.mailhost.net:995 user@example.com",
+			},
+		},
+		{
+			name:  "MX at the domain itself",
+			email: "user@example.co.uk",
+			resolver: fakeResolver{
+				mx:    map[string][]*net.MX{"example.co.uk": {{Host: "mx.example.co.uk.", Pref: 10}}},
+				hosts: map[string]bool{"imap.co.uk": true},
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &accountDiscoverer{resolver: tt.resolver, http: &http.Client{Transport: tt.responses}}
+			candidates, err := d.discover(context.Background(), tt.email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, c := range candidates {
+				got = append(got, fmt.Sprintf("%s %s %s:%d %s", c.Source, c.Protocol, c.Server, c.Port, c.Username))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestDiscoverInvalidDomain(t *testing.T) {
+	requested := cannedTransport{}
+	d := &accountDiscoverer{resolver: fakeResolver{}, http: &http.Client{Transport: requested}}
+	for _, email := range []string{
+		"user@",
+		"user@evil.net/x",
+		"user@evil.net:8080",
+		"user@evil.net?x=",
+		"user@exa mple.com",
+		"user@example..com",
+		"user@evil.net#",
+		"user@[127.0.0.1]",
+	} {
+		if _, err := d.discover(context.Background(), email); err == nil {
+			t.Errorf("discover(%q) accepted the domain", email)
+		}
+	}
+}
+
+func TestRegisteredDomain(t *testing.T) {
+	tests := map[string]string{
+		"aspmx.l.google.com":  "google.com",
+		"mx1.mail.example.de": "example.de",
+		"mx.example.co.uk":    "example.co.uk",
+		"example.com":         "example.com",
+		"localhost":           "",
+	}
+	for host, want := range tests {
+		if got := registeredDomain(host); got != want {
+			t.Errorf("registeredDomain(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	eventCheckStarted     = "check_started"
+	eventCheckFinished    = "check_finished"
+	eventCheckAllFinished = "check_all_finished"
+	eventNotification     = "notification"
+	eventError            = "error"
+	eventConfigChanged    = "config_changed"
+	eventMonitorPaused    = "monitor_paused"
+	eventMonitorResumed   = "monitor_resumed"
+
+	// maxEventBacklog events are kept so that a reconnecting client can catch
+	// up using Last-Event-ID.
+	maxEventBacklog = 100
+)
+
+type Event struct {
+	ID      int64       `json:"id"`
+	Type    string      `json:"type"`
+	Account string      `json:"account,omitempty"`
+	Time    time.Time   `json:"time"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type checkResult struct {
+	UnreadCount int    `json:"unread_count"`
+	LastCheck   string `json:"last_check"`
+	Error       string `json:"error,omitempty"`
+}
+
+// eventBus fans events out to every subscriber. Publishing never blocks; a
+// subscriber that falls behind misses events rather than stalling a monitor.
+type eventBus struct {
+	mu          sync.Mutex
+	nextID      int64
+	backlog     []Event
+	subscribers map[chan Event]struct{}
+}
+
+var events = &eventBus{subscribers: make(map[chan Event]struct{})}
+
+func (b *eventBus) publish(typ, account string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	ev := Event{ID: b.nextID, Type: typ, Account: account, Time: time.Now(), Data: data}
+	b.backlog = append(b.backlog, ev)
+	if len(b.backlog) > maxEventBacklog {
+		b.backlog = b.backlog[len(b.backlog)-maxEventBacklog:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel of new events, preceded by any backlog events
+// newer than lastID.
+func (b *eventBus) subscribe(lastID int64) (<-chan Event, []Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var missed []Event
+	if lastID > 0 {
+		for _, ev := range b.backlog {
+			if ev.ID > lastID {
+				missed = append(missed, ev)
+			}
+		}
+	}
+
+	ch := make(chan Event, 64)
+	b.subscribers[ch] = struct{}{}
+	return ch, missed, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+func publishEvent(typ, account string, data interface{}) {
+	events.publish(typ, account, data)
+}
+
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	ch, missed, unsubscribe := events.subscribe(lastID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	write := func(ev Event) bool {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data); err != nil {
+			return false
+		}
+		return true
+	}
+
+	for _, ev := range missed {
+		if !write(ev) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			if !write(ev) {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// FilterSet is a group of subject and sender filters. The global set applies
+// to every account; named sets apply to the accounts that list them in
+// filter_sets.
+type FilterSet struct {
+	IncludeKeyword []string `json:"include_keyword,omitempty"`
+	ExcludeKeyword []string `json:"exclude_keyword,omitempty"`
+	IncludeEmail   []string `json:"include_email,omitempty"`
+	ExcludeEmail   []string `json:"exclude_email,omitempty"`
+}
+
+func (f FilterSet) validate() error {
+	return validateSenderPatterns(append(append([]string(nil), f.IncludeEmail...), f.ExcludeEmail...))
+}
+
+// merge appends the entries of other that are not already in f, ignoring case.
+func (f *FilterSet) merge(other FilterSet) {
+	f.IncludeKeyword = appendUnique(f.IncludeKeyword, other.IncludeKeyword)
+	f.ExcludeKeyword = appendUnique(f.ExcludeKeyword, other.ExcludeKeyword)
+	f.IncludeEmail = appendUnique(f.IncludeEmail, other.IncludeEmail)
+	f.ExcludeEmail = appendUnique(f.ExcludeEmail, other.ExcludeEmail)
+}
+
+func appendUnique(list, values []string) []string {
+	for _, v := range values {
+		found := false
+		for _, existing := range list {
+			if strings.EqualFold(existing, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func validateFilterSets(global *FilterSet, sets map[string]FilterSet) error {
+	if global != nil {
+		if err := global.validate(); err != nil {
+			return fmt.Errorf("filters: %v", err)
+		}
+	}
+	for name, set := range sets {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("filter_sets: empty set name")
+		}
+		if err := set.validate(); err != nil {
+			return fmt.Errorf("filter set %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// validateAccountFilterSets checks that every set an account refers to exists.
+func validateAccountFilterSets(names []string) error {
+	for _, name := range names {
+		if _, ok := config.FilterSets[name]; !ok {
+			return fmt.Errorf("unknown filter set %q", name)
+		}
+	}
+	return nil
+}
+
+// effectiveFilters merges the global filters, the account's filter sets in
+// the order listed and the account's own filters. Include lists are merged
+// too, so an include entry anywhere restricts the account to matching mail.
+func effectiveFilters(acc *AccountConfig) FilterSet {
+	var f FilterSet
+	if config.Filters != nil {
+		f.merge(*config.Filters)
+	}
+	for _, name := range acc.FilterSets {
+		f.merge(config.FilterSets[name])
+	}
+	f.merge(FilterSet{
+		IncludeKeyword: acc.IncludeKeyword,
+		ExcludeKeyword: acc.ExcludeKeyword,
+		IncludeEmail:   acc.IncludeEmail,
+		ExcludeEmail:   acc.ExcludeEmail,
+	})
+	return f
+}
+
+func handleFilters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	global := FilterSet{}
+	if config.Filters != nil {
+		global = *config.Filters
+	}
+	sets := config.FilterSets
+	if sets == nil {
+		sets = map[string]FilterSet{}
+	}
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"global": global,
+		"sets":   sets,
+		"names":  names,
+	})
+}
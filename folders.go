@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// folderInfo describes a folder for the dashboard's folder tree. Counts are
+// zero for \Noselect folders, which cannot hold messages.
+type folderInfo struct {
+	Name       string   `json:"name"`
+	Delimiter  string   `json:"delimiter,omitempty"`
+	Attributes []string `json:"attributes,omitempty"`
+	SpecialUse string   `json:"special_use,omitempty"`
+	NoSelect   bool     `json:"noselect,omitempty"`
+	Messages   int      `json:"messages"`
+	Unseen     int      `json:"unseen"`
+}
+
+// specialUseAttrs are the RFC 6154 attributes, keyed in lower case because
+// servers differ in how they capitalise them.
+var specialUseAttrs = map[string]string{}
+
+func init() {
+	for _, attr := range []string{imap.AllAttr, imap.ArchiveAttr, imap.DraftsAttr, imap.FlaggedAttr,
+		imap.JunkAttr, imap.SentAttr, imap.TrashAttr, imap.ImportantAttr} {
+		specialUseAttrs[strings.ToLower(attr)] = attr
+	}
+}
+
+func sortFolderInfo(folders []folderInfo) {
+	sort.Slice(folders, func(i, j int) bool {
+		// INBOX first, like listMaildirFolders.
+		if (folders[i].Name == "INBOX") != (folders[j].Name == "INBOX") {
+			return folders[i].Name == "INBOX"
+		}
+		return folders[i].Name < folders[j].Name
+	})
+}
+
+// listIMAPFolderInfo lists every folder and asks the server for its message
+// and unseen counts. A folder whose STATUS fails is still listed, without
+// counts, since some servers refuse STATUS on virtual folders.
+func listIMAPFolderInfo(c *client.Client) ([]folderInfo, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", "*", mailboxes)
+	}()
+
+	var folders []folderInfo
+	for m := range mailboxes {
+		info := folderInfo{Name: m.Name, Delimiter: m.Delimiter, Attributes: m.Attributes}
+		for _, attr := range m.Attributes {
+			if strings.EqualFold(attr, imap.NoSelectAttr) {
+				info.NoSelect = true
+			} else if special, ok := specialUseAttrs[strings.ToLower(attr)]; ok {
+				info.SpecialUse = special
+			}
+		}
+		folders = append(folders, info)
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+
+	for i := range folders {
+		if folders[i].NoSelect {
+			continue
+		}
+		status, err := c.Status(folders[i].Name, []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen})
+		if err != nil {
+			continue
+		}
+		folders[i].Messages = int(status.Messages)
+		folders[i].Unseen = int(status.Unseen)
+	}
+
+	sortFolderInfo(folders)
+	return folders, nil
+}
+
+// jmapFolderInfo presents JMAP mailboxes like IMAP folders, with "/" as the
+// delimiter and the mailbox role as the special-use attribute.
+func jmapFolderInfo(mailboxes []jmapMailbox) []folderInfo {
+	names := jmapFolderNames(mailboxes)
+	var folders []folderInfo
+	for _, mb := range mailboxes {
+		info := folderInfo{Name: names[mb.ID], Delimiter: "/", Messages: mb.TotalEmails, Unseen: mb.UnreadEmails}
+		if mb.Role != "" && mb.Role != "inbox" {
+			info.SpecialUse = "\\" + strings.ToUpper(mb.Role[:1]) + mb.Role[1:]
+			info.Attributes = []string{info.SpecialUse}
+		}
+		folders = append(folders, info)
+	}
+	sortFolderInfo(folders)
+	return folders
+}
+
+// maildirFolderInfo counts the files in new/ and cur/ of every folder. Messages
+// in new/ have not been seen by any client yet.
+func maildirFolderInfo(root string) []folderInfo {
+	var folders []folderInfo
+	for _, name := range listMaildirFolders(root) {
+		info := folderInfo{Name: name, Delimiter: "."}
+		dir := maildirFolderPath(root, name)
+		if entries, err := os.ReadDir(filepath.Join(dir, "new")); err == nil {
+			info.Messages += len(entries)
+			info.Unseen += len(entries)
+		}
+		if entries, err := os.ReadDir(filepath.Join(dir, "cur")); err == nil {
+			info.Messages += len(entries)
+			for _, e := range entries {
+				if !maildirSeen(e.Name()) {
+					info.Unseen++
+				}
+			}
+		}
+		folders = append(folders, info)
+	}
+	return folders
+}
+
+// accountFolderInfo lists the folders of a saved account using the
+// credentials stored in the keyring.
+func accountFolderInfo(acc *AccountConfig) ([]folderInfo, error) {
+	switch acc.Protocol {
+	case "pop3", "mbox":
+		return nil, fmt.Errorf("%s does not support folder listing (inbox only)", strings.ToUpper(acc.Protocol))
+	case "maildir":
+		if _, err := testLocalSource(acc.Protocol, acc.Path); err != nil {
+			return nil, fmt.Errorf("Maildir not found: %v", err)
+		}
+		return maildirFolderInfo(expandPath(acc.Path)), nil
+	case "jmap":
+		c, err := connectToJMAP(acc)
+		if err != nil {
+			return nil, fmt.Errorf("Connection failed: %v", err)
+		}
+		mailboxes, err := c.mailboxes()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list mailboxes: %v", err)
+		}
+		return jmapFolderInfo(mailboxes), nil
+	}
+
+	c, err := connectToIMAP(acc)
+	if err != nil {
+		return nil, fmt.Errorf("Connection failed: %v", err)
+	}
+	defer c.Logout()
+
+	folders, err := listIMAPFolderInfo(c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list folders: %v", err)
+	}
+	return folders, nil
+}
+
+func handleAccountFolders(w http.ResponseWriter, r *http.Request) {
+	acc := accountFromRequest(w, r)
+	if acc == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	folders, err := accountFolderInfo(acc)
+	if err != nil {
+		acc.logger().Warn("Failed to list folders", "error", err)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if folders == nil {
+		folders = []folderInfo{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"folders": folders,
+		"message": fmt.Sprintf("Successfully retrieved %d folders", len(folders)),
+	})
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+)
+
+// webmailURL fills in the account's webmail link template. {message_id},
+// {folder} and {account} are replaced with URL-escaped values, e.g.
+// "https://mail.google.com/mail/u/0/#search/rfc822msgid:{message_id}".
+func webmailURL(acc *AccountConfig, m matchedMessage) string {
+	if acc.WebmailURL == "" {
+		return ""
+	}
+	messageID := strings.Trim(m.MessageID, "<> ")
+	if messageID == "" && strings.Contains(acc.WebmailURL, "{message_id}") {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{message_id}", url.QueryEscape(messageID),
+		"{folder}", url.QueryEscape(m.Folder),
+		"{account}", url.QueryEscape(acc.Email),
+	).Replace(acc.WebmailURL)
+}
+
+// messageActions lists the actions the dashboard can offer for a message.
+// POP3 and mbox have no flags or folders to change.
+func messageActions(acc *AccountConfig, m matchedMessage) []string {
+	var actions []string
+	if m.Ref != "" && (acc.Protocol == "imap" || acc.Protocol == "jmap" || acc.Protocol == "maildir") {
+		actions = append(actions, "read", "archive")
+	}
+	return append(actions, muteActions(m)...)
+}
+
+func findNotification(id int64) (NotificationRecord, bool) {
+	recentNotificationsMu.RLock()
+	defer recentNotificationsMu.RUnlock()
+	for _, rec := range recentNotifications {
+		if rec.ID == id {
+			return rec, true
+		}
+	}
+	return NotificationRecord{}, false
+}
+
+func updateNotification(id int64, update func(*NotificationRecord)) {
+	recentNotificationsMu.Lock()
+	defer recentNotificationsMu.Unlock()
+	for i := range recentNotifications {
+		if recentNotifications[i].ID == id {
+			update(&recentNotifications[i])
+			return
+		}
+	}
+}
+
+// handleNotificationAction runs one of the record's actions: "read" marks the
+// message as read on the server, "archive" moves it to the archive folder and
+// "mute-sender", "mute-domain", "mute-list" and "mute-thread" add a mute,
+// for the duration given by the optional "for" parameter.
+func handleNotificationAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	rec, ok := findNotification(id)
+	var acc *AccountConfig
+	if ok {
+		acc = findAccountByEmail(rec.Account)
+	}
+	if acc == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Message not found"})
+		return
+	}
+
+	action := r.PathValue("action")
+	supported := false
+	for _, a := range rec.Actions {
+		supported = supported || a == action
+	}
+	if !supported {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("%q is not available for this message", action),
+		})
+		return
+	}
+
+	var err error
+	var message string
+	switch action {
+	case "read":
+		err = markMessageRead(acc, rec)
+		message = "Marked as read"
+		if err == nil {
+			updateNotification(id, func(n *NotificationRecord) { n.Read = true })
+		}
+	case "archive":
+		var folder string
+		folder, err = archiveMessage(acc, rec)
+		message = "Archived to " + folder
+		if err == nil {
+			updateNotification(id, func(n *NotificationRecord) { n.Archived = true })
+		}
+	default:
+		var rule MuteRule
+		rule, err = muteFromNotification(rec, strings.TrimPrefix(action, "mute-"))
+		if err == nil && r.URL.Query().Get("for") != "" {
+			var until time.Time
+			until, err = parseUntil(r.URL.Query().Get("for"))
+			rule.Until = &until
+		}
+		if err == nil {
+			rule, err = addMute(rule)
+		}
+		message = "Muted " + rule.Type + " " + rule.Value
+	}
+
+	if err != nil {
+		acc.logger().Warn("Message action failed", "action", action, "error", err)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	acc.logger().Info("Message action", "action", action, "folder", rec.Folder)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": message})
+}
+
+func markMessageRead(acc *AccountConfig, rec NotificationRecord) error {
+	switch acc.Protocol {
+	case "jmap":
+		c, err := connectToJMAP(acc)
+		if err != nil {
+			return err
+		}
+		return c.updateEmail(rec.ref, map[string]interface{}{"keywords/$seen": true})
+	case "maildir":
+		_, err := moveMaildirMessage(expandPath(acc.Path), rec.Folder, rec.Folder, rec.ref, true)
+		return err
+	}
+
+	return withIMAPMessage(acc, rec, func(c *client.Client, uid *imap.SeqSet) error {
+		return c.UidStore(uid, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.SeenFlag}, nil)
+	})
+}
+
+// archiveMessage moves the message to the folder marked \Archive (or the
+// archive role in JMAP), falling back to a folder named "Archive".
+func archiveMessage(acc *AccountConfig, rec NotificationRecord) (string, error) {
+	switch acc.Protocol {
+	case "jmap":
+		c, err := connectToJMAP(acc)
+		if err != nil {
+			return "", err
+		}
+		mailboxes, err := c.mailboxes()
+		if err != nil {
+			return "", err
+		}
+		names := jmapFolderNames(mailboxes)
+		archiveID := ""
+		for _, mb := range mailboxes {
+			if mb.Role == "archive" || (archiveID == "" && strings.EqualFold(names[mb.ID], "Archive")) {
+				archiveID = mb.ID
+			}
+		}
+		if archiveID == "" {
+			return "", fmt.Errorf("no archive mailbox found")
+		}
+		return names[archiveID], c.updateEmail(rec.ref, map[string]interface{}{"mailboxIds": map[string]bool{archiveID: true}})
+	case "maildir":
+		root := expandPath(acc.Path)
+		archive := ""
+		for _, f := range listMaildirFolders(root) {
+			if strings.EqualFold(f, "Archive") {
+				archive = f
+			}
+		}
+		if archive == "" {
+			return "", fmt.Errorf("no Archive folder found in %s", root)
+		}
+		_, err := moveMaildirMessage(root, rec.Folder, archive, rec.ref, false)
+		return archive, err
+	}
+
+	var archive string
+	err := withIMAPMessage(acc, rec, func(c *client.Client, uid *imap.SeqSet) error {
+		folder, err := imapArchiveFolder(c)
+		if err != nil {
+			return err
+		}
+		archive = folder
+		return imapMove(c, uid, folder)
+	})
+	return archive, err
+}
+
+// imapMove moves a message with MOVE or, failing that, with COPY, \Deleted
+// and a UID EXPUNGE (UIDPLUS) of just that message. go-imap's own fallback
+// uses a plain EXPUNGE, which would also remove every other message already
+// flagged \Deleted in the folder.
+func imapMove(c *client.Client, uid *imap.SeqSet, folder string) error {
+	if ok, err := c.Support("MOVE"); err != nil {
+		return err
+	} else if ok {
+		return c.UidMove(uid, folder)
+	}
+	if ok, err := c.Support("UIDPLUS"); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("the server supports neither MOVE nor UIDPLUS, so the message cannot be moved without expunging other deleted messages")
+	}
+
+	if err := c.UidCopy(uid, folder); err != nil {
+		return fmt.Errorf("failed to copy to %s: %v", folder, err)
+	}
+	if err := c.UidStore(uid, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return err
+	}
+	expunge := &commands.Uid{Cmd: &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{uid}}}
+	status, err := c.Execute(expunge, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// withIMAPMessage selects the message's folder read-write and calls f with
+// its UID.
+func withIMAPMessage(acc *AccountConfig, rec NotificationRecord, f func(*client.Client, *imap.SeqSet) error) error {
+	uid, err := strconv.ParseUint(rec.ref, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid UID %q", rec.ref)
+	}
+
+	c, err := connectToIMAP(acc)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	if _, err := c.Select(rec.Folder, false); err != nil {
+		return fmt.Errorf("failed to select %s: %v", rec.Folder, err)
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uint32(uid))
+	return f(c, seqset)
+}
+
+func imapArchiveFolder(c *client.Client) (string, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", "*", mailboxes)
+	}()
+
+	var special, named string
+	for m := range mailboxes {
+		for _, attr := range m.Attributes {
+			if strings.EqualFold(attr, imap.ArchiveAttr) {
+				special = m.Name
+			}
+		}
+		if strings.EqualFold(m.Name, "Archive") {
+			named = m.Name
+		}
+	}
+	if err := <-done; err != nil {
+		return "", err
+	}
+
+	if special != "" {
+		return special, nil
+	}
+	if named != "" {
+		return named, nil
+	}
+	return "", fmt.Errorf("no archive folder found")
+}
+
+// moveMaildirMessage finds the message with the given unique name in new/ or
+// cur/ of folder and moves it into cur/ of dest, adding the S flag if seen is
+// set. It returns the new path.
+func moveMaildirMessage(root, folder, dest, uniq string, seen bool) (string, error) {
+	src := ""
+	for _, sub := range []string{"new", "cur"} {
+		dir := filepath.Join(maildirFolderPath(root, folder), sub)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if maildirUniq(e.Name()) == uniq {
+				src = filepath.Join(dir, e.Name())
+				break
+			}
+		}
+		if src != "" {
+			break
+		}
+	}
+	if src == "" {
+		return "", fmt.Errorf("message not found in %s", folder)
+	}
+
+	flags := ""
+	if i := strings.Index(filepath.Base(src), ":2,"); i >= 0 {
+		flags = filepath.Base(src)[i+3:]
+	}
+	if seen && !strings.Contains(flags, "S") {
+		flags = sortFlags(flags + "S")
+	}
+
+	dst := filepath.Join(maildirFolderPath(root, dest), "cur", uniq+":2,"+flags)
+	if err := os.Rename(src, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// sortFlags keeps Maildir info flags in ASCII order, as the spec requires.
+func sortFlags(flags string) string {
+	b := []byte(flags)
+	slices.Sort(b)
+	return string(b)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// scriptedIMAP is a server that accepts every command and records it.
+type scriptedIMAP struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (s *scriptedIMAP) serve(conn net.Conn, caps string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* PREAUTH ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, cmd, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		s.mu.Unlock()
+
+		switch name, _, _ := strings.Cut(cmd, " "); strings.ToUpper(name) {
+		case "CAPABILITY":
+			fmt.Fprintf(conn, "* CAPABILITY IMAP4rev1 %s\r\n%s OK done\r\n", caps, tag)
+		case "SELECT":
+			fmt.Fprintf(conn, "* 1 EXISTS\r\n* FLAGS (\\Seen \\Deleted)\r\n%s OK [READ-WRITE] done\r\n", tag)
+		case "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK done\r\n", tag)
+			return
+		default:
+			fmt.Fprintf(conn, "%s OK done\r\n", tag)
+		}
+	}
+}
+
+func TestIMAPMove(t *testing.T) {
+	tests := []struct {
+		caps    string
+		want    []string
+		wantErr bool
+	}{
+		{caps: "MOVE", want: []string{`UID MOVE 42 "Archive"`}},
+		{caps: "MOVE UIDPLUS", want: []string{`UID MOVE 42 "Archive"`}},
+		{caps: "UIDPLUS", want: []string{`UID COPY 42 "Archive"`, `UID STORE 42 +FLAGS.SILENT (\Deleted)`, `UID EXPUNGE 42`}},
+		{caps: "IDLE", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.caps, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			server := &scriptedIMAP{}
+			go server.serve(serverConn, tt.caps)
+
+			c, err := client.New(clientConn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Select("INBOX", false); err != nil {
+				t.Fatal(err)
+			}
+			uid := new(imap.SeqSet)
+			uid.AddNum(42)
+			err = imapMove(c, uid, "Archive")
+			c.Logout()
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("imapMove error = %v, wantErr %v", err, tt.wantErr)
+			}
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			var sent []string
+			for _, cmd := range server.commands {
+				switch cmd {
+				case "SELECT INBOX", "CAPABILITY", "LOGOUT":
+					continue
+				case "EXPUNGE":
+					t.Errorf("plain EXPUNGE sent")
+				}
+				sent = append(sent, cmd)
+			}
+			if strings.Join(sent, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("commands = %q, want %q", sent, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,894 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	jmapCoreCapability = "urn:ietf:params:jmap:core"
+	jmapMailCapability = "urn:ietf:params:jmap:mail"
+	jmapInitialLimit   = 50
+
+	jmapPushMinDelay = 5 * time.Second
+	jmapPushMaxDelay = 5 * time.Minute
+)
+
+var jmapEmailProperties = []string{
+	"id", "mailboxIds", "keywords", "from", "sender", "replyTo", "to", "cc", "subject", "receivedAt", "preview", "size", "headers", "attachments", "messageId", "inReplyTo", "references",
+	"header:X-Priority:asText", "header:Importance:asText", "header:Priority:asText", "header:List-Id:asText",
+}
+
+type jmapSession struct {
+	APIURL          string            `json:"apiUrl"`
+	EventSourceURL  string            `json:"eventSourceUrl"`
+	DownloadURL     string            `json:"downloadUrl"`
+	PrimaryAccounts map[string]string `json:"primaryAccounts"`
+	State           string            `json:"state"`
+}
+
+type jmapClient struct {
+	sessionURL string
+	authType   string
+	username   string
+	secret     string
+	httpClient *http.Client
+	session    *jmapSession
+}
+
+type jmapMailbox struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	ParentID     string `json:"parentId"`
+	Role         string `json:"role"`
+	TotalEmails  int    `json:"totalEmails"`
+	UnreadEmails int    `json:"unreadEmails"`
+}
+
+type jmapHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type jmapBodyPart struct {
+	BlobID      string `json:"blobId"`
+	Size        int64  `json:"size"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Charset     string `json:"charset"`
+	Disposition string `json:"disposition"`
+	CID         string `json:"cid"`
+}
+
+type jmapAddress struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type jmapEmail struct {
+	ID          string          `json:"id"`
+	MailboxIDs  map[string]bool `json:"mailboxIds"`
+	Keywords    map[string]bool `json:"keywords"`
+	From        []jmapAddress   `json:"from"`
+	Sender      []jmapAddress   `json:"sender"`
+	Headers     []jmapHeader    `json:"headers"`
+	Size        int64           `json:"size"`
+	Attachments []jmapBodyPart  `json:"attachments"`
+	ReplyTo     []jmapAddress   `json:"replyTo"`
+	To          []jmapAddress   `json:"to"`
+	Cc          []jmapAddress   `json:"cc"`
+	Subject     string          `json:"subject"`
+	ReceivedAt  time.Time       `json:"receivedAt"`
+	Preview     string          `json:"preview"`
+	MessageID   []string        `json:"messageId"`
+	InReplyTo   []string        `json:"inReplyTo"`
+	References  []string        `json:"references"`
+	ListID      string          `json:"header:List-Id:asText"`
+	XPriority   string          `json:"header:X-Priority:asText"`
+	Importance  string          `json:"header:Importance:asText"`
+	Priority    string          `json:"header:Priority:asText"`
+}
+
+// jmapResponse is one entry of methodResponses: [name, arguments, callId].
+type jmapResponse struct {
+	Name string
+	Args json.RawMessage
+	ID   string
+}
+
+func (r *jmapResponse) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 3 {
+		return fmt.Errorf("invalid method response")
+	}
+	if err := json.Unmarshal(raw[0], &r.Name); err != nil {
+		return err
+	}
+	r.Args = raw[1]
+	return json.Unmarshal(raw[2], &r.ID)
+}
+
+type jmapMethodError struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+func (e *jmapMethodError) Error() string {
+	if e.Description != "" {
+		return e.Type + ": " + e.Description
+	}
+	return e.Type
+}
+
+// jmapSessionURL accepts either a hostname, which is resolved through
+// /.well-known/jmap (RFC 8620 section 2.2), or a full URL. A URL without a
+// path also gets /.well-known/jmap, so http://localhost:8080 works for a
+// local test server.
+func jmapSessionURL(server string, port int) string {
+	if strings.Contains(server, "://") {
+		server = strings.TrimRight(server, "/")
+		if i := strings.Index(server, "://"); !strings.Contains(server[i+3:], "/") {
+			server += "/.well-known/jmap"
+		}
+		return server
+	}
+	if port != 0 && port != 443 {
+		return fmt.Sprintf("https://%s:%d/.well-known/jmap", server, port)
+	}
+	return fmt.Sprintf("https://%s/.well-known/jmap", server)
+}
+
+func newJMAPClient(server string, port int, authType, username, secret string) *jmapClient {
+	return &jmapClient{
+		sessionURL: jmapSessionURL(server, port),
+		authType:   authType,
+		username:   username,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func connectToJMAP(acc *AccountConfig) (*jmapClient, error) {
+	password, err := getPassword(acc.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get password from keyring: %v", err)
+	}
+	c := newJMAPClient(acc.Server, acc.Port, acc.AuthType, acc.Username, password)
+	if err := c.fetchSession(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *jmapClient) authorize(req *http.Request) {
+	if c.authType == "bearer" {
+		req.Header.Set("Authorization", "Bearer "+c.secret)
+	} else {
+		req.SetBasicAuth(c.username, c.secret)
+	}
+}
+
+func (c *jmapClient) fetchSession() error {
+	req, err := http.NewRequest(http.MethodGet, c.sessionURL, nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("session request failed: %s", resp.Status)
+	}
+
+	var session jmapSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return fmt.Errorf("invalid session object: %v", err)
+	}
+	if session.APIURL == "" || session.PrimaryAccounts[jmapMailCapability] == "" {
+		return fmt.Errorf("server does not offer %s", jmapMailCapability)
+	}
+
+	// apiUrl may be relative to the session resource.
+	if base, err := resp.Request.URL.Parse(session.APIURL); err == nil {
+		session.APIURL = base.String()
+	}
+	if session.EventSourceURL != "" {
+		if base, err := resp.Request.URL.Parse(session.EventSourceURL); err == nil {
+			session.EventSourceURL = base.String()
+		}
+	}
+
+	if session.DownloadURL != "" {
+		if base, err := resp.Request.URL.Parse(session.DownloadURL); err == nil {
+			// Keep the {blobId} style variables unescaped.
+			session.DownloadURL, _ = url.PathUnescape(base.String())
+		}
+	}
+
+	c.session = &session
+	return nil
+}
+
+// download fetches a blob through the session's downloadUrl template.
+func (c *jmapClient) download(blobID, mediaType string, limit int64) ([]byte, error) {
+	if c.session.DownloadURL == "" {
+		return nil, fmt.Errorf("server has no downloadUrl")
+	}
+	target := strings.NewReplacer(
+		"{accountId}", url.PathEscape(c.accountID()),
+		"{blobId}", url.PathEscape(blobID),
+		"{type}", url.QueryEscape(mediaType),
+		"{name}", "invite.ics",
+	).Replace(c.session.DownloadURL)
+
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+func (c *jmapClient) accountID() string {
+	return c.session.PrimaryAccounts[jmapMailCapability]
+}
+
+// call sends the method calls in one request and returns the responses in
+// order. A method-level error is returned as *jmapMethodError.
+func (c *jmapClient) call(calls ...[]interface{}) ([]jmapResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"using":       []string{jmapCoreCapability, jmapMailCapability},
+		"methodCalls": calls,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.session.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("API request failed: %s %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var result struct {
+		MethodResponses []jmapResponse `json:"methodResponses"`
+		SessionState    string         `json:"sessionState"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid API response: %v", err)
+	}
+
+	for _, r := range result.MethodResponses {
+		if r.Name == "error" {
+			var methodErr jmapMethodError
+			json.Unmarshal(r.Args, &methodErr)
+			return nil, &methodErr
+		}
+	}
+	if len(result.MethodResponses) < len(calls) {
+		return nil, fmt.Errorf("API returned %d responses for %d calls", len(result.MethodResponses), len(calls))
+	}
+	return result.MethodResponses, nil
+}
+
+func (c *jmapClient) mailboxes() ([]jmapMailbox, error) {
+	responses, err := c.call([]interface{}{"Mailbox/get", map[string]interface{}{
+		"accountId":  c.accountID(),
+		"ids":        nil,
+		"properties": []string{"id", "name", "parentId", "role", "totalEmails", "unreadEmails"},
+	}, "m0"})
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		List []jmapMailbox `json:"list"`
+	}
+	if err := json.Unmarshal(responses[0].Args, &result); err != nil {
+		return nil, err
+	}
+	return result.List, nil
+}
+
+// updateEmail applies a patch such as {"keywords/$seen": true} to one email.
+func (c *jmapClient) updateEmail(id string, patch map[string]interface{}) error {
+	responses, err := c.call([]interface{}{"Email/set", map[string]interface{}{
+		"accountId": c.accountID(),
+		"update":    map[string]interface{}{id: patch},
+	}, "s0"})
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		NotUpdated map[string]jmapMethodError `json:"notUpdated"`
+	}
+	if err := json.Unmarshal(responses[0].Args, &result); err != nil {
+		return err
+	}
+	if e, ok := result.NotUpdated[id]; ok {
+		return &e
+	}
+	return nil
+}
+
+// jmapFolderNames maps mailbox IDs to folder names: "INBOX" for the inbox
+// role and the "/"-joined path of names for everything else, matching how
+// IMAP servers usually present the same mailboxes.
+func jmapFolderNames(mailboxes []jmapMailbox) map[string]string {
+	byID := make(map[string]jmapMailbox, len(mailboxes))
+	for _, mb := range mailboxes {
+		byID[mb.ID] = mb
+	}
+
+	names := make(map[string]string, len(mailboxes))
+	for _, mb := range mailboxes {
+		if mb.Role == "inbox" {
+			names[mb.ID] = "INBOX"
+			continue
+		}
+		parts := []string{mb.Name}
+		for parent, depth := mb.ParentID, 0; parent != "" && depth < 20; depth++ {
+			p, ok := byID[parent]
+			if !ok {
+				break
+			}
+			parts = append([]string{p.Name}, parts...)
+			parent = p.ParentID
+		}
+		names[mb.ID] = strings.Join(parts, "/")
+	}
+	return names
+}
+
+func listJMAPFolders(mailboxes []jmapMailbox) []string {
+	var folders []string
+	for _, name := range jmapFolderNames(mailboxes) {
+		folders = append(folders, name)
+	}
+	sort.Strings(folders)
+	return folders
+}
+
+// initialEmails returns unseen messages in the given mailboxes along with the
+// current Email state, which later checks pass to Email/changes.
+func (c *jmapClient) initialEmails(mailboxIDs []string) ([]jmapEmail, string, error) {
+	var conditions []interface{}
+	for _, id := range mailboxIDs {
+		conditions = append(conditions, map[string]interface{}{"inMailbox": id})
+	}
+	filter := map[string]interface{}{
+		"operator": "AND",
+		"conditions": []interface{}{
+			map[string]interface{}{"operator": "OR", "conditions": conditions},
+			map[string]interface{}{"notKeyword": "$seen"},
+		},
+	}
+	return c.queryEmails(filter, jmapInitialLimit)
+}
+
+// queryEmails fetches the newest messages matching filter.
+func (c *jmapClient) queryEmails(filter map[string]interface{}, limit int) ([]jmapEmail, string, error) {
+	responses, err := c.call(
+		[]interface{}{"Email/query", map[string]interface{}{
+			"accountId": c.accountID(),
+			"filter":    filter,
+			"sort":      []interface{}{map[string]interface{}{"property": "receivedAt", "isAscending": false}},
+			"limit":     limit,
+		}, "q0"},
+		[]interface{}{"Email/get", map[string]interface{}{
+			"accountId":  c.accountID(),
+			"#ids":       map[string]interface{}{"resultOf": "q0", "name": "Email/query", "path": "/ids"},
+			"properties": jmapEmailProperties,
+		}, "g0"},
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var result struct {
+		State string      `json:"state"`
+		List  []jmapEmail `json:"list"`
+	}
+	if err := json.Unmarshal(responses[1].Args, &result); err != nil {
+		return nil, "", err
+	}
+	return result.List, result.State, nil
+}
+
+// changedEmails returns messages created or updated since state, following
+// hasMoreChanges, and the new state. Updated messages are needed because mail
+// moved into a watched mailbox only changes its mailboxIds; the caller skips
+// the ones it has already seen.
+func (c *jmapClient) changedEmails(state string) ([]jmapEmail, string, error) {
+	var emails []jmapEmail
+	for {
+		responses, err := c.call(
+			[]interface{}{"Email/changes", map[string]interface{}{
+				"accountId":  c.accountID(),
+				"sinceState": state,
+				"maxChanges": 256,
+			}, "c0"},
+			[]interface{}{"Email/get", map[string]interface{}{
+				"accountId":  c.accountID(),
+				"#ids":       map[string]interface{}{"resultOf": "c0", "name": "Email/changes", "path": "/created"},
+				"properties": jmapEmailProperties,
+			}, "g0"},
+			[]interface{}{"Email/get", map[string]interface{}{
+				"accountId":  c.accountID(),
+				"#ids":       map[string]interface{}{"resultOf": "c0", "name": "Email/changes", "path": "/updated"},
+				"properties": jmapEmailProperties,
+			}, "g1"},
+		)
+		if err != nil {
+			return nil, state, err
+		}
+
+		var changes struct {
+			NewState       string `json:"newState"`
+			HasMoreChanges bool   `json:"hasMoreChanges"`
+		}
+		if err := json.Unmarshal(responses[0].Args, &changes); err != nil {
+			return nil, state, err
+		}
+		for _, r := range responses[1:3] {
+			var result struct {
+				List []jmapEmail `json:"list"`
+			}
+			if err := json.Unmarshal(r.Args, &result); err != nil {
+				return nil, state, err
+			}
+			emails = append(emails, result.List...)
+		}
+
+		state = changes.NewState
+		if !changes.HasMoreChanges {
+			return emails, state, nil
+		}
+	}
+}
+
+func jmapAddressList(addrs []jmapAddress) []string {
+	var result []string
+	for _, a := range addrs {
+		if a.Email != "" {
+			result = append(result, a.Email)
+		}
+	}
+	return result
+}
+
+func newJMAPMatch(folder string, e jmapEmail) matchedMessage {
+	m := matchedMessage{
+		Folder:  folder,
+		To:      jmapAddressList(e.To),
+		Cc:      jmapAddressList(e.Cc),
+		Subject: e.Subject,
+		Date:    e.ReceivedAt,
+		Ref:     e.ID,
+		Senders: jmapSenders(e),
+	}
+	if len(e.MessageID) > 0 {
+		m.MessageID = "<" + e.MessageID[0] + ">"
+	}
+	setThreadHeaders(&m, e.header)
+	if len(e.From) > 0 {
+		m.Sender = e.From[0].Email
+		m.FromName = e.From[0].Name
+		m.FromAddress = e.From[0].Email
+	}
+	return m
+}
+
+func (e jmapEmail) header(name string) string {
+	switch name {
+	case "X-Priority":
+		return e.XPriority
+	case "Importance":
+		return e.Importance
+	case "Priority":
+		return e.Priority
+	case "List-Id":
+		return e.ListID
+	case "In-Reply-To":
+		return jmapMessageIDs(e.InReplyTo)
+	case "References":
+		return jmapMessageIDs(e.References)
+	}
+	return ""
+}
+
+// jmapFilterInput uses the preview as the text for Sieve body tests, since
+// JMAP servers generate it from the first text part anyway.
+func jmapFilterInput(e jmapEmail) filterInput {
+	parts, _ := jmapMessageParts(e)
+	return filterInput{
+		Senders: jmapSenders(e),
+		Subject: e.Subject,
+		Header: func(name string) []string {
+			var values []string
+			for _, h := range e.Headers {
+				if strings.EqualFold(h.Name, name) {
+					values = append(values, h.Value)
+				}
+			}
+			return values
+		},
+		Body:  e.Preview,
+		Size:  e.Size,
+		Parts: &parts,
+	}
+}
+
+// jmapMessageParts describes the attachments property. The calendar part is
+// returned so its invite can be downloaded once the message has matched.
+func jmapMessageParts(e jmapEmail) (messageParts, *jmapBodyPart) {
+	parts := messageParts{Size: e.Size}
+	var calendar *jmapBodyPart
+	for i, a := range e.Attachments {
+		mediaType := strings.ToLower(a.Type)
+		if isCalendarType(mediaType) && calendar == nil {
+			parts.Calendar = true
+			calendar = &e.Attachments[i]
+			if a.Name == "" {
+				continue
+			}
+		}
+		if a.CID != "" && a.Disposition != "attachment" {
+			continue
+		}
+		parts.Attachments = append(parts.Attachments, attachmentInfo{Name: a.Name, Type: mediaType, Size: a.Size})
+	}
+	return parts, calendar
+}
+
+func jmapSenders(e jmapEmail) []senderAddress {
+	var senders []senderAddress
+	for _, list := range [][]jmapAddress{e.From, e.Sender, e.ReplyTo} {
+		for _, a := range list {
+			senders = append(senders, senderAddress{Name: a.Name, Address: a.Email})
+		}
+	}
+	return senders
+}
+
+// jmapMessageIDs formats JMAP message IDs, which come without angle brackets,
+// as they appear in the header.
+func jmapMessageIDs(ids []string) string {
+	var b strings.Builder
+	for i, id := range ids {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString("<" + id + ">")
+	}
+	return b.String()
+}
+
+func jmapStateFile(acc *AccountConfig) string {
+	return filepath.Join(historyDir, sanitizeFilename(acc.Email)+"-jmap.json")
+}
+
+func loadJMAPState(acc *AccountConfig) string {
+	data, err := os.ReadFile(jmapStateFile(acc))
+	if err != nil {
+		return ""
+	}
+	var saved struct {
+		EmailState string `json:"email_state"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return ""
+	}
+	return saved.EmailState
+}
+
+func saveJMAPState(acc *AccountConfig, state string) error {
+	data, err := json.MarshalIndent(map[string]string{"email_state": state}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(jmapStateFile(acc), data, 0644)
+}
+
+func checkNewEmailsJMAP(acc *AccountConfig) error {
+	c, err := connectToJMAP(acc)
+	if err != nil {
+		acc.logger().Warn("Connect error", "error", err)
+		return err
+	}
+
+	mailboxes, err := c.mailboxes()
+	if err != nil {
+		acc.logger().Warn("Mailbox/get error", "error", err)
+		return err
+	}
+
+	names := jmapFolderNames(mailboxes)
+	selected := make(map[string]bool)
+	for _, folder := range selectFolders(acc, func() []string { return listJMAPFolders(mailboxes) }) {
+		selected[folder] = true
+	}
+
+	totalUnread := 0
+	var mailboxIDs []string
+	for _, mb := range mailboxes {
+		if selected[names[mb.ID]] {
+			totalUnread += mb.UnreadEmails
+			mailboxIDs = append(mailboxIDs, mb.ID)
+		}
+	}
+
+	acc.mu.Lock()
+	if acc.jmapState == "" {
+		acc.jmapState = loadJMAPState(acc)
+	}
+	state := acc.jmapState
+	acc.mu.Unlock()
+
+	var emails []jmapEmail
+	if state != "" {
+		emails, state, err = c.changedEmails(state)
+		if methodErr, ok := err.(*jmapMethodError); ok && methodErr.Type == "cannotCalculateChanges" {
+			acc.logger().Info("JMAP state expired, resyncing")
+			state = ""
+		} else if err != nil {
+			acc.logger().Warn("Email/changes error", "error", err)
+			return err
+		}
+	}
+	if state == "" && len(mailboxIDs) > 0 {
+		emails, state, err = c.initialEmails(mailboxIDs)
+		if err != nil {
+			acc.logger().Warn("Email/query error", "error", err)
+			return err
+		}
+	}
+
+	newNotifications := false
+	byFolder := make(map[string][]matchedMessage)
+	var folderOrder []string
+
+	for _, e := range emails {
+		if e.Keywords["$seen"] || e.Keywords["$draft"] {
+			continue
+		}
+		folder := ""
+		for id := range e.MailboxIDs {
+			if selected[names[id]] {
+				folder = names[id]
+				break
+			}
+		}
+		if folder == "" {
+			continue
+		}
+
+		emailID := "jmap-" + e.ID
+		acc.mu.Lock()
+		alreadyNotified := acc.notifiedEmails[emailID]
+		acc.mu.Unlock()
+		if alreadyNotified {
+			continue
+		}
+		metrics.messageScanned(acc.Email)
+
+		in := jmapFilterInput(e)
+		if ok, rule := filterMessage(acc, in); ok {
+			m := newJMAPMatch(folder, e)
+			m.Rule = rule
+			m.Auth = assessSender(in)
+			m.Parts = *in.Parts
+			if _, calendar := jmapMessageParts(e); calendar != nil {
+				if data, err := c.download(calendar.BlobID, calendar.Type, maxCalendarBytes); err == nil {
+					m.Parts.Invite = parseCalendar(string(data))
+					if m.Parts.Invite != nil {
+						m.Parts.CalendarMethod = m.Parts.Invite.Method
+					}
+				}
+			}
+			m.Priority = resolvePriority(acc, m, headerPriority(e.header))
+			if acc.ShowSnippet || acc.DetectCodes {
+				setSnippetAndCode(acc, &m, cleanSnippet(e.Preview))
+			}
+			if _, ok := byFolder[folder]; !ok {
+				folderOrder = append(folderOrder, folder)
+			}
+			byFolder[folder] = append(byFolder[folder], m)
+		}
+
+		acc.mu.Lock()
+		acc.notifiedEmails[emailID] = true
+		acc.mu.Unlock()
+		newNotifications = true
+	}
+
+	for _, folder := range folderOrder {
+		notifyMatches(acc, folder, byFolder[folder])
+	}
+
+	acc.mu.Lock()
+	acc.jmapState = state
+	acc.lastCheckTime = time.Now()
+	acc.unreadCount = totalUnread
+	acc.mu.Unlock()
+
+	if state != "" {
+		if err := saveJMAPState(acc, state); err != nil {
+			acc.logger().Error("Failed to save JMAP state", "error", err)
+		}
+	}
+	if newNotifications {
+		saveNotifiedEmails(acc)
+	}
+
+	return nil
+}
+
+// watchJMAPPush listens on the session's EventSource endpoint (RFC 8620
+// section 7.3) and signals when the Email state changes. It reconnects with
+// a delay that grows while connecting fails and starts over once a
+// connection was made; the check interval keeps working meanwhile.
+func watchJMAPPush(acc *AccountConfig) (<-chan struct{}, func()) {
+	changes := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	go func() {
+		delay := jmapPushMinDelay
+		for {
+			connected, err := listenJMAPEvents(acc, changes, done)
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if connected {
+				delay = jmapPushMinDelay
+			}
+			if err != nil {
+				acc.logger().Warn("JMAP push error, retrying", "delay", delay.String(), "error", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(delay):
+			}
+			if delay < jmapPushMaxDelay {
+				delay *= 2
+			}
+		}
+	}()
+
+	return changes, func() { close(done) }
+}
+
+// listenJMAPEvents reads the event stream until it ends, and reports whether
+// it got connected at all.
+func listenJMAPEvents(acc *AccountConfig, changes chan<- struct{}, done <-chan struct{}) (bool, error) {
+	c, err := connectToJMAP(acc)
+	if err != nil {
+		return false, err
+	}
+	if c.session.EventSourceURL == "" {
+		return false, fmt.Errorf("server has no eventSourceUrl")
+	}
+
+	url := strings.NewReplacer("{types}", "Email", "{closeafter}", "no", "{ping}", "300").Replace(c.session.EventSourceURL)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	c.authorize(req)
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream is long-lived, so the client timeout must not apply.
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("event source request failed: %s", resp.Status)
+	}
+
+	go func() {
+		<-done
+		resp.Body.Close()
+	}()
+
+	acc.logger().Info("JMAP push connected")
+	scanner := bufio.NewScanner(resp.Body)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:") && event == "state":
+			var data struct {
+				Changed map[string]map[string]string `json:"changed"`
+			}
+			if json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &data) == nil {
+				if _, ok := data.Changed[c.accountID()]["Email"]; ok {
+					select {
+					case changes <- struct{}{}:
+					default:
+					}
+				}
+			}
+		case line == "":
+			event = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, io.EOF
+}
+
+func testJMAPConnection(server string, port int, authType, username, secret string) (int, error) {
+	c := newJMAPClient(server, port, authType, username, secret)
+	if err := c.fetchSession(); err != nil {
+		return 0, err
+	}
+	mailboxes, err := c.mailboxes()
+	if err != nil {
+		return 0, err
+	}
+	return len(mailboxes), nil
+}
+
+func validateJMAPSettings(acc *AccountConfig) error {
+	if acc.Protocol != "jmap" {
+		return nil
+	}
+	if acc.Server == "" {
+		return fmt.Errorf("server is required for jmap accounts")
+	}
+	switch acc.AuthType {
+	case "", "basic", "bearer":
+		return nil
+	}
+	return fmt.Errorf("invalid auth_type %q (want \"basic\" or \"bearer\")", acc.AuthType)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zalando/go-keyring"
+)
+
+// jmapStub is a JMAP server with one account, an inbox, an archive and a
+// spam mailbox. It keeps a change log so that Email/changes works, and
+// answers cannotCalculateChanges for states older than minState.
+type jmapStub struct {
+	mu       sync.Mutex
+	emails   map[string]*jmapEmail
+	state    int
+	minState int
+	changes  []jmapStubChange
+	methods  []string
+}
+
+type jmapStubChange struct {
+	state   int
+	id      string
+	created bool
+}
+
+func newJMAPStub() *jmapStub {
+	return &jmapStub{emails: make(map[string]*jmapEmail)}
+}
+
+func (s *jmapStub) add(id, mailbox, subject string, seen bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state++
+	s.emails[id] = &jmapEmail{
+		ID:         id,
+		MailboxIDs: map[string]bool{mailbox: true},
+		Keywords:   map[string]bool{"$seen": seen},
+		From:       []jmapAddress{{Name: "Sender", Email: "sender@example.com"}},
+		Subject:    subject,
+		ReceivedAt: time.Now(),
+	}
+	s.changes = append(s.changes, jmapStubChange{state: s.state, id: id, created: true})
+}
+
+func (s *jmapStub) update(id string, f func(e *jmapEmail)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state++
+	f(s.emails[id])
+	s.changes = append(s.changes, jmapStubChange{state: s.state, id: id})
+}
+
+// forget drops the change log, as servers do after a while.
+func (s *jmapStub) forget() {
+	s.mu.Lock()
+	s.minState = s.state
+	s.mu.Unlock()
+}
+
+func (s *jmapStub) calledMethods() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	methods := s.methods
+	s.methods = nil
+	return methods
+}
+
+func (s *jmapStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/.well-known/jmap":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"apiUrl":          "/api",
+			"eventSourceUrl":  "/events?types={types}&closeafter={closeafter}&ping={ping}",
+			"primaryAccounts": map[string]string{jmapMailCapability: "a1"},
+			"state":           "session",
+		})
+	case "/api":
+		s.serveAPI(w, r)
+	case "/events":
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: state\ndata: {\"changed\":{\"a1\":{\"Email\":\"s9\"}}}\n\n")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *jmapStub) serveAPI(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MethodCalls [][3]json.RawMessage `json:"methodCalls"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make(map[string]map[string]interface{})
+	var responses []interface{}
+	for _, call := range req.MethodCalls {
+		var name, id string
+		var args map[string]json.RawMessage
+		json.Unmarshal(call[0], &name)
+		json.Unmarshal(call[1], &args)
+		json.Unmarshal(call[2], &id)
+		s.methods = append(s.methods, name)
+
+		result, errType := s.method(name, args, results)
+		if errType != "" {
+			responses = append(responses, []interface{}{"error", map[string]string{"type": errType}, id})
+			continue
+		}
+		results[id] = result
+		responses = append(responses, []interface{}{name, result, id})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"methodResponses": responses, "sessionState": "session"})
+}
+
+func (s *jmapStub) method(name string, args map[string]json.RawMessage, results map[string]map[string]interface{}) (map[string]interface{}, string) {
+	state := "s" + strconv.Itoa(s.state)
+	switch name {
+	case "Mailbox/get":
+		unread := make(map[string]int)
+		for _, e := range s.emails {
+			for mb := range e.MailboxIDs {
+				if !e.Keywords["$seen"] {
+					unread[mb]++
+				}
+			}
+		}
+		return map[string]interface{}{"state": state, "list": []jmapMailbox{
+			{ID: "inbox", Name: "Inbox", Role: "inbox", UnreadEmails: unread["inbox"]},
+			{ID: "archive", Name: "Archive", Role: "archive", UnreadEmails: unread["archive"]},
+			{ID: "spam", Name: "Spam", Role: "junk", UnreadEmails: unread["spam"]},
+		}}, ""
+
+	case "Email/query":
+		// The filter is (inMailbox OR ...) AND notKeyword $seen.
+		var filter struct {
+			Conditions []struct {
+				Conditions []struct {
+					InMailbox string `json:"inMailbox"`
+				} `json:"conditions"`
+			} `json:"conditions"`
+		}
+		json.Unmarshal(args["filter"], &filter)
+		mailboxes := make(map[string]bool)
+		if len(filter.Conditions) > 0 {
+			for _, c := range filter.Conditions[0].Conditions {
+				mailboxes[c.InMailbox] = true
+			}
+		}
+		ids := []string{}
+		for id, e := range s.emails {
+			for mb := range e.MailboxIDs {
+				if mailboxes[mb] && !e.Keywords["$seen"] {
+					ids = append(ids, id)
+				}
+			}
+		}
+		sort.Strings(ids)
+		return map[string]interface{}{"queryState": state, "ids": ids}, ""
+
+	case "Email/changes":
+		var since string
+		json.Unmarshal(args["sinceState"], &since)
+		n, err := strconv.Atoi(strings.TrimPrefix(since, "s"))
+		if err != nil || n < s.minState || n > s.state {
+			return nil, "cannotCalculateChanges"
+		}
+		created, updated := []string{}, []string{}
+		isNew := make(map[string]bool)
+		for _, c := range s.changes {
+			if c.state <= n {
+				continue
+			}
+			switch {
+			case c.created:
+				isNew[c.id] = true
+				created = append(created, c.id)
+			case !isNew[c.id] && !contains(updated, c.id):
+				updated = append(updated, c.id)
+			}
+		}
+		return map[string]interface{}{
+			"oldState": since, "newState": state, "hasMoreChanges": false,
+			"created": created, "updated": updated, "destroyed": []string{},
+		}, ""
+
+	case "Email/get":
+		var ref struct {
+			ResultOf string `json:"resultOf"`
+			Path     string `json:"path"`
+		}
+		json.Unmarshal(args["#ids"], &ref)
+		ids, _ := results[ref.ResultOf][strings.TrimPrefix(ref.Path, "/")].([]string)
+		list := []jmapEmail{}
+		for _, id := range ids {
+			list = append(list, *s.emails[id])
+		}
+		return map[string]interface{}{"state": state, "list": list}, ""
+	}
+	return nil, "unknownMethod"
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jmapTestAccount is an account on the stub whose notifications are
+// recorded as dropped, so no desktop notification is shown.
+func jmapTestAccount(t *testing.T, server string) *AccountConfig {
+	t.Helper()
+	useTempAppDir(t)
+	keyring.MockInit()
+	acc := &AccountConfig{
+		Email:          "jmap-test@example.com",
+		Protocol:       "jmap",
+		Server:         server,
+		Username:       "user",
+		FolderMode:     "exclude",
+		ExcludeFolders: []string{"Spam"},
+		QuietHours: &QuietHours{
+			Schedules: []QuietSchedule{{Start: "00:00", End: "00:00"}},
+			Action:    "drop",
+		},
+		notifiedEmails: make(map[string]bool),
+	}
+	if err := setPassword(acc.Email, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	return acc
+}
+
+func checkJMAPSubjects(t *testing.T, acc *AccountConfig) []string {
+	t.Helper()
+	start := time.Now()
+	if err := checkNewEmailsJMAP(acc); err != nil {
+		t.Fatalf("checkNewEmailsJMAP: %v", err)
+	}
+	var subjects []string
+	for _, rec := range notificationsSince(acc.Email, start) {
+		subjects = append(subjects, rec.Subject)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
+
+func TestJMAPCheck(t *testing.T) {
+	stub := newJMAPStub()
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+	acc := jmapTestAccount(t, srv.URL)
+
+	stub.add("e1", "inbox", "unread in inbox", false)
+	stub.add("e2", "inbox", "read in inbox", true)
+	stub.add("e3", "spam", "unread in spam", false)
+
+	// The first check queries unseen mail in the watched mailboxes.
+	if got := checkJMAPSubjects(t, acc); strings.Join(got, ",") != "unread in inbox" {
+		t.Errorf("first check notified %q", got)
+	}
+	if got := stub.calledMethods(); !contains(got, "Email/query") || contains(got, "Email/changes") {
+		t.Errorf("first check called %v, want Email/query", got)
+	}
+	if got := loadJMAPState(acc); got != "s3" {
+		t.Errorf("saved state = %q, want s3", got)
+	}
+
+	// After a restart the saved state is used with Email/changes. New mail
+	// and mail moved into the inbox notify; mail marked read does not.
+	acc.jmapState = ""
+	stub.add("e4", "inbox", "new in inbox", false)
+	stub.update("e3", func(e *jmapEmail) { e.MailboxIDs = map[string]bool{"inbox": true} })
+	stub.update("e1", func(e *jmapEmail) { e.Keywords["$seen"] = true })
+	stub.add("e5", "spam", "new in spam", false)
+
+	if got := checkJMAPSubjects(t, acc); strings.Join(got, ",") != "new in inbox,unread in spam" {
+		t.Errorf("second check notified %q", got)
+	}
+	if got := stub.calledMethods(); !contains(got, "Email/changes") || contains(got, "Email/query") {
+		t.Errorf("second check called %v, want Email/changes", got)
+	}
+	if got := loadJMAPState(acc); got != "s7" {
+		t.Errorf("saved state = %q, want s7", got)
+	}
+
+	// Nothing changed.
+	if got := checkJMAPSubjects(t, acc); len(got) != 0 {
+		t.Errorf("third check notified %q", got)
+	}
+
+	// When the server can no longer calculate changes, the account resyncs
+	// without notifying twice.
+	stub.add("e6", "archive", "new in archive", false)
+	stub.forget()
+	stub.add("e7", "inbox", "after resync", false)
+	if got := checkJMAPSubjects(t, acc); strings.Join(got, ",") != "after resync,new in archive" {
+		t.Errorf("check after resync notified %q", got)
+	}
+	if got := stub.calledMethods(); !contains(got, "Email/changes") || !contains(got, "Email/query") {
+		t.Errorf("resync called %v, want Email/changes then Email/query", got)
+	}
+	if got := loadJMAPState(acc); got != "s9" {
+		t.Errorf("saved state = %q, want s9", got)
+	}
+}
+
+func TestJMAPSessionDiscovery(t *testing.T) {
+	tests := []struct {
+		server string
+		port   int
+		want   string
+	}{
+		{"jmap.example.com", 0, "https://jmap.example.com/.well-known/jmap"},
+		{"jmap.example.com", 8443, "https://jmap.example.com:8443/.well-known/jmap"},
+		{"http://localhost:8080", 0, "http://localhost:8080/.well-known/jmap"},
+		{"https://api.example.com/jmap/session/", 0, "https://api.example.com/jmap/session"},
+	}
+	for _, tt := range tests {
+		if got := jmapSessionURL(tt.server, tt.port); got != tt.want {
+			t.Errorf("jmapSessionURL(%q, %d) = %q, want %q", tt.server, tt.port, got, tt.want)
+		}
+	}
+
+	stub := newJMAPStub()
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+	c := newJMAPClient(srv.URL, 0, "basic", "user", "secret")
+	if err := c.fetchSession(); err != nil {
+		t.Fatal(err)
+	}
+	if c.session.APIURL != srv.URL+"/api" || c.accountID() != "a1" {
+		t.Errorf("session = %+v, want the apiUrl resolved against the server", c.session)
+	}
+	if err := newJMAPClient(srv.URL, 0, "basic", "user", "wrong").fetchSession(); err == nil {
+		t.Error("fetchSession succeeded with a wrong password")
+	}
+}
+
+func TestJMAPPushEvents(t *testing.T) {
+	stub := newJMAPStub()
+	srv := httptest.NewServer(stub)
+	acc := jmapTestAccount(t, srv.URL)
+
+	changes := make(chan struct{}, 1)
+	done := make(chan struct{})
+	defer close(done)
+	connected, err := listenJMAPEvents(acc, changes, done)
+	if !connected || err != io.EOF {
+		t.Errorf("listenJMAPEvents = %v, %v, want connected until EOF", connected, err)
+	}
+	select {
+	case <-changes:
+	default:
+		t.Error("Email state change not signalled")
+	}
+
+	srv.Close()
+	if connected, err := listenJMAPEvents(acc, changes, done); connected || err == nil {
+		t.Errorf("listenJMAPEvents = %v, %v after the server went away, want an error", connected, err)
+	}
+}
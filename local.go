@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/fsnotify/fsnotify"
+)
+
+// localEventDelay coalesces the burst of events a single delivery produces
+// (tmp -> new rename, mbox lock files, ...) into one check.
+const localEventDelay = 500 * time.Millisecond
+
+func isLocalProtocol(protocol string) bool {
+	return protocol == "maildir" || protocol == "mbox"
+}
+
+func validateLocalSource(acc *AccountConfig) error {
+	if !isLocalProtocol(acc.Protocol) {
+		return nil
+	}
+	if acc.Path == "" {
+		return fmt.Errorf("path is required for %s accounts", acc.Protocol)
+	}
+	return nil
+}
+
+func expandPath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// testLocalSource checks that path looks like a mailbox of the given type and
+// returns a short description for the dashboard.
+func testLocalSource(protocol, path string) (string, error) {
+	path = expandPath(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if protocol == "mbox" {
+		if info.IsDir() {
+			return "", fmt.Errorf("%s is a directory, not an mbox file", path)
+		}
+		return fmt.Sprintf("✅ mbox file found (%d KB)", info.Size()/1024), nil
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", path)
+	}
+	if !isMaildir(path) {
+		return "", fmt.Errorf("%s has no cur/new/tmp subdirectories", path)
+	}
+	return fmt.Sprintf("✅ Maildir found with %d folders", len(listMaildirFolders(path))), nil
+}
+
+func isMaildir(dir string) bool {
+	for _, sub := range []string{"cur", "new"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// listMaildirFolders returns "INBOX" for the root plus every Maildir++
+// subfolder, named as on disk without the leading dot (e.g. "Lists.golang").
+func listMaildirFolders(root string) []string {
+	folders := []string{"INBOX"}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return folders
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || !strings.HasPrefix(name, ".") || name == "." || name == ".." {
+			continue
+		}
+		if isMaildir(filepath.Join(root, name)) {
+			folders = append(folders, strings.TrimPrefix(name, "."))
+		}
+	}
+	sort.Strings(folders[1:])
+	return folders
+}
+
+func maildirFolderPath(root, folder string) string {
+	if folder == "INBOX" {
+		return root
+	}
+	return filepath.Join(root, "."+folder)
+}
+
+// selectFolders applies the account's folder mode to the folders returned by
+// list, which is only called when the full list is needed.
+func selectFolders(acc *AccountConfig, list func() []string) []string {
+	switch acc.FolderMode {
+	case "include":
+		return acc.IncludeFolders
+	case "exclude":
+		excludeMap := make(map[string]bool)
+		for _, f := range acc.ExcludeFolders {
+			excludeMap[f] = true
+		}
+		var result []string
+		for _, f := range list() {
+			if !excludeMap[f] {
+				result = append(result, f)
+			}
+		}
+		return result
+	default:
+		return list()
+	}
+}
+
+func checkLocalSource(acc *AccountConfig) error {
+	if acc.Protocol == "mbox" {
+		return checkMbox(acc)
+	}
+	return checkMaildir(acc)
+}
+
+// maildirUniq returns the part of a Maildir filename that stays the same when
+// the message moves from new/ to cur/ and its flags change.
+func maildirUniq(name string) string {
+	if i := strings.Index(name, ":"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func maildirSeen(name string) bool {
+	i := strings.Index(name, ":2,")
+	return i >= 0 && strings.Contains(name[i+3:], "S")
+}
+
+func checkMaildir(acc *AccountConfig) error {
+	root := expandPath(acc.Path)
+	if !isMaildir(root) {
+		err := fmt.Errorf("%s is not a Maildir", root)
+		acc.logger().Warn("Local source error", "error", err)
+		return err
+	}
+
+	folders := selectFolders(acc, func() []string { return listMaildirFolders(root) })
+	totalUnread := 0
+	newNotifications := false
+
+	for _, folder := range folders {
+		dir := maildirFolderPath(root, folder)
+
+		var unread []string
+		for _, sub := range []string{"new", "cur"} {
+			entries, err := os.ReadDir(filepath.Join(dir, sub))
+			if err != nil {
+				if sub == "new" {
+					acc.logger().Warn("Read error", "folder", folder, "error", err)
+				}
+				continue
+			}
+			for _, e := range entries {
+				if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+					continue
+				}
+				if sub == "new" || !maildirSeen(e.Name()) {
+					unread = append(unread, filepath.Join(dir, sub, e.Name()))
+				}
+			}
+		}
+		totalUnread += len(unread)
+
+		var matches []matchedMessage
+		for _, file := range unread {
+			emailID := fmt.Sprintf("maildir-%s-%s", folder, maildirUniq(filepath.Base(file)))
+
+			acc.mu.Lock()
+			alreadyNotified := acc.notifiedEmails[emailID]
+			acc.mu.Unlock()
+			if alreadyNotified {
+				continue
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				// Moved to cur/ (or deleted) since the directory was read;
+				// the next check picks it up under its new name.
+				continue
+			}
+			var size int64
+			if info, err := f.Stat(); err == nil {
+				size = info.Size()
+			}
+			m, ok := localMatch(acc, folder, f, size)
+			f.Close()
+
+			acc.mu.Lock()
+			acc.notifiedEmails[emailID] = true
+			acc.mu.Unlock()
+			newNotifications = true
+
+			if ok {
+				m.Ref = maildirUniq(filepath.Base(file))
+				matches = append(matches, m)
+			}
+		}
+
+		notifyMatches(acc, folder, matches)
+	}
+
+	acc.mu.Lock()
+	acc.lastCheckTime = time.Now()
+	acc.unreadCount = totalUnread
+	acc.mu.Unlock()
+
+	if newNotifications {
+		saveNotifiedEmails(acc)
+	}
+
+	return nil
+}
+
+// localMatch parses a message and applies the account's filters. It reports
+// false if the message is unparsable or filtered out.
+func localMatch(acc *AccountConfig, folder string, r io.Reader, size int64) (matchedMessage, bool) {
+	e, err := message.Read(r)
+	if e == nil || (err != nil && !message.IsUnknownCharset(err)) {
+		acc.logger().Warn("Failed to parse message", "folder", folder, "error", err)
+		return matchedMessage{}, false
+	}
+
+	header := entityHeader(e.Header)
+	text, parts := messageContent(e)
+	parts.Size = size
+
+	metrics.messageScanned(acc.Email)
+	in := entityFilterInput(e.Header, text)
+	in.Size = size
+	in.Parts = &parts
+	ok, rule := filterMessage(acc, in)
+	if !ok {
+		return matchedMessage{}, false
+	}
+
+	m := newPOP3Match(header)
+	m.Folder = folder
+	m.Rule = rule
+	m.Auth = assessSender(in)
+	m.Parts = parts
+	m.Priority = resolvePriority(acc, m, headerPriority(header))
+	if acc.ShowSnippet || acc.DetectCodes {
+		setSnippetAndCode(acc, &m, cleanSnippet(text))
+	}
+	return m, true
+}
+
+// entityHeader returns a header lookup that decodes encoded words, falling
+// back to the raw value.
+func entityHeader(h message.Header) func(string) string {
+	return func(k string) string {
+		if v, err := h.Text(k); err == nil {
+			return v
+		}
+		return h.Get(k)
+	}
+}
+
+// mboxMessage is one message of an mbox file, without its "From " line.
+type mboxMessage struct {
+	raw []byte
+}
+
+func (msg mboxMessage) header() (message.Header, error) {
+	e, err := message.Read(bytes.NewReader(msg.raw))
+	if e == nil {
+		return message.Header{}, err
+	}
+	return e.Header, nil
+}
+
+// splitMbox splits data at "From " lines that follow a blank line. If the last
+// message does not end with a newline it is still being written; it is left
+// out and its offset returned so the next check starts there.
+func splitMbox(data []byte) ([]mboxMessage, int) {
+	var msgs []mboxMessage
+	start := -1
+	consumed := 0
+	prevBlank := true
+
+	pos := 0
+	for pos < len(data) {
+		end := bytes.IndexByte(data[pos:], '\n')
+		if end < 0 {
+			break
+		}
+		line := data[pos : pos+end+1]
+
+		if prevBlank && bytes.HasPrefix(line, []byte("From ")) {
+			if start >= 0 {
+				msgs = append(msgs, mboxMessage{raw: data[start:pos]})
+				consumed = pos
+			}
+			start = pos + len(line)
+		}
+		prevBlank = len(bytes.TrimRight(line, "\r\n")) == 0
+		pos += end + 1
+	}
+
+	if start >= 0 && pos == len(data) {
+		msgs = append(msgs, mboxMessage{raw: data[start:]})
+		consumed = len(data)
+	} else if start < 0 {
+		consumed = pos
+	}
+	return msgs, consumed
+}
+
+func mboxMessageID(header message.Header, raw []byte) string {
+	if id := strings.TrimSpace(header.Get("Message-Id")); id != "" {
+		return id
+	}
+	head := raw
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	return fmt.Sprintf("%x", sha1.Sum(head))
+}
+
+func readFrom(f *os.File, offset int64) ([]byte, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(bufio.NewReader(f))
+}
+
+func checkMbox(acc *AccountConfig) error {
+	path := expandPath(acc.Path)
+	f, err := os.Open(path)
+	if err != nil {
+		acc.logger().Warn("Open mbox error", "error", err)
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Only the part appended since the last check is parsed. A file that was
+	// rewritten (a mail client expunging or marking messages read) is
+	// rescanned from the start; notified Message-IDs keep that from producing
+	// duplicates.
+	acc.mu.Lock()
+	offset := acc.mboxOffset
+	unread := acc.mboxUnread
+	if info.Size() < offset || (info.Size() == offset && !info.ModTime().Equal(acc.mboxModTime)) {
+		offset, unread = 0, 0
+	}
+	acc.mu.Unlock()
+
+	data, err := readFrom(f, offset)
+	if err == nil && offset > 0 && len(data) > 0 && !bytes.HasPrefix(data, []byte("From ")) {
+		offset, unread = 0, 0
+		data, err = readFrom(f, 0)
+	}
+	if err != nil {
+		acc.logger().Warn("Read mbox error", "error", err)
+		return err
+	}
+
+	msgs, consumed := splitMbox(data)
+	newNotifications := false
+	var matches []matchedMessage
+
+	for _, msg := range msgs {
+		header, err := msg.header()
+		if err != nil && header.Len() == 0 {
+			continue
+		}
+		// Status is maintained by mail clients: R marks a message as read.
+		if strings.Contains(header.Get("Status"), "R") {
+			continue
+		}
+		unread++
+
+		emailID := "mbox-" + mboxMessageID(header, msg.raw)
+
+		acc.mu.Lock()
+		alreadyNotified := acc.notifiedEmails[emailID]
+		acc.mu.Unlock()
+		if alreadyNotified {
+			continue
+		}
+
+		m, ok := localMatch(acc, "INBOX", bytes.NewReader(msg.raw), int64(len(msg.raw)))
+
+		acc.mu.Lock()
+		acc.notifiedEmails[emailID] = true
+		acc.mu.Unlock()
+		newNotifications = true
+
+		if ok {
+			matches = append(matches, m)
+		}
+	}
+
+	notifyMatches(acc, "INBOX", matches)
+
+	acc.mu.Lock()
+	acc.mboxOffset = offset + int64(consumed)
+	acc.mboxUnread = unread
+	acc.mboxModTime = info.ModTime()
+	acc.lastCheckTime = time.Now()
+	acc.unreadCount = unread
+	acc.mu.Unlock()
+
+	if newNotifications {
+		saveNotifiedEmails(acc)
+	}
+
+	return nil
+}
+
+// watchLocalSource watches the account's Maildir folders or mbox file and
+// signals on the returned channel when they change. The returned function
+// stops the watcher. If watching is not possible the channel is nil and the
+// account falls back to its check interval.
+func watchLocalSource(acc *AccountConfig) (<-chan struct{}, func()) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		acc.logger().Warn("File watcher unavailable, polling instead", "interval", acc.CheckInterval, "error", err)
+		return nil, func() {}
+	}
+
+	root := expandPath(acc.Path)
+	addWatches := func() {
+		if acc.Protocol == "mbox" {
+			// Watch the directory: mail clients often replace the file,
+			// which would silently drop a watch on the file itself.
+			watcher.Add(filepath.Dir(root))
+			return
+		}
+		watcher.Add(root)
+		for _, folder := range selectFolders(acc, func() []string { return listMaildirFolders(root) }) {
+			dir := maildirFolderPath(root, folder)
+			for _, sub := range []string{"new", "cur"} {
+				if err := watcher.Add(filepath.Join(dir, sub)); err != nil {
+					acc.logger().Warn("Watch error", "folder", folder, "error", err)
+				}
+			}
+		}
+	}
+	addWatches()
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					if timer != nil {
+						timer.Stop()
+					}
+					return
+				}
+				if acc.Protocol == "mbox" && filepath.Clean(event.Name) != filepath.Clean(root) {
+					continue
+				}
+				// A new Maildir++ folder was created next to the others.
+				if acc.Protocol == "maildir" && filepath.Dir(event.Name) == filepath.Clean(root) && event.Has(fsnotify.Create) {
+					addWatches()
+				}
+				if timer == nil {
+					timer = time.AfterFunc(localEventDelay, func() {
+						select {
+						case changes <- struct{}{}:
+						default:
+						}
+					})
+				} else {
+					timer.Reset(localEventDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				acc.logger().Warn("File watcher error", "error", err)
+			}
+		}
+	}()
+
+	return changes, func() { watcher.Close() }
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogMaxSizeMB  = 10
+	defaultLogMaxBackups = 5
+	defaultLogMaxAgeDays = 7
+	maxRecentLogEntries  = 1000
+)
+
+type LogSettings struct {
+	Level      string `json:"level,omitempty"` // "debug", "info" (default), "warn" or "error"
+	MaxSizeMB  int    `json:"max_size_mb,omitempty"`
+	MaxBackups *int   `json:"max_backups,omitempty"` // 0 keeps no backups; a pointer to tell that from unset
+	MaxAgeDays int    `json:"max_age_days,omitempty"`
+}
+
+type logEntry struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Account string            `json:"account,omitempty"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+var (
+	logLevel     = new(slog.LevelVar)
+	logWriter    *rotatingWriter
+	recentLogs   []logEntry
+	recentLogsMu sync.Mutex
+)
+
+// logger returns a logger that tags every entry with the account.
+func (acc *AccountConfig) logger() *slog.Logger {
+	return slog.With("account", acc.Email)
+}
+
+// fatal logs at error level and exits, like log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+func validateLogSettings(ls *LogSettings) error {
+	if ls == nil {
+		return nil
+	}
+	if _, err := parseLogLevel(ls.Level); err != nil {
+		return err
+	}
+	if ls.MaxSizeMB < 0 || (ls.MaxBackups != nil && *ls.MaxBackups < 0) || ls.MaxAgeDays < 0 {
+		return fmt.Errorf("max_size_mb, max_backups and max_age_days must not be negative")
+	}
+	return nil
+}
+
+// rotatingWriter appends to path and rotates it to path.1, path.2, ... when it
+// grows past maxSize or gets older than maxAge.
+type rotatingWriter struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	size       int64
+	opened     time.Time
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+}
+
+func (w *rotatingWriter) configure(ls LogSettings) {
+	if ls.MaxSizeMB == 0 {
+		ls.MaxSizeMB = defaultLogMaxSizeMB
+	}
+	maxBackups := defaultLogMaxBackups
+	if ls.MaxBackups != nil {
+		maxBackups = *ls.MaxBackups
+	}
+	if ls.MaxAgeDays == 0 {
+		ls.MaxAgeDays = defaultLogMaxAgeDays
+	}
+
+	w.mu.Lock()
+	w.maxSize = int64(ls.MaxSizeMB) << 20
+	w.maxBackups = maxBackups
+	w.maxAge = time.Duration(ls.MaxAgeDays) * 24 * time.Hour
+	w.mu.Unlock()
+}
+
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	// Older versions created the log world-readable.
+	f.Chmod(0600)
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	w.opened = time.Now()
+	if w.size > 0 {
+		w.opened = firstEntryTime(w.path, info.ModTime())
+	}
+	return nil
+}
+
+// firstEntryTime returns the time of the oldest entry in the log, which is
+// when it was started. Every write moves the modification time, so that is
+// only the fallback for logs that do not start with a JSON entry.
+func firstEntryTime(path string, fallback time.Time) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		return fallback
+	}
+	defer f.Close()
+
+	line, _ := bufio.NewReaderSize(io.LimitReader(f, 64*1024), 64*1024).ReadSlice('\n')
+	var entry struct {
+		Time time.Time `json:"time"`
+	}
+	if json.Unmarshal(line, &entry) != nil || entry.Time.IsZero() {
+		return fallback
+	}
+	return entry.Time
+}
+
+func (w *rotatingWriter) rotate() error {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+
+	if w.maxBackups == 0 {
+		os.Remove(w.path)
+		return w.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", w.path, w.maxBackups))
+	for i := w.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+	os.Rename(w.path, w.path+".1")
+	return w.open()
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.size > 0 && (w.size+int64(len(p)) > w.maxSize || time.Since(w.opened) > w.maxAge) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// recentLogHandler keeps the latest entries in memory for /api/logs and
+// passes every record on to the file handler.
+type recentLogHandler struct {
+	next  slog.Handler
+	attrs []slog.Attr
+}
+
+func (h *recentLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *recentLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	entry := logEntry{Time: r.Time, Level: r.Level.String(), Message: r.Message}
+	add := func(a slog.Attr) bool {
+		if a.Key == "account" {
+			entry.Account = a.Value.String()
+			return true
+		}
+		if entry.Attrs == nil {
+			entry.Attrs = make(map[string]string)
+		}
+		entry.Attrs[a.Key] = a.Value.String()
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	recentLogsMu.Lock()
+	recentLogs = append(recentLogs, entry)
+	if len(recentLogs) > maxRecentLogEntries {
+		recentLogs = recentLogs[len(recentLogs)-maxRecentLogEntries:]
+	}
+	recentLogsMu.Unlock()
+
+	return h.next.Handle(ctx, r)
+}
+
+func (h *recentLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &recentLogHandler{next: h.next.WithAttrs(attrs), attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *recentLogHandler) WithGroup(name string) slog.Handler {
+	return &recentLogHandler{next: h.next.WithGroup(name), attrs: h.attrs}
+}
+
+// setupLogging writes JSON lines to the log file. It runs before the config
+// is loaded, so applyLogSettings adjusts the level and rotation afterwards.
+func setupLogging() {
+	logWriter = &rotatingWriter{path: logFile}
+	logWriter.configure(LogSettings{})
+
+	var out io.Writer = logWriter
+	if err := logWriter.open(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+		out = os.Stderr
+	}
+
+	handler := slog.NewJSONHandler(out, &slog.HandlerOptions{Level: logLevel})
+	slog.SetDefault(slog.New(&recentLogHandler{next: handler}))
+}
+
+func applyLogSettings() {
+	ls := LogSettings{}
+	if config.Logging != nil {
+		ls = *config.Logging
+	}
+	level, _ := parseLogLevel(ls.Level)
+	logLevel.Set(level)
+	logWriter.configure(ls)
+}
+
+func handleLogs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	minLevel := slog.LevelDebug
+	if s := r.URL.Query().Get("level"); s != "" {
+		level, err := parseLogLevel(s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+			return
+		}
+		minLevel = level
+	}
+	account := r.URL.Query().Get("account")
+	tail, err := strconv.Atoi(r.URL.Query().Get("tail"))
+	if err != nil || tail <= 0 || tail > maxRecentLogEntries {
+		tail = 200
+	}
+
+	recentLogsMu.Lock()
+	var entries []logEntry
+	for i := len(recentLogs) - 1; i >= 0 && len(entries) < tail; i-- {
+		e := recentLogs[i]
+		var level slog.Level
+		level.UnmarshalText([]byte(e.Level))
+		if level < minLevel || (account != "" && e.Account != account) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	recentLogsMu.Unlock()
+
+	// Oldest first, like the log file.
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if entries == nil {
+		entries = []logEntry{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"level":   strings.ToLower(logLevel.Level().String()),
+		"entries": entries,
+	})
+}
+
+// handleLogLevel changes the level at runtime and saves it to the config.
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	level, err := parseLogLevel(req.Level)
+	if err != nil || req.Level == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Invalid level"})
+		return
+	}
+
+	logLevel.Set(level)
+	if config.Logging == nil {
+		config.Logging = &LogSettings{}
+	}
+	config.Logging.Level = strings.ToLower(level.String())
+	if err := saveConfig(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Log level changed", "level", config.Logging.Level)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Log level set to " + config.Logging.Level})
+}
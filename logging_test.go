@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingWriterMaxAge(t *testing.T) {
+	tests := []struct {
+		name    string
+		content func(started time.Time) string
+		started time.Duration // before now
+		rotate  bool
+	}{
+		{
+			name:    "started recently",
+			content: jsonLog,
+			started: 24 * time.Hour,
+		},
+		{
+			name:    "started before max age",
+			content: jsonLog,
+			started: 8 * 24 * time.Hour,
+			rotate:  true,
+		},
+		{
+			name:    "not JSON, written recently",
+			content: func(time.Time) string { return "2020/01/01 00:00:00 old format\n" },
+			started: 30 * 24 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "email-monitor.log")
+			// Appended to a minute ago, which is what ModTime reports.
+			if err := os.WriteFile(path, []byte(tt.content(time.Now().Add(-tt.started))), 0600); err != nil {
+				t.Fatal(err)
+			}
+			recent := time.Now().Add(-time.Minute)
+			os.Chtimes(path, recent, recent)
+
+			w := &rotatingWriter{path: path}
+			w.configure(LogSettings{MaxAgeDays: 7})
+			if err := w.open(); err != nil {
+				t.Fatal(err)
+			}
+			defer w.file.Close()
+			if _, err := w.Write([]byte("{}\n")); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := os.Stat(path + ".1")
+			if rotated := err == nil; rotated != tt.rotate {
+				t.Errorf("rotated = %v, want %v", rotated, tt.rotate)
+			}
+		})
+	}
+}
+
+func jsonLog(started time.Time) string {
+	return fmt.Sprintf(`{"time":%q,"level":"INFO","msg":"first"}`+"\n"+`{"time":%q,"level":"INFO","msg":"latest"}`+"\n",
+		started.Format(time.RFC3339Nano), time.Now().Add(-time.Minute).Format(time.RFC3339Nano))
+}
+
+func TestRotatingWriterMaxBackups(t *testing.T) {
+	zero, two := 0, 2
+	tests := []struct {
+		name       string
+		maxBackups *int
+		want       []string
+	}{
+		{"default", nil, []string{"app.log", "app.log.1", "app.log.2", "app.log.3"}},
+		{"two", &two, []string{"app.log", "app.log.1", "app.log.2"}},
+		{"none", &zero, []string{"app.log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := &rotatingWriter{path: filepath.Join(dir, "app.log")}
+			w.configure(LogSettings{MaxSizeMB: 1, MaxBackups: tt.maxBackups})
+			defer func() { w.file.Close() }()
+
+			// Each write fills the log, so the next one rotates it.
+			entry := make([]byte, 1<<20)
+			for i := 0; i < 4; i++ {
+				if _, err := w.Write(entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entries, _ := os.ReadDir(dir)
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := validateLogSettings(&LogSettings{MaxBackups: &zero}); err != nil {
+		t.Errorf("max_backups 0 rejected: %v", err)
+	}
+	negative := -1
+	if err := validateLogSettings(&LogSettings{MaxBackups: &negative}); err == nil {
+		t.Error("negative max_backups accepted")
+	}
+}
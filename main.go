@@ -4,20 +4,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
-	"net"
+	"log/slog"
 	"net/http"
+	"net/mail"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message"
 	"github.com/gen2brain/beeep"
 	"github.com/getlantern/systray"
 	"github.com/knadh/go-pop3"
@@ -29,38 +31,76 @@ const (
 )
 
 type AccountConfig struct {
-	Email                   string   `json:"email"`
-	Server                  string   `json:"server"`
-	Port                    int      `json:"port"`
-	Username                string   `json:"username"`
-	Password                string   `json:"password,omitempty"`
-	Protocol                string   `json:"protocol"` // "imap" or "pop3"
-	IncludeKeyword          []string `json:"include_keyword"`
-	ExcludeKeyword          []string `json:"exclude_keyword"`
-	IncludeEmail            []string `json:"include_email"`
-	ExcludeEmail            []string `json:"exclude_email"`
-	CheckInterval           int      `json:"check_interval"`
-	CheckHistory            int      `json:"check_history"`
-	EnableNotificationSound bool     `json:"enable_notification_sound"`
-	FolderMode              string   `json:"folder_mode"`
-	IncludeFolders          []string `json:"include_folders"`
-	ExcludeFolders          []string `json:"exclude_folders"`
+	ID                      string         `json:"id,omitempty"`
+	Email                   string         `json:"email"`
+	Server                  string         `json:"server"`
+	Port                    int            `json:"port"`
+	Username                string         `json:"username"`
+	Password                string         `json:"password,omitempty"`
+	Protocol                string         `json:"protocol"` // "imap", "pop3", "jmap", "maildir" or "mbox"
+	Path                    string         `json:"path,omitempty"`
+	AuthType                string         `json:"auth_type,omitempty"` // JMAP: "basic" (default) or "bearer"
+	JMAPPush                bool           `json:"jmap_push,omitempty"`
+	IncludeKeyword          []string       `json:"include_keyword"`
+	ExcludeKeyword          []string       `json:"exclude_keyword"`
+	IncludeEmail            []string       `json:"include_email"`
+	ExcludeEmail            []string       `json:"exclude_email"`
+	CheckInterval           int            `json:"check_interval"`
+	CheckHistory            int            `json:"check_history"`
+	EnableNotificationSound bool           `json:"enable_notification_sound"`
+	FolderMode              string         `json:"folder_mode"`
+	IncludeFolders          []string       `json:"include_folders"`
+	ExcludeFolders          []string       `json:"exclude_folders"`
+	Label                   string         `json:"label,omitempty"`
+	TitleTemplate           string         `json:"title_template,omitempty"`
+	BodyTemplate            string         `json:"body_template,omitempty"`
+	ShowSnippet             bool           `json:"show_snippet,omitempty"`
+	DetectCodes             bool           `json:"detect_codes,omitempty"`
+	NormalizePlusTags       bool           `json:"normalize_plus_tags,omitempty"` // ignore "+tag" in sender addresses
+	FilterSets              []string       `json:"filter_sets,omitempty"`         // names of shared filter sets, see effectiveFilters
+	SieveScript             string         `json:"sieve_script,omitempty"`        // replaces the filters above, see sieve.go
+	WebmailURL              string         `json:"webmail_url,omitempty"`         // link template, see webmailURL
+	QuietHours              *QuietHours    `json:"quiet_hours,omitempty"`
+	Batching                *BatchSettings `json:"batching,omitempty"`
+	PriorityRules           []PriorityRule `json:"priority_rules,omitempty"`
+	Paused                  bool           `json:"paused,omitempty"`
+	SnoozeUntil             *time.Time     `json:"snooze_until,omitempty"`
 	notifiedEmails          map[string]bool
+	heldNotifications       []heldNotification
+	pendingBatches          map[string][]matchedMessage
+	digestQueue             []matchedMessage
+	lastDigest              time.Time
+	mboxOffset              int64
+	mboxUnread              int
+	mboxModTime             time.Time
+	jmapState               string
 	lastCheckTime           time.Time
 	unreadCount             int
+	lastError               string
+	monitoring              bool
+	deleting                bool // set under the global mu while handleDeleteAccount stops the monitor
 	mu                      sync.RWMutex
+	checkMu                 sync.Mutex // serializes checks of the same account
 	stopChan                chan bool
 	ticker                  *time.Ticker
 }
 
 type Config struct {
-	Accounts []AccountConfig `json:"accounts"`
+	Accounts   []AccountConfig          `json:"accounts"`
+	QuietHours *QuietHours              `json:"quiet_hours,omitempty"`
+	Priorities map[string]PriorityLevel `json:"priorities,omitempty"`
+	Web        *WebSettings             `json:"web,omitempty"`
+	Logging    *LogSettings             `json:"logging,omitempty"`
+	Mutes      []MuteRule               `json:"mutes,omitempty"`
+	Filters    *FilterSet               `json:"filters,omitempty"` // applies to every account
+	FilterSets map[string]FilterSet     `json:"filter_sets,omitempty"`
+	Trust      *TrustSettings           `json:"trust,omitempty"`
 }
 
 var (
 	config           Config
 	accountMenuItems map[string]*systray.MenuItem
-	mu               sync.RWMutex
+	mu               sync.RWMutex // held while adding or deleting accounts, see withAccount
 	appDir           string
 	configFile       string
 	logFile          string
@@ -127,9 +167,9 @@ func migratePasswordsToKeyring() {
 	for i := range config.Accounts {
 		if config.Accounts[i].Password != "" {
 			if err := setPassword(config.Accounts[i].Email, config.Accounts[i].Password); err != nil {
-				log.Printf("[%s] Failed to migrate password to keyring: %v", config.Accounts[i].Email, err)
+				config.Accounts[i].logger().Error("Failed to migrate password to keyring", "error", err)
 			} else {
-				log.Printf("[%s] Migrated password to keyring", config.Accounts[i].Email)
+				config.Accounts[i].logger().Info("Migrated password to keyring")
 				config.Accounts[i].Password = ""
 				migrated = true
 			}
@@ -138,7 +178,7 @@ func migratePasswordsToKeyring() {
 
 	if migrated {
 		if err := saveConfig(); err != nil {
-			log.Printf("Failed to save config after migration: %v", err)
+			slog.Error("Failed to save config after migration", "error", err)
 		}
 	}
 }
@@ -146,16 +186,28 @@ func migratePasswordsToKeyring() {
 func main() {
 	setupLogging()
 
-	log.Printf("Application directory: %s", appDir)
+	slog.Info("Application directory", "path", appDir)
 	fmt.Printf("📧 Email Monitor (IMAP & POP3)\n")
 	fmt.Printf("Application directory: %s\n\n", appDir)
 
 	if err := loadConfig(); err != nil {
-		log.Fatal(err)
+		fatal("Failed to load config", "error", err)
 	}
+	applyLogSettings()
 
 	migratePasswordsToKeyring()
 
+	accountsChanged := assignAccountIDs()
+	if mutesChanged := assignMuteIDs(); accountsChanged || mutesChanged {
+		if err := saveConfig(); err != nil {
+			slog.Error("Failed to save config after assigning IDs", "error", err)
+		}
+	}
+
+	if err := loadAPIToken(); err != nil {
+		fatal("Failed to load API token", "error", err)
+	}
+
 	os.MkdirAll(historyDir, 0755)
 
 	for i := range config.Accounts {
@@ -165,20 +217,13 @@ func main() {
 		cleanupOldNotifications(&config.Accounts[i])
 	}
 
-	log.Printf("Starting email monitor for %d accounts", len(config.Accounts))
+	slog.Info("Starting email monitor", "accounts", len(config.Accounts))
 
-	go startWebServer()
+	startWebServer()
 
 	systray.Run(onReady, onExit)
 }
 
-func setupLogging() {
-	f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err == nil {
-		log.SetOutput(f)
-	}
-}
-
 func loadConfig() error {
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
 		return createSampleConfig()
@@ -197,6 +242,28 @@ func loadConfig() error {
 		return fmt.Errorf("no accounts configured")
 	}
 
+	if err := validateQuietHours(config.QuietHours); err != nil {
+		return fmt.Errorf("invalid quiet_hours: %v", err)
+	}
+	if err := validatePriorityLevels(config.Priorities); err != nil {
+		return fmt.Errorf("invalid priorities: %v", err)
+	}
+	if err := validateWebSettings(config.Web); err != nil {
+		return fmt.Errorf("invalid web: %v", err)
+	}
+	if err := validateMuteRules(config.Mutes); err != nil {
+		return fmt.Errorf("invalid mutes: %v", err)
+	}
+	if err := validateFilterSets(config.Filters, config.FilterSets); err != nil {
+		return err
+	}
+	if err := validateTrustSettings(config.Trust); err != nil {
+		return fmt.Errorf("invalid trust: %v", err)
+	}
+	if err := validateLogSettings(config.Logging); err != nil {
+		return fmt.Errorf("invalid logging: %v", err)
+	}
+
 	for i := range config.Accounts {
 		if config.Accounts[i].CheckInterval == 0 {
 			config.Accounts[i].CheckInterval = 120
@@ -210,6 +277,33 @@ func loadConfig() error {
 		if config.Accounts[i].FolderMode == "" {
 			config.Accounts[i].FolderMode = "all"
 		}
+		if err := validateLocalSource(&config.Accounts[i]); err != nil {
+			return fmt.Errorf("[%s] %v", config.Accounts[i].Email, err)
+		}
+		if err := validateJMAPSettings(&config.Accounts[i]); err != nil {
+			return fmt.Errorf("[%s] %v", config.Accounts[i].Email, err)
+		}
+		if err := validateQuietHours(config.Accounts[i].QuietHours); err != nil {
+			return fmt.Errorf("[%s] invalid quiet_hours: %v", config.Accounts[i].Email, err)
+		}
+		if err := validateBatchSettings(config.Accounts[i].Batching); err != nil {
+			return fmt.Errorf("[%s] invalid batching: %v", config.Accounts[i].Email, err)
+		}
+		if err := validatePriorityRules(config.Accounts[i].PriorityRules); err != nil {
+			return fmt.Errorf("[%s] invalid priority_rules: %v", config.Accounts[i].Email, err)
+		}
+		if err := validateNotificationTemplates(config.Accounts[i].TitleTemplate, config.Accounts[i].BodyTemplate); err != nil {
+			return fmt.Errorf("[%s] %v", config.Accounts[i].Email, err)
+		}
+		if err := validateSenderPatterns(append(config.Accounts[i].IncludeEmail, config.Accounts[i].ExcludeEmail...)); err != nil {
+			return fmt.Errorf("[%s] %v", config.Accounts[i].Email, err)
+		}
+		if err := validateAccountFilterSets(config.Accounts[i].FilterSets); err != nil {
+			return fmt.Errorf("[%s] %v", config.Accounts[i].Email, err)
+		}
+		if _, err := parseSieve(config.Accounts[i].SieveScript); err != nil {
+			return fmt.Errorf("[%s] invalid sieve_script: %v", config.Accounts[i].Email, err)
+		}
 	}
 
 	return nil
@@ -247,11 +341,11 @@ func createSampleConfig() error {
 		return fmt.Errorf("failed to write sample config: %v", err)
 	}
 
-	log.Printf("Created sample config file: %s", configFile)
+	slog.Info("Created sample config file", "path", configFile)
 	fmt.Printf("✅ Sample config created: %s\n", configFile)
 	fmt.Printf("Please edit it with your email settings and restart.\n")
 	fmt.Printf("\nNote: Passwords are stored securely in your system's keyring, not in the config file.\n")
-	fmt.Printf("Supported protocols: IMAP and POP3\n")
+	fmt.Printf("Supported protocols: IMAP, POP3, JMAP, Maildir and mbox\n")
 
 	beeep.Notify("Email Monitor - Setup Required",
 		fmt.Sprintf("Config file created at:\n%s\n\nPlease edit and restart.", configFile), "")
@@ -261,7 +355,17 @@ func createSampleConfig() error {
 }
 
 func saveConfig() error {
-	configCopy := Config{Accounts: make([]AccountConfig, len(config.Accounts))}
+	configCopy := Config{
+		Accounts:   make([]AccountConfig, len(config.Accounts)),
+		QuietHours: config.QuietHours,
+		Priorities: config.Priorities,
+		Web:        config.Web,
+		Logging:    config.Logging,
+		Mutes:      currentMutes(),
+		Filters:    config.Filters,
+		FilterSets: config.FilterSets,
+		Trust:      config.Trust,
+	}
 	for i, acc := range config.Accounts {
 		configCopy.Accounts[i] = acc
 		configCopy.Accounts[i].Password = ""
@@ -271,19 +375,35 @@ func saveConfig() error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(configFile, data, 0644)
+	if err := os.WriteFile(configFile, data, 0644); err != nil {
+		return err
+	}
+	publishEvent(eventConfigChanged, "", nil)
+	return nil
 }
 
 func startWebServer() {
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	ws := webSettings()
+	listener, err := listenWebServer(ws)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to start web server", "error", err)
+	}
+
+	var certFile, keyFile string
+	if ws.TLS {
+		certFile, keyFile, err = ensureWebCertificate(ws)
+		if err != nil {
+			fatal("Failed to prepare TLS certificate", "error", err)
+		}
 	}
-	webServerPort = listener.Addr().(*net.TCPAddr).Port
-	webServerURL = fmt.Sprintf("http://127.0.0.1:%d", webServerPort)
-	listener.Close()
 
-	log.Printf("Starting web server on %s", webServerURL)
+	slog.Info("Starting web server", "url", webServerURL)
+	if ws.AllowLAN {
+		slog.Info("Dashboard is reachable from the network", "address", listener.Addr().String())
+		if !ws.TLS {
+			slog.Warn("LAN mode without tls sends the API token unencrypted")
+		}
+	}
 
 	http.HandleFunc("/", handleHome)
 	http.HandleFunc("/api/accounts", handleAccounts)
@@ -292,21 +412,53 @@ func startWebServer() {
 	http.HandleFunc("/api/accounts/delete", handleDeleteAccount)
 	http.HandleFunc("/api/accounts/test", handleTestConnection)
 	http.HandleFunc("/api/accounts/folders", handleFetchFolders)
+	http.HandleFunc("/api/accounts/{id}/check", handleAccountCheck)
+	http.HandleFunc("/api/accounts/{id}/pause", handleAccountPause)
+	http.HandleFunc("/api/accounts/{id}/resume", handleAccountResume)
+	http.HandleFunc("/api/accounts/{id}/snooze", handleAccountSnooze)
+	http.HandleFunc("/api/accounts/{id}/folders", handleAccountFolders)
+	http.HandleFunc("/api/accounts/{id}/test", handleAccountTest)
+	http.HandleFunc("/api/accounts/{id}/filter-preview", handleFilterPreview)
+	http.HandleFunc("/api/accounts/{id}/sieve", handleAccountSieve)
 	http.HandleFunc("/api/status", handleStatus)
+	http.HandleFunc("/api/events", handleEvents)
+	http.HandleFunc("/api/logs", handleLogs)
+	http.HandleFunc("/api/logs/level", handleLogLevel)
+	if ws.Metrics {
+		http.HandleFunc("/metrics", handleMetrics)
+	}
 	http.HandleFunc("/api/check-all", handleCheckAll)
 	http.HandleFunc("/api/clear-history", handleClearHistory)
 	http.HandleFunc("/api/restart", handleRestart)
-
-	log.Fatal(http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", webServerPort), nil))
+	http.HandleFunc("/api/dnd", handleDoNotDisturb)
+	http.HandleFunc("/api/notifications", handleNotifications)
+	http.HandleFunc("/api/notifications/{id}/{action}", handleNotificationAction)
+	http.HandleFunc("/api/mutes", handleMutes)
+	http.HandleFunc("/api/mutes/{id}/delete", handleDeleteMute)
+	http.HandleFunc("/api/filters", handleFilters)
+	http.HandleFunc("/api/sieve/validate", handleSieveValidate)
+	http.HandleFunc("/api/alerts", handleAlerts)
+	http.HandleFunc("/api/alerts/ack", handleAcknowledgeAlerts)
+	http.HandleFunc("/api/templates/preview", handleTemplatePreview)
+	http.HandleFunc("/api/codes", handleCodes)
+	http.HandleFunc("/api/discover", handleDiscover)
+	http.HandleFunc("/api/codes/copy", handleCopyCode)
+
+	server := &http.Server{
+		Handler:           requireAuth(http.DefaultServeMux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if ws.TLS {
+			fatal("Web server stopped", "error", server.ServeTLS(listener, certFile, keyFile))
+		}
+		fatal("Web server stopped", "error", server.Serve(listener))
+	}()
 }
 
 func onReady() {
 	systray.SetIcon(getIconData())
 	systray.SetTitle("📧")
-	systray.SetTooltip(fmt.Sprintf("Email Monitor - Click to open"))
-
-	time.Sleep(500 * time.Millisecond)
-
 	systray.SetTooltip(fmt.Sprintf("Email Monitor (IMAP & POP3)\nClick to open dashboard\n%s", webServerURL))
 
 	mOpen := systray.AddMenuItem("🖥️ Open Dashboard", "Open web dashboard")
@@ -314,18 +466,27 @@ func onReady() {
 	go func() {
 		for {
 			<-mOpen.ClickedCh
-			log.Printf("Opening dashboard: %s", webServerURL)
-			openBrowser(webServerURL)
+			slog.Info("Opening dashboard", "url", webServerURL)
+			openBrowser(dashboardAuthURL())
 		}
 	}()
+	setupLANMenu()
+
+	setupDoNotDisturbMenu()
+	setupAlertsMenu()
+	setupCodesMenu()
 
 	for i := range config.Accounts {
 		go startMonitoring(&config.Accounts[i])
 	}
+
+	go runQuietHoursLoop()
+	go runDigestLoop()
+	go runAlertLoop()
 }
 
 func onExit() {
-	log.Println("Email monitor stopped")
+	slog.Info("Email monitor stopped")
 	for i := range config.Accounts {
 		if config.Accounts[i].stopChan != nil {
 			close(config.Accounts[i].stopChan)
@@ -347,7 +508,7 @@ func openBrowser(url string) {
 		err = exec.Command("open", url).Start()
 	}
 	if err != nil {
-		log.Printf("Failed to open browser: %v", err)
+		slog.Warn("Failed to open browser", "error", err)
 	}
 }
 
@@ -384,6 +545,8 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             margin-left: 8px;
         }
         .protocol-imap { background: #2196f3; color: white; }
+        .protocol-local { background: #607d8b; color: white; }
+        .protocol-jmap { background: #4caf50; color: white; }
         .protocol-pop3 { background: #ff9800; color: white; }
         .security-note {
             background: #e8f5e9;
@@ -439,6 +602,15 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             font-size: 14px;
             color: #666;
         }
+        .account-card .detail.last-error {
+            color: #dc3545;
+        }
+        .account-card .pause-state,
+        .account-card .check-state {
+            font-size: 12px;
+            font-weight: normal;
+            color: #999;
+        }
         .account-card .detail strong {
             color: #333;
             display: inline-block;
@@ -481,7 +653,7 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             color: #333;
             font-weight: 500;
         }
-        .form-group input, .form-group select {
+        .form-group input, .form-group select, .form-group textarea {
             width: 100%;
             padding: 8px;
             border: 1px solid #ddd;
@@ -546,6 +718,71 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             margin-right: 8px;
             width: auto;
         }
+        .folder-noselect { color: #999; cursor: default; }
+        .folder-special {
+            background: #e9ecef;
+            border-radius: 3px;
+            font-size: 11px;
+            padding: 1px 5px;
+            margin-left: 6px;
+        }
+        .folder-counts { color: #666; font-size: 12px; margin-left: 6px; }
+        .template-preview {
+            background: #333;
+            color: white;
+            padding: 10px 15px;
+            border-radius: 5px;
+            font-size: 13px;
+            white-space: pre-wrap;
+        }
+        .template-preview strong { display: block; margin-bottom: 4px; }
+        .template-preview.error { background: #dc3545; }
+        .notification-table {
+            width: 100%;
+            border-collapse: collapse;
+            margin-top: 15px;
+            font-size: 13px;
+        }
+        .notification-table th, .notification-table td {
+            text-align: left;
+            padding: 6px 8px;
+            border-bottom: 1px solid #eee;
+        }
+        .notification-table th { color: #333; }
+        .alerts-banner {
+            display: none;
+            background: #fdecea;
+            border-left: 4px solid #dc3545;
+            padding: 10px 15px;
+            margin-top: 10px;
+            border-radius: 4px;
+            font-size: 14px;
+            color: #a71d2a;
+        }
+        .alerts-banner .alert-item {
+            display: flex;
+            justify-content: space-between;
+            align-items: center;
+            margin: 5px 0;
+        }
+        .notification-table td { color: #666; }
+        .notification-table tr.message-read td { opacity: 0.6; }
+        .notification-table tr.message-archived td { text-decoration: line-through; opacity: 0.6; }
+        .message-actions { white-space: nowrap; }
+        .message-actions .btn { margin: 1px; text-decoration: none; display: inline-block; }
+        .notification-table td.log-debug { color: #999; }
+        .notification-table td.log-warn { color: #b8860b; }
+        .notification-table td.log-error { color: #dc3545; }
+        .sieve-script { font-family: monospace; font-size: 13px; }
+        .auth-warning { color: #dc3545; font-weight: bold; font-size: 12px; }
+        .auth-verified { color: #28a745; }
+        .notification-table td.code {
+            font-family: monospace;
+            font-size: 18px;
+            font-weight: bold;
+            color: #333;
+            letter-spacing: 2px;
+        }
     </style>
 </head>
 <body>
@@ -555,17 +792,89 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             <div class="security-note">
                 🔒 <strong>Secure Storage:</strong> Passwords are stored in your system's keyring (not in config files)
             </div>
-            <p style="margin-top: 10px;"><strong>Supported Protocols:</strong> <span class="protocol-badge protocol-imap">IMAP</span> <span class="protocol-badge protocol-pop3">POP3</span></p>
+            <p style="margin-top: 10px;"><strong>Supported Protocols:</strong> <span class="protocol-badge protocol-imap">IMAP</span> <span class="protocol-badge protocol-pop3">POP3</span> <span class="protocol-badge protocol-jmap">JMAP</span> <span class="protocol-badge protocol-local">MAILDIR</span> <span class="protocol-badge protocol-local">MBOX</span></p>
             <p style="margin-top: 5px;">Application Directory: {{.AppDir}}</p>
             <div class="actions">
                 <button class="btn btn-primary" onclick="showAddModal()">Add Account</button>
                 <button class="btn btn-success" onclick="checkAll()">Check All Now</button>
                 <button class="btn btn-warning" onclick="clearHistory()">Clear History</button>
                 <button class="btn btn-danger" onclick="restartMonitor()">Restart</button>
+                <button class="btn btn-primary" id="dndButton" onclick="toggleDoNotDisturb()">🔕 Do Not Disturb</button>
             </div>
+            <p id="dndStatus" style="margin-top: 10px; display: none;"></p>
+            <div id="alertsBanner" class="alerts-banner"></div>
         </div>
 
         <div id="accounts" class="accounts-grid"></div>
+
+        <div class="header" id="codes" style="display: none;">
+            <h2>🔑 Recent Codes</h2>
+            <small style="color:#666;">One-time codes are kept for 10 minutes</small>
+            <table class="notification-table">
+                <thead><tr><th>Time</th><th>Account</th><th>From</th><th>Code</th><th></th></tr></thead>
+                <tbody id="codeList"></tbody>
+            </table>
+        </div>
+
+        <div class="header" id="notifications">
+            <h2>📥 Inbox</h2>
+            <small style="color:#666;">Recent matched messages from all accounts</small>
+            <div class="actions">
+                <select id="notificationAccount" onchange="loadNotifications()"><option value="">All accounts</option></select>
+                <input type="text" id="notificationFolder" placeholder="Folder" onchange="loadNotifications()">
+                <select id="notificationRule" onchange="loadNotifications()"><option value="">All rules</option></select>
+            </div>
+            <table class="notification-table">
+                <thead><tr><th>Time</th><th>Account</th><th>Folder</th><th>From</th><th>Subject</th><th>Rule</th><th>Priority</th><th>Status</th><th></th></tr></thead>
+                <tbody id="notificationList"></tbody>
+            </table>
+        </div>
+
+        <div class="header" id="mutes">
+            <h2>🔇 Muted</h2>
+            <small style="color:#666;">Matching messages are recorded in the inbox as "muted" without a notification</small>
+            <div class="actions">
+                <select id="muteType">
+                    <option value="sender">Sender</option>
+                    <option value="domain">Domain</option>
+                    <option value="list">Mailing list (List-Id)</option>
+                    <option value="thread">Thread (Message-ID)</option>
+                </select>
+                <input type="text" id="muteValue" placeholder="news@example.com">
+                <select id="muteAccount"><option value="">All accounts</option></select>
+                <input type="text" id="muteFor" placeholder="For (e.g. 1w), empty = forever" style="width:200px;">
+                <button class="btn btn-primary btn-sm" onclick="addMute()">Add</button>
+            </div>
+            <table class="notification-table">
+                <thead><tr><th>Type</th><th>Value</th><th>Account</th><th>Note</th><th>Until</th><th></th></tr></thead>
+                <tbody id="muteList"></tbody>
+            </table>
+        </div>
+
+        <div class="header" id="logs">
+            <h2>Logs</h2>
+            <div class="actions">
+                <select id="logFilterLevel" onchange="loadLogs()">
+                    <option value="">All levels</option>
+                    <option value="info">Info and above</option>
+                    <option value="warn">Warnings and errors</option>
+                    <option value="error">Errors only</option>
+                </select>
+                <select id="logAccount" onchange="loadLogs()"><option value="">All accounts</option></select>
+                <label>Log level
+                    <select id="logLevel" onchange="setLogLevel()">
+                        <option value="debug">Debug</option>
+                        <option value="info">Info</option>
+                        <option value="warn">Warn</option>
+                        <option value="error">Error</option>
+                    </select>
+                </label>
+            </div>
+            <table class="notification-table">
+                <thead><tr><th>Time</th><th>Level</th><th>Account</th><th>Message</th></tr></thead>
+                <tbody id="logList"></tbody>
+            </table>
+        </div>
     </div>
 
     <div id="addModal" class="modal">
@@ -577,6 +886,9 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                     <select id="protocol" onchange="updateProtocolSettings()">
                         <option value="imap">IMAP (recommended)</option>
                         <option value="pop3">POP3</option>
+                        <option value="jmap">JMAP</option>
+                        <option value="maildir">Maildir (local)</option>
+                        <option value="mbox">mbox (local)</option>
                     </select>
                 </div>
                 <div class="form-group">
@@ -591,7 +903,26 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                 <div class="form-group">
                     <label>Email</label>
                     <input type="email" id="email" required>
+                    <button type="button" class="btn btn-primary btn-sm" style="margin-top:5px;" onclick="discoverSettings()">🔍 Auto-detect Settings</button>
+                    <div id="discoverResults" style="margin-top:5px;"></div>
+                </div>
+                <div class="form-group">
+                    <label>Label (optional)</label>
+                    <input type="text" id="label" placeholder="Work" oninput="previewTemplate('')">
+                </div>
+                <div class="form-group">
+                    <label>Webmail Link (optional)</label>
+                    <input type="text" id="webmailUrl" placeholder="https://mail.google.com/mail/u/0/#search/rfc822msgid:{message_id}">
+                    <small style="color:#666;">Used by "Open" in the inbox. {message_id}, {folder} and {account} are filled in</small>
                 </div>
+                <div id="localSettings" style="display: none;">
+                    <div class="form-group">
+                        <label id="pathLabel">Maildir Path</label>
+                        <input type="text" id="path" placeholder="~/Maildir">
+                        <small style="color:#666;">Watched for changes; no server or password needed</small>
+                    </div>
+                </div>
+                <div id="remoteSettings">
                 <div class="form-group">
                     <label id="serverLabel">Server</label>
                     <input type="text" id="server" required>
@@ -609,13 +940,28 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                     <input type="password" id="password" required>
                     <small style="color:#666;">Password will be stored securely in system keyring</small>
                 </div>
+                <div id="jmapSettings" style="display: none;">
+                    <div class="form-group">
+                        <label>Authentication</label>
+                        <select id="authType">
+                            <option value="basic">Username and password</option>
+                            <option value="bearer">API token (Bearer)</option>
+                        </select>
+                        <small style="color:#666;">For a token, put it in the password field; it is stored in the keyring</small>
+                    </div>
+                    <div class="form-group">
+                        <label class="folder-checkbox-label"><input type="checkbox" id="jmapPush"> Use push (EventSource)</label>
+                        <small style="color:#666;">Check as soon as the server reports new mail</small>
+                    </div>
+                </div>
+                </div>
                 <div class="form-group">
                     <label>Check Interval (seconds)</label>
                     <input type="number" id="interval" value="120" required>
                 </div>
                 <div id="folderSettings">
                     <div class="protocol-note">
-                        ℹ️ <strong>Note:</strong> Folder selection is only available for IMAP. POP3 only accesses the inbox.
+                        ℹ️ <strong>Note:</strong> Folder selection is only available for IMAP, JMAP and Maildir (Maildir++ subfolders). POP3 and mbox only access the inbox.
                     </div>
                     <div class="form-group">
                         <label>Folder Mode</label>
@@ -645,6 +991,12 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                     <small style="color:#666;display:block;margin-bottom:10px;">Configure keyword and email filters to control which notifications you receive</small>
                 </div>
 
+                <div class="form-group">
+                    <label>Filter Sets (comma-separated)</label>
+                    <input type="text" id="filterSets" placeholder="marketing, on-call">
+                    <small style="color:#666;">Shared filters from filter_sets in the config file, added to the global filters and the filters below. <span class="filter-set-names"></span></small>
+                </div>
+
                 <div class="form-group">
                     <label>Include Keywords (comma-separated)</label>
                     <input type="text" id="includeKeywords" placeholder="urgent, invoice, payment">
@@ -658,15 +1010,57 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                 </div>
 
                 <div class="form-group">
-                    <label>Include Email Addresses (comma-separated)</label>
-                    <input type="text" id="includeEmails" placeholder="boss@company.com, client@example.com">
-                    <small style="color:#666;">Only notify for emails from these addresses (leave empty for all)</small>
+                    <label>Include Senders (comma-separated)</label>
+                    <input type="text" id="includeEmails" placeholder="boss@company.com, @client.com, GitHub">
+                    <small style="color:#666;">Only notify for emails from these senders (leave empty for all). Addresses, @domain, *.domain, globs like noreply-*@*, /regex/ or display name text. Checked against From, Sender and Reply-To</small>
+                </div>
+
+                <div class="form-group">
+                    <label>Exclude Senders (comma-separated)</label>
+                    <input type="text" id="excludeEmails" placeholder="spam@example.com, *.marketing.com, /^noreply@/">
+                    <small style="color:#666;">Never notify for emails from these senders. Addresses, @domain, *.domain, globs like noreply-*@*, /regex/ or display name text. Checked against From, Sender and Reply-To</small>
+                </div>
+
+                <div class="form-group">
+                    <label class="folder-checkbox-label"><input type="checkbox" id="normalizePlusTags"> Ignore plus tags in sender addresses</label>
+                    <small style="color:#666;">Treat user+news@example.com like user@example.com when matching senders</small>
+                </div>
+
+                <div class="form-group">
+                    <label>Sieve Script</label>
+                    <textarea id="sieveScript" rows="5" class="sieve-script" placeholder="if address :domain &quot;from&quot; &quot;example.com&quot; { keep; }&#10;else { discard; }" oninput="validateSieve('')"></textarea>
+                    <small style="color:#666;">Optional. When set, the script alone decides which messages notify: keep and notify notify, discard, fileinto, redirect and reject do not. Replaces the sender and keyword filters above; mutes still apply</small>
+                    <div id="sieveStatus"></div>
+                </div>
+
+                <div class="form-group">
+                    <label class="folder-checkbox-label"><input type="checkbox" id="showSnippet"> Show message snippet in notifications</label>
+                    <small style="color:#666;">Includes the first ~200 characters of the message text (never marks it as read)</small>
+                </div>
+
+                <div class="form-group">
+                    <label class="folder-checkbox-label"><input type="checkbox" id="detectCodes"> Detect one-time codes</label>
+                    <small style="color:#666;">Shows verification codes prominently with a "Copy code" action</small>
+                </div>
+
+                <div class="form-group">
+                    <label>🔔 Notification Template (Optional)</label>
+                    <small style="color:#666;display:block;margin-bottom:10px;">Go templates with {{"{{.Label}}"}}, {{"{{.Account}}"}}, {{"{{.Folder}}"}}, {{"{{.From}}"}}, {{"{{.FromName}}"}}, {{"{{.FromAddress}}"}}, {{"{{.To}}"}}, {{"{{.Cc}}"}}, {{"{{.Subject}}"}}, {{"{{.Date}}"}}, {{"{{.Snippet}}"}} and helpers such as {{"{{truncate 50 .Subject}}"}}</small>
+                </div>
+
+                <div class="form-group">
+                    <label>Title Template</label>
+                    <input type="text" id="titleTemplate" placeholder="📧 {{"{{.Account}}"}} [{{"{{.Folder}}"}}]" oninput="previewTemplate('')">
+                </div>
+
+                <div class="form-group">
+                    <label>Body Template</label>
+                    <textarea id="bodyTemplate" rows="3" placeholder="From: {{"{{.From}}"}}&#10;Subject: {{"{{truncate 50 .Subject}}"}}" oninput="previewTemplate('')"></textarea>
                 </div>
 
                 <div class="form-group">
-                    <label>Exclude Email Addresses (comma-separated)</label>
-                    <input type="text" id="excludeEmails" placeholder="spam@example.com, noreply@marketing.com">
-                    <small style="color:#666;">Never notify for emails from these addresses</small>
+                    <label>Preview</label>
+                    <div id="templatePreview" class="template-preview"></div>
                 </div>
 
                 <div style="display: flex; gap: 10px; margin-top: 20px;">
@@ -685,17 +1079,36 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                 <input type="hidden" id="editIndex">
                 <div class="form-group">
                     <label>Protocol</label>
-                    <select id="editProtocol" readonly disabled style="background:#f5f5f5;">
+                    <select id="editProtocol" onchange="updateEditProtocolSettings(true)">
                         <option value="imap">IMAP</option>
                         <option value="pop3">POP3</option>
+                        <option value="jmap">JMAP</option>
+                        <option value="maildir">Maildir</option>
+                        <option value="mbox">mbox</option>
                     </select>
-                    <small style="color:#666;">Protocol cannot be changed</small>
+                    <small style="color:#666;">Switching from a local source to a server needs the password below</small>
                 </div>
                 <div class="form-group">
                     <label>Email</label>
-                    <input type="email" id="editEmail" required readonly style="background:#f5f5f5;">
-                    <small style="color:#666;">Email cannot be changed (used as keyring identifier)</small>
+                    <input type="email" id="editEmail" required>
+                    <small style="color:#666;">Changing the email moves the stored password and notification history</small>
+                </div>
+                <div class="form-group">
+                    <label>Label (optional)</label>
+                    <input type="text" id="editLabel" placeholder="Work" oninput="previewTemplate('edit')">
+                </div>
+                <div class="form-group">
+                    <label>Webmail Link (optional)</label>
+                    <input type="text" id="editWebmailUrl" placeholder="https://mail.google.com/mail/u/0/#search/rfc822msgid:{message_id}">
+                    <small style="color:#666;">Used by "Open" in the inbox. {message_id}, {folder} and {account} are filled in</small>
+                </div>
+                <div id="editLocalSettings" style="display: none;">
+                    <div class="form-group">
+                        <label>Path</label>
+                        <input type="text" id="editPath">
+                    </div>
                 </div>
+                <div id="editRemoteSettings">
                 <div class="form-group">
                     <label id="editServerLabel">Server</label>
                     <input type="text" id="editServer" required>
@@ -713,6 +1126,21 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                     <input type="password" id="editPassword" placeholder="Leave empty to keep existing">
                     <small style="color:#666;">Leave empty to keep current password</small>
                 </div>
+                <div id="editJmapSettings" style="display: none;">
+                    <div class="form-group">
+                        <label>Authentication</label>
+                        <select id="editAuthType">
+                            <option value="basic">Username and password</option>
+                            <option value="bearer">API token (Bearer)</option>
+                        </select>
+                        <small style="color:#666;">For a token, put it in the password field; it is stored in the keyring</small>
+                    </div>
+                    <div class="form-group">
+                        <label class="folder-checkbox-label"><input type="checkbox" id="editJmapPush"> Use push (EventSource)</label>
+                        <small style="color:#666;">Check as soon as the server reports new mail</small>
+                    </div>
+                </div>
+                </div>
                 <div class="form-group">
                     <label>Check Interval (seconds)</label>
                     <input type="number" id="editInterval" required>
@@ -729,7 +1157,7 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                     <div id="editFolderSelection">
                         <div class="form-group">
                             <button type="button" class="btn btn-primary btn-sm" onclick="fetchEditFolders()">📁 Fetch Folders from Server</button>
-                            <small style="color:#666;display:block;margin-top:5px;">Click to retrieve available folders</small>
+                            <small style="color:#666;display:block;margin-top:5px;">Uses the saved credentials, so the password is not needed</small>
                         </div>
                         <div class="form-group">
                             <label id="editFolderListLabel">Select Folders</label>
@@ -745,32 +1173,88 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                     <small style="color:#666;display:block;margin-bottom:10px;">Configure keyword and email filters</small>
                 </div>
 
+                <div class="form-group">
+                    <label>Filter Sets (comma-separated)</label>
+                    <input type="text" id="editFilterSets" placeholder="marketing, on-call" oninput="scheduleFilterPreview()">
+                    <small style="color:#666;">Shared filters from filter_sets in the config file, added to the global filters and the filters below. <span class="filter-set-names"></span></small>
+                </div>
+
                 <div class="form-group">
                     <label>Include Keywords (comma-separated)</label>
-                    <input type="text" id="editIncludeKeywords" placeholder="urgent, invoice, payment">
+                    <input type="text" id="editIncludeKeywords" placeholder="urgent, invoice, payment" oninput="scheduleFilterPreview()">
                     <small style="color:#666;">Only notify for emails with these keywords in subject</small>
                 </div>
 
                 <div class="form-group">
                     <label>Exclude Keywords (comma-separated)</label>
-                    <input type="text" id="editExcludeKeywords" placeholder="newsletter, marketing">
+                    <input type="text" id="editExcludeKeywords" placeholder="newsletter, marketing" oninput="scheduleFilterPreview()">
                     <small style="color:#666;">Never notify for emails with these keywords</small>
                 </div>
 
                 <div class="form-group">
-                    <label>Include Email Addresses (comma-separated)</label>
-                    <input type="text" id="editIncludeEmails" placeholder="boss@company.com">
-                    <small style="color:#666;">Only notify for emails from these addresses</small>
+                    <label>Include Senders (comma-separated)</label>
+                    <input type="text" id="editIncludeEmails" placeholder="boss@company.com" oninput="scheduleFilterPreview()">
+                    <small style="color:#666;">Only notify for emails from these senders. Addresses, @domain, *.domain, globs like noreply-*@*, /regex/ or display name text. Checked against From, Sender and Reply-To</small>
+                </div>
+
+                <div class="form-group">
+                    <label>Exclude Senders (comma-separated)</label>
+                    <input type="text" id="editExcludeEmails" placeholder="spam@example.com" oninput="scheduleFilterPreview()">
+                    <small style="color:#666;">Never notify for emails from these senders. Addresses, @domain, *.domain, globs like noreply-*@*, /regex/ or display name text. Checked against From, Sender and Reply-To</small>
+                </div>
+
+                <div class="form-group">
+                    <label class="folder-checkbox-label"><input type="checkbox" id="editNormalizePlusTags" onchange="scheduleFilterPreview()"> Ignore plus tags in sender addresses</label>
+                    <small style="color:#666;">Treat user+news@example.com like user@example.com when matching senders</small>
+                </div>
+
+                <div class="form-group">
+                    <label>Sieve Script</label>
+                    <textarea id="editSieveScript" rows="5" class="sieve-script" placeholder="if address :domain &quot;from&quot; &quot;example.com&quot; { keep; }&#10;else { discard; }" oninput="validateSieve('edit'); scheduleFilterPreview()"></textarea>
+                    <small style="color:#666;">Optional. When set, the script alone decides which messages notify: keep and notify notify, discard, fileinto, redirect and reject do not. Replaces the sender and keyword filters above; mutes still apply</small>
+                    <div id="editSieveStatus"></div>
+                    <button type="button" class="btn btn-primary btn-sm" onclick="fetchSieveScript()">⬇️ Fetch from Server (ManageSieve)</button>
+                </div>
+
+                <div class="form-group">
+                    <button type="button" class="btn btn-primary btn-sm" onclick="runFilterPreview()">🔍 Preview on Recent Mail</button>
+                    <small style="color:#666;display:block;">Shows which of the last 20 messages these filters would notify about, without saving. Updates as you edit</small>
+                    <div id="editFilterPreview"></div>
+                </div>
+
+                <div class="form-group">
+                    <label class="folder-checkbox-label"><input type="checkbox" id="editShowSnippet"> Show message snippet in notifications</label>
+                    <small style="color:#666;">Includes the first ~200 characters of the message text (never marks it as read)</small>
+                </div>
+
+                <div class="form-group">
+                    <label class="folder-checkbox-label"><input type="checkbox" id="editDetectCodes"> Detect one-time codes</label>
+                    <small style="color:#666;">Shows verification codes prominently with a "Copy code" action</small>
+                </div>
+
+                <div class="form-group">
+                    <label>🔔 Notification Template (Optional)</label>
+                    <small style="color:#666;display:block;margin-bottom:10px;">Go templates with {{"{{.Label}}"}}, {{"{{.Account}}"}}, {{"{{.Folder}}"}}, {{"{{.From}}"}}, {{"{{.FromName}}"}}, {{"{{.FromAddress}}"}}, {{"{{.To}}"}}, {{"{{.Cc}}"}}, {{"{{.Subject}}"}}, {{"{{.Date}}"}}, {{"{{.Snippet}}"}} and helpers such as {{"{{truncate 50 .Subject}}"}}</small>
+                </div>
+
+                <div class="form-group">
+                    <label>Title Template</label>
+                    <input type="text" id="editTitleTemplate" placeholder="📧 {{"{{.Account}}"}} [{{"{{.Folder}}"}}]" oninput="previewTemplate('edit')">
+                </div>
+
+                <div class="form-group">
+                    <label>Body Template</label>
+                    <textarea id="editBodyTemplate" rows="3" placeholder="From: {{"{{.From}}"}}&#10;Subject: {{"{{truncate 50 .Subject}}"}}" oninput="previewTemplate('edit')"></textarea>
                 </div>
 
                 <div class="form-group">
-                    <label>Exclude Email Addresses (comma-separated)</label>
-                    <input type="text" id="editExcludeEmails" placeholder="spam@example.com">
-                    <small style="color:#666;">Never notify for emails from these addresses</small>
+                    <label>Preview</label>
+                    <div id="editTemplatePreview" class="template-preview"></div>
                 </div>
 
                 <div style="display: flex; gap: 10px; margin-top: 20px;">
                     <button type="submit" class="btn btn-success">Save</button>
+                    <button type="button" class="btn btn-primary" onclick="testEditConnection()">Test Saved Connection</button>
                     <button type="button" class="btn btn-danger" onclick="closeEditModal()">Cancel</button>
                 </div>
             </form>
@@ -780,6 +1264,22 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
     <div id="toast" class="toast"></div>
 
     <script>
+        const csrfToken = '{{.CSRFToken}}';
+        const nativeFetch = window.fetch.bind(window);
+        window.fetch = function(url, options = {}) {
+            const method = (options.method || 'GET').toUpperCase();
+            if (method !== 'GET' && method !== 'HEAD') {
+                options.headers = Object.assign({'X-CSRF-Token': csrfToken}, options.headers || {});
+            }
+            options.credentials = 'same-origin';
+            return nativeFetch(url, options).then(response => {
+                if (response.status === 401) {
+                    showToast('Session expired, reopen the dashboard from the tray menu', 'error');
+                }
+                return response;
+            });
+        };
+
         let availableFolders = [];
         let selectedFolders = [];
         let editAvailableFolders = [];
@@ -790,10 +1290,26 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             const folderSettings = document.getElementById('folderSettings');
             const serverLabel = document.getElementById('serverLabel');
 
-            if (protocol === 'pop3') {
+            const local = protocol === 'maildir' || protocol === 'mbox';
+            document.getElementById('jmapSettings').style.display = protocol === 'jmap' ? 'block' : 'none';
+            document.getElementById('localSettings').style.display = local ? 'block' : 'none';
+            document.getElementById('remoteSettings').style.display = local ? 'none' : 'block';
+            ['server', 'port', 'username', 'password'].forEach(id => {
+                document.getElementById(id).required = !local;
+            });
+
+            if (local) {
+                folderSettings.style.display = protocol === 'maildir' ? 'block' : 'none';
+                document.getElementById('pathLabel').textContent = protocol === 'maildir' ? 'Maildir Path' : 'mbox File';
+                document.getElementById('path').placeholder = protocol === 'maildir' ? '~/Maildir' : '/var/mail/' + (document.getElementById('email').value.split('@')[0] || 'user');
+            } else if (protocol === 'pop3') {
                 folderSettings.style.display = 'none';
                 serverLabel.textContent = 'POP3 Server';
                 document.getElementById('port').value = '995';
+            } else if (protocol === 'jmap') {
+                folderSettings.style.display = 'block';
+                serverLabel.textContent = 'JMAP Server (host or session URL)';
+                document.getElementById('port').value = '443';
             } else {
                 folderSettings.style.display = 'block';
                 serverLabel.textContent = 'IMAP Server';
@@ -826,6 +1342,7 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                 folderSelection.style.display = 'block';
                 folderListLabel.textContent = folderMode === 'include' ? 'Include These Folders' : 'Exclude These Folders';
             }
+            scheduleFilterPreview();
         }
 
         async function fetchFolders() {
@@ -834,8 +1351,14 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             const username = document.getElementById('username').value;
             const password = document.getElementById('password').value;
             const protocol = document.getElementById('protocol').value;
+            const path = document.getElementById('path').value;
 
-            if (!server || !username || !password) {
+            if (protocol === 'maildir') {
+                if (!path) {
+                    showToast('Please fill in the Maildir path first', 'error');
+                    return;
+                }
+            } else if (!server || !username || !password) {
                 showToast('Please fill in server, username, and password first', 'error');
                 return;
             }
@@ -844,7 +1367,7 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                 const response = await fetch('/api/accounts/folders', {
                     method: 'POST',
                     headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ server, port, username, password, protocol })
+                    body: JSON.stringify({ server, port, username, password, protocol, path, auth_type: document.getElementById('authType').value })
                 });
                 const result = await response.json();
 
@@ -865,20 +1388,8 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             const accounts = await (await fetch('/api/accounts')).json();
             const acc = accounts[index];
 
-            const password = document.getElementById('editPassword').value || '';
-
             try {
-                const response = await fetch('/api/accounts/folders', {
-                    method: 'POST',
-                    headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({
-                        server: acc.server,
-                        port: acc.port,
-                        username: acc.username,
-                        password: password || 'dummy',
-                        protocol: acc.protocol
-                    })
-                });
+                const response = await fetch('/api/accounts/' + encodeURIComponent(acc.id) + '/folders', { method: 'POST' });
                 const result = await response.json();
 
                 if (result.success) {
@@ -893,6 +1404,20 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             }
         }
 
+        async function testEditConnection() {
+            const index = parseInt(document.getElementById('editIndex').value);
+            const accounts = await (await fetch('/api/accounts')).json();
+            const acc = accounts[index];
+
+            try {
+                const response = await fetch('/api/accounts/' + encodeURIComponent(acc.id) + '/test', { method: 'POST' });
+                const result = await response.json();
+                showToast(result.message, result.success ? 'success' : 'error');
+            } catch (error) {
+                showToast('Test failed: ' + error, 'error');
+            }
+        }
+
         function renderFolderList() {
             const container = document.getElementById('folderList');
             if (availableFolders.length === 0) {
@@ -924,15 +1449,20 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             }
 
             container.innerHTML = editAvailableFolders.map(folder => {
-                const isSelected = editSelectedFolders.includes(folder);
-                const escapedFolder = folder.replace(/'/g, "\\'");
+                const parts = folder.delimiter ? folder.name.split(folder.delimiter) : [folder.name];
+                const indent = (parts.length - 1) * 18;
+                const name = escapeHTML(folder.name);
+                const special = folder.special_use ? ` + "`" + ` <span class="folder-special">${escapeHTML(folder.special_use.slice(1))}</span>` + "`" + ` : '';
+                const counts = folder.noselect ? '' : ` + "`" + ` <span class="folder-counts">${folder.unseen} unread / ${folder.messages}</span>` + "`" + `;
+                const isSelected = editSelectedFolders.includes(folder.name);
                 return ` + "`" + `
-                    <div class="folder-checkbox-item">
-                        <label class="folder-checkbox-label">
-                            <input type="checkbox" value="${folder}"
+                    <div class="folder-checkbox-item" style="margin-left:${indent}px">
+                        <label class="folder-checkbox-label${folder.noselect ? ' folder-noselect' : ''}" title="${name}">
+                            <input type="checkbox" value="${name}"
                                 ${isSelected ? 'checked' : ''}
-                                onchange="toggleEditFolder('${escapedFolder}')">
-                            <span>${folder}</span>
+                                ${folder.noselect ? 'disabled' : ''}
+                                onchange="toggleEditFolder(this.value)">
+                            <span>${escapeHTML(parts[parts.length - 1])}${special}${counts}</span>
                         </label>
                     </div>
                 ` + "`" + `;
@@ -955,6 +1485,46 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             } else {
                 editSelectedFolders.push(folder);
             }
+            scheduleFilterPreview();
+        }
+
+        let previewTimer = null;
+
+        function previewTemplate(prefix) {
+            const field = name => document.getElementById(prefix ? prefix + name[0].toUpperCase() + name.slice(1) : name);
+            clearTimeout(previewTimer);
+            previewTimer = setTimeout(async () => {
+                const data = {
+                    email: field('email').value || 'user@example.com',
+                    label: field('label').value,
+                    protocol: field('protocol').value,
+                    title_template: field('titleTemplate').value,
+                    body_template: field('bodyTemplate').value
+                };
+                const preview = field('templatePreview');
+                try {
+                    const response = await fetch('/api/templates/preview', {
+                        method: 'POST',
+                        headers: { 'Content-Type': 'application/json' },
+                        body: JSON.stringify(data)
+                    });
+                    const result = await response.json();
+                    preview.textContent = '';
+                    if (result.success) {
+                        preview.className = 'template-preview';
+                        const title = document.createElement('strong');
+                        title.textContent = result.title;
+                        preview.appendChild(title);
+                        preview.appendChild(document.createTextNode(result.body));
+                    } else {
+                        preview.className = 'template-preview error';
+                        preview.textContent = result.message;
+                    }
+                } catch (error) {
+                    preview.className = 'template-preview error';
+                    preview.textContent = 'Preview failed: ' + error;
+                }
+            }, 300);
         }
 
         function showToast(message, type = 'success') {
@@ -987,29 +1557,242 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             }
         }
 
+        let discoveredCandidates = [];
+
+        async function discoverSettings() {
+            const email = document.getElementById('email').value.trim();
+            const results = document.getElementById('discoverResults');
+            if (!email) {
+                showToast('Please enter an email address first', 'error');
+                return;
+            }
+
+            results.innerHTML = '<small style="color:#666;">Looking up settings...</small>';
+            try {
+                const response = await fetch('/api/discover?email=' + encodeURIComponent(email));
+                const result = await response.json();
+                discoveredCandidates = result.candidates || [];
+                if (!result.success) {
+                    results.innerHTML = '';
+                    showToast(result.message, 'error');
+                    return;
+                }
+                results.innerHTML = discoveredCandidates.map((c, i) => ` + "`" + `
+                    <label class="folder-checkbox-label">
+                        <input type="radio" name="discovered" ${i === 0 ? 'checked' : ''} onchange="applyDiscovered(${i})">
+                        ${escapeHTML(c.protocol.toUpperCase())} ${escapeHTML(c.server)}:${c.port} <small style="color:#999;">(${escapeHTML(c.source)})</small>
+                    </label>
+                ` + "`" + `).join('');
+                applyDiscovered(0);
+                showToast(result.message, 'success');
+            } catch (error) {
+                results.innerHTML = '';
+                showToast('Discovery failed: ' + error, 'error');
+            }
+        }
+
+        function applyDiscovered(index) {
+            const c = discoveredCandidates[index];
+            if (!c) return;
+            document.getElementById('provider').value = 'custom';
+            document.getElementById('protocol').value = c.protocol;
+            updateProtocolSettings();
+            document.getElementById('server').value = c.server;
+            document.getElementById('port').value = c.port;
+            document.getElementById('username').value = c.username;
+            if (document.getElementById('password').value) {
+                testConnection();
+            }
+        }
+
+        document.getElementById('password').addEventListener('change', () => {
+            if (discoveredCandidates.length > 0) {
+                testConnection();
+            }
+        });
+
         function showAddModal() {
             document.getElementById('addModal').style.display = 'block';
             document.getElementById('addForm').reset();
+            document.getElementById('discoverResults').innerHTML = '';
+            document.getElementById('sieveStatus').innerHTML = '';
+            discoveredCandidates = [];
             selectedFolders = [];
             availableFolders = [];
             updateProtocolSettings();
+            previewTemplate('');
+            loadFilterSetNames();
+        }
+
+        async function loadFilterSetNames() {
+            try {
+                const response = await fetch('/api/filters');
+                const filters = await response.json();
+                const text = filters.names.length > 0 ? 'Available: ' + filters.names.join(', ') : 'No filter sets are defined.';
+                document.querySelectorAll('.filter-set-names').forEach(el => el.textContent = text);
+            } catch (error) {
+                console.error('Failed to load filter sets:', error);
+            }
+        }
+
+        function filterDetails(label, values) {
+            return values && values.length > 0 ?
+                ` + "`" + `<div class="detail"><strong>${label}:</strong> ${escapeHTML(values.join(', '))}</div>` + "`" + ` : '';
         }
 
         function closeModal() {
             document.getElementById('addModal').style.display = 'none';
         }
 
+        function updateEditProtocolSettings(changed) {
+            const protocol = document.getElementById('editProtocol').value;
+            const original = document.getElementById('editProtocol').dataset.original;
+            const local = protocol === 'maildir' || protocol === 'mbox';
+            const wasLocal = original === 'maildir' || original === 'mbox';
+
+            document.getElementById('editJmapSettings').style.display = protocol === 'jmap' ? 'block' : 'none';
+            document.getElementById('editLocalSettings').style.display = local ? 'block' : 'none';
+            document.getElementById('editRemoteSettings').style.display = local ? 'none' : 'block';
+            ['editServer', 'editPort', 'editUsername'].forEach(id => {
+                document.getElementById(id).required = !local;
+            });
+            document.getElementById('editPassword').required = !local && wasLocal;
+            document.getElementById('editFolderSettings').style.display = protocol === 'pop3' || protocol === 'mbox' ? 'none' : 'block';
+
+            if (changed && !local) {
+                const ports = { imap: '993', pop3: '995', jmap: '443' };
+                document.getElementById('editPort').value = ports[protocol];
+                document.getElementById('editServerLabel').textContent = protocol === 'jmap' ? 'JMAP Server (host or session URL)' : protocol.toUpperCase() + ' Server';
+            }
+        }
+
         function closeEditModal() {
             document.getElementById('editModal').style.display = 'none';
+            filterPreviewActive = false;
         }
 
-        async function testConnection() {
-            const data = {
-                protocol: document.getElementById('protocol').value,
-                server: document.getElementById('server').value,
-                port: parseInt(document.getElementById('port').value),
-                username: document.getElementById('username').value,
-                password: document.getElementById('password').value
+        let editAccountId = '';
+        let filterPreviewActive = false;
+        let filterPreviewTimer = null;
+
+        function splitList(id) {
+            return document.getElementById(id).value.split(',').map(s => s.trim()).filter(s => s);
+        }
+
+        function editFilterSettings() {
+            return {
+                filter_sets: splitList('editFilterSets'),
+                include_keyword: splitList('editIncludeKeywords'),
+                exclude_keyword: splitList('editExcludeKeywords'),
+                include_email: splitList('editIncludeEmails'),
+                exclude_email: splitList('editExcludeEmails'),
+                normalize_plus_tags: document.getElementById('editNormalizePlusTags').checked,
+                sieve_script: document.getElementById('editSieveScript').value
+            };
+        }
+
+        let sieveTimers = {};
+
+        function validateSieve(prefix) {
+            const textarea = document.getElementById(prefix ? 'editSieveScript' : 'sieveScript');
+            const status = document.getElementById(prefix ? 'editSieveStatus' : 'sieveStatus');
+            clearTimeout(sieveTimers[prefix]);
+            if (!textarea.value.trim()) {
+                status.innerHTML = '';
+                return;
+            }
+            sieveTimers[prefix] = setTimeout(async () => {
+                try {
+                    const response = await fetch('/api/sieve/validate', {
+                        method: 'POST',
+                        headers: { 'Content-Type': 'application/json' },
+                        body: JSON.stringify({ script: textarea.value })
+                    });
+                    const result = await response.json();
+                    status.innerHTML = result.success
+                        ? '<small style="color:#28a745;">✓ ' + escapeHTML(result.message) + '</small>'
+                        : ` + "`" + `<div class="template-preview error">${escapeHTML(result.message)}</div>` + "`" + `;
+                } catch (error) {
+                    status.innerHTML = ` + "`" + `<div class="template-preview error">${escapeHTML(error.message)}</div>` + "`" + `;
+                }
+            }, 400);
+        }
+
+        async function fetchSieveScript() {
+            const server = prompt('ManageSieve server (host:port), empty for the account server on port 4190', '');
+            if (server === null) return;
+            const status = document.getElementById('editSieveStatus');
+            status.innerHTML = '<small style="color:#999;">Fetching active script...</small>';
+            try {
+                const query = server.trim() ? '?server=' + encodeURIComponent(server.trim()) : '';
+                const response = await fetch('/api/accounts/' + encodeURIComponent(editAccountId) + '/sieve' + query, { method: 'POST' });
+                const result = await response.json();
+                if (!result.success) {
+                    status.innerHTML = ` + "`" + `<div class="template-preview error">${escapeHTML(result.message)}</div>` + "`" + `;
+                    return;
+                }
+                document.getElementById('editSieveScript').value = result.script;
+                validateSieve('edit');
+                scheduleFilterPreview();
+            } catch (error) {
+                status.innerHTML = ` + "`" + `<div class="template-preview error">${escapeHTML(error.message)}</div>` + "`" + `;
+            }
+        }
+
+        function scheduleFilterPreview() {
+            if (!filterPreviewActive) return;
+            clearTimeout(filterPreviewTimer);
+            filterPreviewTimer = setTimeout(runFilterPreview, 800);
+        }
+
+        async function runFilterPreview() {
+            filterPreviewActive = true;
+            const container = document.getElementById('editFilterPreview');
+            const data = editFilterSettings();
+            data.folder_mode = document.getElementById('editFolderMode').value;
+            data.include_folders = data.folder_mode === 'include' ? editSelectedFolders : [];
+            data.exclude_folders = data.folder_mode === 'exclude' ? editSelectedFolders : [];
+            container.innerHTML = '<p style="color:#999;">Checking recent mail...</p>';
+
+            try {
+                const response = await fetch('/api/accounts/' + encodeURIComponent(editAccountId) + '/filter-preview', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(data)
+                });
+                const result = await response.json();
+                if (!result.success) {
+                    container.innerHTML = ` + "`" + `<div class="template-preview error">${escapeHTML(result.message)}</div>` + "`" + `;
+                    return;
+                }
+                container.innerHTML = ` + "`" + `
+                    <p><strong>${escapeHTML(result.message)}</strong></p>
+                    <table class="notification-table">
+                        <thead><tr><th></th><th>From</th><th>Subject</th><th>Folder</th><th>Why</th></tr></thead>
+                        <tbody>${result.messages.map(m => ` + "`" + `
+                            <tr class="${m.notify ? '' : 'message-read'}">
+                                <td>${m.notify ? '🔔' : '🚫'}</td>
+                                <td>${authBadge(m.auth)}${escapeHTML(m.from)}</td>
+                                <td>${escapeHTML(m.subject)}</td>
+                                <td>${escapeHTML(m.folder)}</td>
+                                <td>${escapeHTML(m.reason)}</td>
+                            </tr>` + "`" + `).join('')}
+                        </tbody>
+                    </table>` + "`" + `;
+            } catch (error) {
+                container.innerHTML = ` + "`" + `<div class="template-preview error">${escapeHTML(String(error))}</div>` + "`" + `;
+            }
+        }
+
+        async function testConnection() {
+            const data = {
+                protocol: document.getElementById('protocol').value,
+                server: document.getElementById('server').value,
+                port: parseInt(document.getElementById('port').value),
+                username: document.getElementById('username').value,
+                password: document.getElementById('password').value,
+                path: document.getElementById('path').value,
+                auth_type: document.getElementById('authType').value
             };
 
             try {
@@ -1041,14 +1824,26 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             const data = {
                 protocol: document.getElementById('protocol').value,
                 email: document.getElementById('email').value,
+                label: document.getElementById('label').value,
+                webmail_url: document.getElementById('webmailUrl').value,
+                title_template: document.getElementById('titleTemplate').value,
+                body_template: document.getElementById('bodyTemplate').value,
+                show_snippet: document.getElementById('showSnippet').checked,
+                detect_codes: document.getElementById('detectCodes').checked,
+                normalize_plus_tags: document.getElementById('normalizePlusTags').checked,
+                sieve_script: document.getElementById('sieveScript').value,
                 server: document.getElementById('server').value,
                 port: parseInt(document.getElementById('port').value),
                 username: document.getElementById('username').value,
                 password: document.getElementById('password').value,
+                path: document.getElementById('path').value,
+                auth_type: document.getElementById('protocol').value === 'jmap' ? document.getElementById('authType').value : '',
+                jmap_push: document.getElementById('jmapPush').checked,
                 check_interval: parseInt(document.getElementById('interval').value),
                 folder_mode: folderMode,
                 include_folders: includeFolders,
                 exclude_folders: excludeFolders,
+                filter_sets: document.getElementById('filterSets').value.split(',').map(s => s.trim()).filter(s => s),
                 include_keyword: document.getElementById('includeKeywords').value.split(',').map(s => s.trim()).filter(s => s),
                 exclude_keyword: document.getElementById('excludeKeywords').value.split(',').map(s => s.trim()).filter(s => s),
                 include_email: document.getElementById('includeEmails').value.split(',').map(s => s.trim()).filter(s => s),
@@ -1088,20 +1883,28 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             }
 
             const data = {
+                id: accounts[index].id,
                 index: index,
                 email: document.getElementById('editEmail').value,
+                protocol: document.getElementById('editProtocol').value,
+                label: document.getElementById('editLabel').value,
+                webmail_url: document.getElementById('editWebmailUrl').value,
+                title_template: document.getElementById('editTitleTemplate').value,
+                body_template: document.getElementById('editBodyTemplate').value,
+                show_snippet: document.getElementById('editShowSnippet').checked,
+                detect_codes: document.getElementById('editDetectCodes').checked,
                 server: document.getElementById('editServer').value,
                 port: parseInt(document.getElementById('editPort').value),
                 username: document.getElementById('editUsername').value,
                 password: document.getElementById('editPassword').value,
+                path: document.getElementById('editPath').value,
+                auth_type: document.getElementById('editProtocol').value === 'jmap' ? document.getElementById('editAuthType').value : '',
+                jmap_push: document.getElementById('editJmapPush').checked,
                 check_interval: parseInt(document.getElementById('editInterval').value),
                 folder_mode: folderMode,
                 include_folders: includeFolders,
                 exclude_folders: excludeFolders,
-                include_keyword: document.getElementById('editIncludeKeywords').value.split(',').map(s => s.trim()).filter(s => s),
-                exclude_keyword: document.getElementById('editExcludeKeywords').value.split(',').map(s => s.trim()).filter(s => s),
-                include_email: document.getElementById('editIncludeEmails').value.split(',').map(s => s.trim()).filter(s => s),
-                exclude_email: document.getElementById('editExcludeEmails').value.split(',').map(s => s.trim()).filter(s => s)
+                ...editFilterSettings()
             };
 
             try {
@@ -1115,7 +1918,7 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                     closeEditModal();
                     loadAccounts();
                 } else {
-                    showToast('Failed to update account', 'error');
+                    showToast('Failed to update account: ' + (await response.text()).trim(), 'error');
                 }
             } catch (error) {
                 showToast('Error: ' + error, 'error');
@@ -1128,14 +1931,31 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                 .then(accounts => {
                     const acc = accounts[index];
                     document.getElementById('editIndex').value = index;
+                    editAccountId = acc.id;
+                    filterPreviewActive = false;
+                    document.getElementById('editFilterPreview').innerHTML = '';
+                    loadFilterSetNames();
                     document.getElementById('editProtocol').value = acc.protocol;
                     document.getElementById('editEmail').value = acc.email;
+                    document.getElementById('editLabel').value = acc.label || '';
+                    document.getElementById('editWebmailUrl').value = acc.webmail_url || '';
+                    document.getElementById('editTitleTemplate').value = acc.title_template || '';
+                    document.getElementById('editBodyTemplate').value = acc.body_template || '';
+                    document.getElementById('editShowSnippet').checked = !!acc.show_snippet;
+                    document.getElementById('editDetectCodes').checked = !!acc.detect_codes;
+                    document.getElementById('editNormalizePlusTags').checked = !!acc.normalize_plus_tags;
+                    document.getElementById('editSieveScript').value = acc.sieve_script || '';
+                    validateSieve('edit');
                     document.getElementById('editServer').value = acc.server;
                     document.getElementById('editPort').value = acc.port;
                     document.getElementById('editUsername').value = acc.username;
                     document.getElementById('editPassword').value = '';
+                    document.getElementById('editPath').value = acc.path || '';
+                    document.getElementById('editAuthType').value = acc.auth_type || 'basic';
+                    document.getElementById('editJmapPush').checked = !!acc.jmap_push;
                     document.getElementById('editInterval').value = acc.check_interval;
                     document.getElementById('editFolderMode').value = acc.folder_mode;
+                    document.getElementById('editFilterSets').value = (acc.filter_sets || []).join(', ');
                     document.getElementById('editIncludeKeywords').value = (acc.include_keyword || []).join(', ');
                     document.getElementById('editExcludeKeywords').value = (acc.exclude_keyword || []).join(', ');
                     document.getElementById('editIncludeEmails').value = (acc.include_email || []).join(', ');
@@ -1150,13 +1970,10 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                         editSelectedFolders = acc.exclude_folders || [];
                     }
 
-                    if (acc.protocol === 'pop3') {
-                        document.getElementById('editFolderSettings').style.display = 'none';
-                    } else {
-                        document.getElementById('editFolderSettings').style.display = 'block';
-                    }
-
+                    document.getElementById('editProtocol').dataset.original = acc.protocol;
+                    updateEditProtocolSettings(false);
                     updateEditFolderMode();
+                    previewTemplate('edit');
                     document.getElementById('editModal').style.display = 'block';
                 });
         }
@@ -1190,6 +2007,28 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             }
         }
 
+        async function checkAccountNow(id) {
+            showToast('Checking...');
+            try {
+                const response = await fetch(` + "`" + `/api/accounts/${id}/check` + "`" + `, { method: 'POST' });
+                const result = await response.json();
+                showToast(result.message, result.success ? 'success' : 'error');
+            } catch (error) {
+                showToast('Error: ' + error, 'error');
+            }
+        }
+
+        async function accountAction(id, action) {
+            try {
+                const response = await fetch(` + "`" + `/api/accounts/${id}/${action}` + "`" + `, { method: 'POST' });
+                const result = await response.json();
+                showToast(result.message, result.success ? 'success' : 'error');
+                loadAccounts();
+            } catch (error) {
+                showToast('Error: ' + error, 'error');
+            }
+        }
+
         async function clearHistory() {
             if (!confirm('Clear all notification history?')) return;
             try {
@@ -1210,11 +2049,384 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             }
         }
 
+        async function loadDoNotDisturb() {
+            try {
+                const response = await fetch('/api/dnd');
+                renderDoNotDisturb(await response.json());
+            } catch (error) {
+                console.error('Failed to load do not disturb status:', error);
+            }
+        }
+
+        function renderDoNotDisturb(status) {
+            const el = document.getElementById('dndStatus');
+            const button = document.getElementById('dndButton');
+            if (status.active) {
+                el.style.display = 'block';
+                el.textContent = '🔕 Do not disturb until ' + status.until;
+                button.textContent = '🔔 Resume Notifications';
+            } else {
+                el.style.display = 'none';
+                button.textContent = '🔕 Do Not Disturb';
+            }
+        }
+
+        async function toggleDoNotDisturb() {
+            let minutes = 0;
+            if (document.getElementById('dndStatus').style.display === 'none') {
+                const input = prompt('Do not disturb for how many minutes?', '60');
+                if (!input) return;
+                minutes = parseInt(input);
+                if (!(minutes > 0)) {
+                    showToast('Please enter a number of minutes', 'error');
+                    return;
+                }
+            }
+
+            try {
+                const response = await fetch('/api/dnd', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ minutes })
+                });
+                renderDoNotDisturb(await response.json());
+                showToast(minutes > 0 ? 'Notifications paused for ' + minutes + ' minutes' : 'Notifications resumed');
+            } catch (error) {
+                showToast('Error: ' + error, 'error');
+            }
+        }
+
+        // authBadge marks suspicious senders with the reasons as a tooltip,
+        // and senders verified by DMARC with a check mark.
+        function authBadge(auth) {
+            if (!auth) return '';
+            if (auth.status === 'suspicious') {
+                return ` + "`" + `<span class="auth-warning" title="${escapeHTML((auth.warnings || []).join('; '))}">⚠️ ${escapeHTML((auth.warnings || [])[0] || '')}</span><br>` + "`" + `;
+            }
+            if (auth.status === 'verified') {
+                return '<span class="auth-verified" title="DMARC pass">✓</span> ';
+            }
+            return '';
+        }
+
+        function inviteDetails(invite) {
+            if (!invite) return '';
+            const parts = [];
+            if (invite.method === 'CANCEL') parts.push('Cancelled');
+            if (!invite.start.startsWith('0001')) {
+                const start = new Date(invite.start);
+                parts.push(invite.all_day ? start.toLocaleDateString() + ' (all day)' : start.toLocaleString());
+            }
+            if (invite.location) parts.push('@ ' + invite.location);
+            if (invite.organizer) parts.push('organizer ' + invite.organizer);
+            return ` + "`" + `<br><small style="color:#555;">📅 ${escapeHTML(parts.join(' · '))}</small>` + "`" + `;
+        }
+
+        function attachmentDetails(attachments) {
+            if (!attachments || attachments.length === 0) return '';
+            const names = attachments.map(a => a.name || a.type);
+            const title = attachments.map(a => ` + "`" + `${a.name || '(unnamed)'} — ${a.type}, ${formatBytes(a.size)}` + "`" + `).join('\n');
+            return ` + "`" + `<br><small style="color:#555;" title="${escapeHTML(title)}">📎 ${escapeHTML(names.join(', '))}</small>` + "`" + `;
+        }
+
+        function formatBytes(n) {
+            if (n >= 1048576) return (n / 1048576).toFixed(1) + ' MB';
+            if (n >= 1024) return Math.round(n / 1024) + ' KB';
+            return n + ' B';
+        }
+
+        function escapeHTML(s) {
+            const div = document.createElement('div');
+            div.textContent = s == null ? '' : String(s);
+            return div.innerHTML.replace(/"/g, '&quot;');
+        }
+
+        async function loadNotifications() {
+            const account = document.getElementById('notificationAccount').value;
+            const folder = document.getElementById('notificationFolder').value;
+            const rule = document.getElementById('notificationRule').value;
+            const params = new URLSearchParams();
+            if (account) params.set('account', account);
+            if (folder) params.set('folder', folder);
+            if (rule) params.set('rule', rule);
+
+            try {
+                const response = await fetch('/api/notifications?' + params.toString());
+                const notifications = await response.json();
+                if (!rule) updateRuleFilter(notifications);
+                const list = document.getElementById('notificationList');
+                if (notifications.length === 0) {
+                    list.innerHTML = '<tr><td colspan="9" style="text-align:center;color:#999;">No notifications yet</td></tr>';
+                    return;
+                }
+                list.innerHTML = notifications.map(n => ` + "`" + `
+                    <tr class="${n.archived ? 'message-archived' : n.read ? 'message-read' : ''}">
+                        <td>${new Date(n.time).toLocaleTimeString()}</td>
+                        <td>${escapeHTML(n.account)}</td>
+                        <td>${escapeHTML(n.folder)}</td>
+                        <td>${authBadge(n.auth)}${escapeHTML(n.sender)}</td>
+                        <td>${n.code ? ` + "`" + `<strong>🔑 ${escapeHTML(n.code)}</strong> ` + "`" + ` : ''}${escapeHTML(n.subject)}${inviteDetails(n.invite)}${attachmentDetails(n.attachments)}${n.snippet ? ` + "`" + `<br><small style="color:#999;">${escapeHTML(n.snippet)}</small>` + "`" + ` : ''}</td>
+                        <td>${escapeHTML(n.rule || '')}</td>
+                        <td>${escapeHTML(n.priority)}</td>
+                        <td>${escapeHTML(n.status)}</td>
+                        <td class="message-actions">${messageActionButtons(n)}</td>
+                    </tr>
+                ` + "`" + `).join('');
+            } catch (error) {
+                console.error('Failed to load notifications:', error);
+            }
+        }
+
+        function messageActionButtons(n) {
+            const actions = n.actions || [];
+            let html = '';
+            if (actions.includes('read') && !n.read && !n.archived) {
+                html += ` + "`" + `<button class="btn btn-primary btn-sm" onclick="messageAction(${n.id}, 'read')">✓ Read</button>` + "`" + `;
+            }
+            if (actions.includes('archive') && !n.archived) {
+                html += ` + "`" + `<button class="btn btn-success btn-sm" onclick="messageAction(${n.id}, 'archive')">🗄 Archive</button>` + "`" + `;
+            }
+            if (n.webmail_url) {
+                html += ` + "`" + `<a class="btn btn-primary btn-sm" href="${escapeHTML(n.webmail_url)}" target="_blank" rel="noopener">↗ Open</a>` + "`" + `;
+            }
+            const mutes = actions.filter(a => a.startsWith('mute-'));
+            if (mutes.length > 0) {
+                html += ` + "`" + `<select onchange="muteMessage(${n.id}, this)"><option value="">🔇 Mute…</option>${mutes.map(a => ` + "`" + `<option value="${a}">${a.slice(5)}</option>` + "`" + `).join('')}</select>` + "`" + `;
+            }
+            return html;
+        }
+
+        function updateRuleFilter(notifications) {
+            const select = document.getElementById('notificationRule');
+            const current = select.value;
+            const rules = [...new Set(notifications.map(n => n.rule).filter(r => r))].sort();
+            select.innerHTML = '<option value="">All rules</option>' +
+                rules.map(r => ` + "`" + `<option value="${escapeHTML(r)}">${escapeHTML(r)}</option>` + "`" + `).join('');
+            select.value = current;
+        }
+
+        async function messageAction(id, action, query) {
+            try {
+                const response = await fetch('/api/notifications/' + id + '/' + action + (query || ''), { method: 'POST' });
+                const result = await response.json();
+                showToast(result.message, result.success ? 'success' : 'error');
+                if (result.success) {
+                    loadNotifications();
+                    if (action.startsWith('mute-')) loadMutes();
+                }
+            } catch (error) {
+                showToast('Error: ' + error, 'error');
+            }
+        }
+
+        function muteMessage(id, select) {
+            const action = select.value;
+            select.value = '';
+            if (!action) return;
+            const duration = prompt('Mute for how long? For example 8h, 1d or 1w. Leave empty to mute until removed.', '1w');
+            if (duration === null) return;
+            messageAction(id, action, duration ? '?for=' + encodeURIComponent(duration) : '');
+        }
+
+        async function loadMutes() {
+            try {
+                const response = await fetch('/api/mutes');
+                const mutes = await response.json();
+                const list = document.getElementById('muteList');
+                if (mutes.length === 0) {
+                    list.innerHTML = '<tr><td colspan="6" style="text-align:center;color:#999;">Nothing muted</td></tr>';
+                    return;
+                }
+                list.innerHTML = mutes.map(m => ` + "`" + `
+                    <tr>
+                        <td>${escapeHTML(m.type)}</td>
+                        <td>${escapeHTML(m.value)}</td>
+                        <td>${escapeHTML(m.account || 'All accounts')}</td>
+                        <td>${escapeHTML(m.note || '')}</td>
+                        <td>${m.until ? new Date(m.until).toLocaleString() : 'Until removed'}</td>
+                        <td><button class="btn btn-danger btn-sm" onclick="removeMute('${escapeHTML(m.id)}')">Remove</button></td>
+                    </tr>
+                ` + "`" + `).join('');
+            } catch (error) {
+                console.error('Failed to load mutes:', error);
+            }
+        }
+
+        async function addMute() {
+            const data = {
+                type: document.getElementById('muteType').value,
+                value: document.getElementById('muteValue').value,
+                account: document.getElementById('muteAccount').value,
+                for: document.getElementById('muteFor').value
+            };
+            try {
+                const response = await fetch('/api/mutes', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(data)
+                });
+                const result = await response.json();
+                showToast(result.message, result.success ? 'success' : 'error');
+                if (result.success) {
+                    document.getElementById('muteValue').value = '';
+                    loadMutes();
+                }
+            } catch (error) {
+                showToast('Error: ' + error, 'error');
+            }
+        }
+
+        async function removeMute(id) {
+            try {
+                const response = await fetch('/api/mutes/' + encodeURIComponent(id) + '/delete', { method: 'POST' });
+                const result = await response.json();
+                showToast(result.message, result.success ? 'success' : 'error');
+                loadMutes();
+            } catch (error) {
+                showToast('Error: ' + error, 'error');
+            }
+        }
+
+        async function loadCodes() {
+            try {
+                const response = await fetch('/api/codes');
+                const codes = await response.json();
+                document.getElementById('codes').style.display = codes.length === 0 ? 'none' : 'block';
+                document.getElementById('codeList').innerHTML = codes.map(c => ` + "`" + `
+                    <tr>
+                        <td>${new Date(c.time).toLocaleTimeString()}</td>
+                        <td>${escapeHTML(c.account)}</td>
+                        <td>${escapeHTML(c.sender)}</td>
+                        <td class="code">${escapeHTML(c.code)}</td>
+                        <td><button class="btn btn-primary btn-sm" onclick="copyCode(${c.id}, '${escapeHTML(c.code)}')">📋 Copy code</button></td>
+                    </tr>
+                ` + "`" + `).join('');
+            } catch (error) {
+                console.error('Failed to load codes:', error);
+            }
+        }
+
+        async function copyCode(id, code) {
+            // Prefer the browser clipboard; fall back to the system clipboard
+            // on the machine running the monitor.
+            if (navigator.clipboard && window.isSecureContext) {
+                try {
+                    await navigator.clipboard.writeText(code);
+                    showToast('Code copied to clipboard', 'success');
+                    return;
+                } catch (error) {}
+            }
+            try {
+                const response = await fetch('/api/codes/copy', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ id })
+                });
+                const result = await response.json();
+                showToast(result.message, result.success ? 'success' : 'error');
+            } catch (error) {
+                showToast('Error: ' + error, 'error');
+            }
+        }
+
+        async function loadAlerts() {
+            try {
+                const response = await fetch('/api/alerts');
+                const alerts = await response.json();
+                const banner = document.getElementById('alertsBanner');
+                if (alerts.length === 0) {
+                    banner.style.display = 'none';
+                    return;
+                }
+                banner.style.display = 'block';
+                banner.innerHTML = '<strong>🚨 Unacknowledged alerts</strong>' + alerts.map(a => ` + "`" + `
+                    <div class="alert-item">
+                        <span>${escapeHTML(a.title)} - ${escapeHTML(a.message)}</span>
+                        <button class="btn btn-danger btn-sm" onclick="acknowledgeAlert(${a.id})">Acknowledge</button>
+                    </div>
+                ` + "`" + `).join('');
+            } catch (error) {
+                console.error('Failed to load alerts:', error);
+            }
+        }
+
+        async function acknowledgeAlert(id) {
+            try {
+                await fetch('/api/alerts/ack', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ id })
+                });
+                loadAlerts();
+            } catch (error) {
+                showToast('Error: ' + error, 'error');
+            }
+        }
+
+        function updateNotificationAccounts(accounts) {
+            for (const id of ['notificationAccount', 'logAccount', 'muteAccount']) {
+                const select = document.getElementById(id);
+                const current = select.value;
+                select.innerHTML = '<option value="">All accounts</option>' +
+                    accounts.map(acc => ` + "`" + `<option value="${escapeHTML(acc.email)}">${escapeHTML(acc.email)}</option>` + "`" + `).join('');
+                select.value = current;
+            }
+        }
+
+        async function loadLogs() {
+            const params = new URLSearchParams({ tail: '200' });
+            const level = document.getElementById('logFilterLevel').value;
+            const account = document.getElementById('logAccount').value;
+            if (level) params.set('level', level);
+            if (account) params.set('account', account);
+
+            try {
+                const response = await fetch('/api/logs?' + params.toString());
+                const result = await response.json();
+                document.getElementById('logLevel').value = result.level;
+                const list = document.getElementById('logList');
+                if (result.entries.length === 0) {
+                    list.innerHTML = '<tr><td colspan="4" style="text-align:center;color:#999;">No log entries</td></tr>';
+                    return;
+                }
+                list.innerHTML = result.entries.slice().reverse().map(e => {
+                    const attrs = Object.entries(e.attrs || {}).map(([k, v]) => ` + "`" + `${k}=${v}` + "`" + `).join(' ');
+                    return ` + "`" + `
+                    <tr>
+                        <td>${new Date(e.time).toLocaleTimeString()}</td>
+                        <td class="log-${e.level.toLowerCase()}">${escapeHTML(e.level)}</td>
+                        <td>${escapeHTML(e.account)}</td>
+                        <td>${escapeHTML(e.message)}${attrs ? ` + "`" + `<br><small style="color:#999;">${escapeHTML(attrs)}</small>` + "`" + ` : ''}</td>
+                    </tr>
+                ` + "`" + `;
+                }).join('');
+            } catch (error) {
+                console.error('Failed to load logs:', error);
+            }
+        }
+
+        async function setLogLevel() {
+            try {
+                const response = await fetch('/api/logs/level', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ level: document.getElementById('logLevel').value })
+                });
+                const result = await response.json();
+                showToast(result.message, result.success ? 'success' : 'error');
+                loadLogs();
+            } catch (error) {
+                showToast('Error: ' + error, 'error');
+            }
+        }
+
         async function loadAccounts() {
             try {
                 const response = await fetch('/api/accounts');
                 const accounts = await response.json();
 
+                updateNotificationAccounts(accounts);
+
                 const container = document.getElementById('accounts');
                 if (accounts.length === 0) {
                     container.innerHTML = '<p style="text-align:center;color:#666;">No accounts configured. Click "Add Account" to get started.</p>';
@@ -1222,28 +2434,34 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
                 }
 
                 container.innerHTML = accounts.map((acc, index) => {
-                    const protocolClass = acc.protocol === 'pop3' ? 'protocol-pop3' : 'protocol-imap';
+                    const local = acc.protocol === 'maildir' || acc.protocol === 'mbox';
+                    const protocolClass = local ? 'protocol-local' : acc.protocol === 'pop3' ? 'protocol-pop3' : acc.protocol === 'jmap' ? 'protocol-jmap' : 'protocol-imap';
+                    const hasFolders = acc.protocol === 'imap' || acc.protocol === 'jmap' || acc.protocol === 'maildir';
                     const protocolText = acc.protocol.toUpperCase();
                     return ` + "`" + `
-                    <div class="account-card">
-                        <h3>${acc.email} <span class="protocol-badge ${protocolClass}">${protocolText}</span></h3>
-                        <div class="detail"><strong>Server:</strong> ${acc.server}:${acc.port}</div>
+                    <div class="account-card" data-account="${escapeHTML(acc.email)}">
+                        <h3>${acc.label ? escapeHTML(acc.label) + ' · ' : ''}${acc.email} <span class="protocol-badge ${protocolClass}">${protocolText}</span> <span class="pause-state">${acc.paused ? '⏸ Paused' : acc.snooze_until ? '💤 Snoozed until ' + new Date(acc.snooze_until).toLocaleTimeString() : ''}</span> <span class="check-state">${acc.monitoring ? '' : '⏹ Stopped'}</span></h3>
+                        ${local ? ` + "`" + `<div class="detail"><strong>Path:</strong> ${escapeHTML(acc.path)}</div>` + "`" + ` : ` + "`" + `<div class="detail"><strong>Server:</strong> ${acc.server}:${acc.port}</div>` + "`" + `}
                         <div class="detail"><strong>Interval:</strong> ${acc.check_interval}s</div>
-                        ${acc.protocol === 'imap' ? ` + "`" + `<div class="detail"><strong>Folder Mode:</strong> ${acc.folder_mode}</div>` + "`" + ` : ''}
-                        ${acc.protocol === 'imap' && acc.folder_mode === 'include' && acc.include_folders && acc.include_folders.length > 0 ?
+                        ${hasFolders ? ` + "`" + `<div class="detail"><strong>Folder Mode:</strong> ${acc.folder_mode}</div>` + "`" + ` : ''}
+                        ${hasFolders && acc.folder_mode === 'include' && acc.include_folders && acc.include_folders.length > 0 ?
                             ` + "`" + `<div class="detail"><strong>Include Folders:</strong> ${acc.include_folders.join(', ')}</div>` + "`" + ` : ''}
-                        ${acc.protocol === 'imap' && acc.folder_mode === 'exclude' && acc.exclude_folders && acc.exclude_folders.length > 0 ?
+                        ${hasFolders && acc.folder_mode === 'exclude' && acc.exclude_folders && acc.exclude_folders.length > 0 ?
                             ` + "`" + `<div class="detail"><strong>Exclude Folders:</strong> ${acc.exclude_folders.join(', ')}</div>` + "`" + ` : ''}
-                        ${acc.include_keyword && acc.include_keyword.length > 0 ?
-                            ` + "`" + `<div class="detail"><strong>Include Keywords:</strong> ${acc.include_keyword.join(', ')}</div>` + "`" + ` : ''}
-                        ${acc.exclude_keyword && acc.exclude_keyword.length > 0 ?
-                            ` + "`" + `<div class="detail"><strong>Exclude Keywords:</strong> ${acc.exclude_keyword.join(', ')}</div>` + "`" + ` : ''}
-                        ${acc.include_email && acc.include_email.length > 0 ?
-                            ` + "`" + `<div class="detail"><strong>Include Emails:</strong> ${acc.include_email.join(', ')}</div>` + "`" + ` : ''}
-                        ${acc.exclude_email && acc.exclude_email.length > 0 ?
-                            ` + "`" + `<div class="detail"><strong>Exclude Emails:</strong> ${acc.exclude_email.join(', ')}</div>` + "`" + ` : ''}
-                        <div class="detail"><strong>Last Check:</strong> ${acc.last_check || 'Never'}</div>
+                        ${filterDetails('Filter Sets', acc.filter_sets)}
+                        ${filterDetails('Include Keywords', acc.effective_filters.include_keyword)}
+                        ${filterDetails('Exclude Keywords', acc.effective_filters.exclude_keyword)}
+                        ${filterDetails('Include Senders', acc.effective_filters.include_email)}
+                        ${filterDetails('Exclude Senders', acc.effective_filters.exclude_email)}
+                        <div class="detail"><strong>Unread:</strong> <span class="unread-count">${acc.unread_count}</span></div>
+                        <div class="detail"><strong>Last Check:</strong> <span class="last-check">${acc.last_check || 'Never'}</span></div>
+                        <div class="detail last-error" style="display: ${acc.last_error ? 'block' : 'none'};">⚠️ ${escapeHTML(acc.last_error)}</div>
                         <div class="account-actions">
+                            <button class="btn btn-success btn-sm" onclick="checkAccountNow('${acc.id}')">Check Now</button>
+                            ${acc.paused || acc.snooze_until ?
+                                ` + "`" + `<button class="btn btn-success btn-sm" onclick="accountAction('${acc.id}', 'resume')">Resume</button>` + "`" + ` :
+                                ` + "`" + `<button class="btn btn-warning btn-sm" onclick="accountAction('${acc.id}', 'pause')">Pause</button>
+                                <button class="btn btn-warning btn-sm" onclick="accountAction('${acc.id}', 'snooze?until=1h')">Snooze 1h</button>` + "`" + `}
                             <button class="btn btn-primary btn-sm" onclick="editAccount(${index})">Edit</button>
                             <button class="btn btn-danger btn-sm" onclick="deleteAccount(${index})">Delete</button>
                         </div>
@@ -1255,15 +2473,95 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
             }
         }
 
-        loadAccounts();
-        setInterval(loadAccounts, 10000);
+        function accountCard(email) {
+            return Array.from(document.querySelectorAll('.account-card')).find(card => card.dataset.account === email);
+        }
+
+        function showAccountError(card, message) {
+            const el = card.querySelector('.last-error');
+            el.textContent = message ? '⚠️ ' + message : '';
+            el.style.display = message ? 'block' : 'none';
+        }
+
+        function connectEvents() {
+            const source = new EventSource('/api/events');
+            let connected = false;
+            const on = (type, handler) => source.addEventListener(type, e => {
+                // Connection failures are also dispatched as "error", without data.
+                if (!e.data) return;
+                const event = JSON.parse(e.data);
+                const card = event.account ? accountCard(event.account) : null;
+                handler(event, card);
+            });
+
+            source.onopen = () => {
+                // Catch up after a reconnect in case the backlog did not
+                // cover the gap.
+                if (connected) {
+                    loadAccounts();
+                    loadNotifications();
+                }
+                connected = true;
+            };
+
+            on('check_started', (event, card) => {
+                if (card) card.querySelector('.check-state').textContent = '🔄 Checking...';
+            });
+            on('check_finished', (event, card) => {
+                if (!card) return;
+                card.querySelector('.check-state').textContent = '';
+                card.querySelector('.unread-count').textContent = event.data.unread_count;
+                card.querySelector('.last-check').textContent = event.data.last_check || 'Never';
+                showAccountError(card, event.data.error);
+            });
+            on('error', (event, card) => {
+                if (card) showAccountError(card, event.data.message);
+            });
+            // Events with data come from pause, resume and snooze; the others
+            // from a monitor being stopped or started.
+            on('monitor_paused', (event, card) => {
+                if (event.data) loadAccounts();
+                else if (card) card.querySelector('.check-state').textContent = '⏹ Stopped';
+            });
+            on('monitor_resumed', (event, card) => {
+                if (event.data) loadAccounts();
+                else if (card) card.querySelector('.check-state').textContent = '';
+            });
+            on('notification', () => loadNotifications());
+            on('config_changed', () => {
+                loadAccounts();
+                loadMutes();
+            });
+            on('check_all_finished', () => showToast('Manual check completed'));
+        }
+
+        const initialParams = new URLSearchParams(window.location.search);
+
+        loadAccounts().then(() => {
+            document.getElementById('notificationAccount').value = initialParams.get('account') || '';
+            document.getElementById('notificationFolder').value = initialParams.get('folder') || '';
+            loadNotifications();
+        });
+        loadDoNotDisturb();
+        loadAlerts();
+        loadCodes();
+        loadLogs();
+        loadMutes();
+        connectEvents();
+        setInterval(loadCodes, 10000);
+        setInterval(loadAlerts, 10000);
+        setInterval(loadDoNotDisturb, 10000);
+        setInterval(loadLogs, 10000);
     </script>
 </body>
 </html>`))
+	csrfToken, _ := sessionCSRFToken(r)
 	data := struct {
-		AppDir string
+		AppDir    string
+		CSRFToken string
 	}{
-		AppDir: appDir,
+		AppDir:    appDir,
+		CSRFToken: csrfToken,
 	}
 
 	tmpl.Execute(w, data)
@@ -1273,20 +2571,39 @@ func handleAccounts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	type AccountResponse struct {
-		Email          string   `json:"email"`
-		Server         string   `json:"server"`
-		Port           int      `json:"port"`
-		Username       string   `json:"username"`
-		Protocol       string   `json:"protocol"`
-		CheckInterval  int      `json:"check_interval"`
-		FolderMode     string   `json:"folder_mode"`
-		IncludeFolders []string `json:"include_folders"`
-		ExcludeFolders []string `json:"exclude_folders"`
-		LastCheck      string   `json:"last_check"`
-		IncludeKeyword []string `json:"include_keyword"`
-		ExcludeKeyword []string `json:"exclude_keyword"`
-		IncludeEmail   []string `json:"include_email"`
-		ExcludeEmail   []string `json:"exclude_email"`
+		ID                string    `json:"id"`
+		Email             string    `json:"email"`
+		Server            string    `json:"server"`
+		Port              int       `json:"port"`
+		Username          string    `json:"username"`
+		Protocol          string    `json:"protocol"`
+		Path              string    `json:"path"`
+		AuthType          string    `json:"auth_type"`
+		JMAPPush          bool      `json:"jmap_push"`
+		CheckInterval     int       `json:"check_interval"`
+		FolderMode        string    `json:"folder_mode"`
+		IncludeFolders    []string  `json:"include_folders"`
+		ExcludeFolders    []string  `json:"exclude_folders"`
+		LastCheck         string    `json:"last_check"`
+		UnreadCount       int       `json:"unread_count"`
+		LastError         string    `json:"last_error"`
+		Monitoring        bool      `json:"monitoring"`
+		Paused            bool      `json:"paused"`
+		SnoozeUntil       string    `json:"snooze_until"`
+		IncludeKeyword    []string  `json:"include_keyword"`
+		ExcludeKeyword    []string  `json:"exclude_keyword"`
+		IncludeEmail      []string  `json:"include_email"`
+		ExcludeEmail      []string  `json:"exclude_email"`
+		Label             string    `json:"label"`
+		TitleTemplate     string    `json:"title_template"`
+		BodyTemplate      string    `json:"body_template"`
+		ShowSnippet       bool      `json:"show_snippet"`
+		DetectCodes       bool      `json:"detect_codes"`
+		NormalizePlusTags bool      `json:"normalize_plus_tags"`
+		WebmailURL        string    `json:"webmail_url"`
+		FilterSets        []string  `json:"filter_sets"`
+		SieveScript       string    `json:"sieve_script"`
+		EffectiveFilters  FilterSet `json:"effective_filters"` // global, filter sets and account filters merged
 	}
 
 	accounts := make([]AccountResponse, len(config.Accounts))
@@ -1296,23 +2613,47 @@ func handleAccounts(w http.ResponseWriter, r *http.Request) {
 		if !acc.lastCheckTime.IsZero() {
 			lastCheck = acc.lastCheckTime.Format("15:04:05")
 		}
+		unreadCount, lastError, monitoring := acc.unreadCount, acc.lastError, acc.monitoring
+		snoozeUntil := ""
+		if acc.SnoozeUntil != nil && time.Now().Before(*acc.SnoozeUntil) {
+			snoozeUntil = acc.SnoozeUntil.Format(time.RFC3339)
+		}
 		acc.mu.RUnlock()
 
 		accounts[i] = AccountResponse{
-			Email:          acc.Email,
-			Server:         acc.Server,
-			Port:           acc.Port,
-			Username:       acc.Username,
-			Protocol:       acc.Protocol,
-			CheckInterval:  acc.CheckInterval,
-			FolderMode:     acc.FolderMode,
-			IncludeFolders: acc.IncludeFolders,
-			ExcludeFolders: acc.ExcludeFolders,
-			IncludeKeyword: acc.IncludeKeyword,
-			ExcludeKeyword: acc.ExcludeKeyword,
-			IncludeEmail:   acc.IncludeEmail,
-			ExcludeEmail:   acc.ExcludeEmail,
-			LastCheck:      lastCheck,
+			ID:                acc.ID,
+			Email:             acc.Email,
+			Server:            acc.Server,
+			Port:              acc.Port,
+			Username:          acc.Username,
+			Protocol:          acc.Protocol,
+			Path:              acc.Path,
+			AuthType:          acc.AuthType,
+			JMAPPush:          acc.JMAPPush,
+			CheckInterval:     acc.CheckInterval,
+			FolderMode:        acc.FolderMode,
+			IncludeFolders:    acc.IncludeFolders,
+			ExcludeFolders:    acc.ExcludeFolders,
+			IncludeKeyword:    acc.IncludeKeyword,
+			ExcludeKeyword:    acc.ExcludeKeyword,
+			IncludeEmail:      acc.IncludeEmail,
+			ExcludeEmail:      acc.ExcludeEmail,
+			Label:             acc.Label,
+			TitleTemplate:     acc.TitleTemplate,
+			BodyTemplate:      acc.BodyTemplate,
+			ShowSnippet:       acc.ShowSnippet,
+			DetectCodes:       acc.DetectCodes,
+			NormalizePlusTags: acc.NormalizePlusTags,
+			WebmailURL:        acc.WebmailURL,
+			FilterSets:        acc.FilterSets,
+			SieveScript:       acc.SieveScript,
+			EffectiveFilters:  effectiveFilters(&acc),
+			LastCheck:         lastCheck,
+			UnreadCount:       unreadCount,
+			LastError:         lastError,
+			Monitoring:        monitoring,
+			Paused:            acc.Paused,
+			SnoozeUntil:       snoozeUntil,
 		}
 	}
 
@@ -1326,20 +2667,32 @@ func handleAddAccount(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var newAccount struct {
-		Email          string   `json:"email"`
-		Server         string   `json:"server"`
-		Port           int      `json:"port"`
-		Username       string   `json:"username"`
-		Password       string   `json:"password"`
-		Protocol       string   `json:"protocol"`
-		CheckInterval  int      `json:"check_interval"`
-		FolderMode     string   `json:"folder_mode"`
-		IncludeFolders []string `json:"include_folders"`
-		ExcludeFolders []string `json:"exclude_folders"`
-		IncludeKeyword []string `json:"include_keyword"`
-		ExcludeKeyword []string `json:"exclude_keyword"`
-		IncludeEmail   []string `json:"include_email"`
-		ExcludeEmail   []string `json:"exclude_email"`
+		Email             string   `json:"email"`
+		Server            string   `json:"server"`
+		Port              int      `json:"port"`
+		Username          string   `json:"username"`
+		Password          string   `json:"password"`
+		Protocol          string   `json:"protocol"`
+		Path              string   `json:"path"`
+		AuthType          string   `json:"auth_type"`
+		JMAPPush          bool     `json:"jmap_push"`
+		CheckInterval     int      `json:"check_interval"`
+		FolderMode        string   `json:"folder_mode"`
+		IncludeFolders    []string `json:"include_folders"`
+		ExcludeFolders    []string `json:"exclude_folders"`
+		IncludeKeyword    []string `json:"include_keyword"`
+		ExcludeKeyword    []string `json:"exclude_keyword"`
+		IncludeEmail      []string `json:"include_email"`
+		ExcludeEmail      []string `json:"exclude_email"`
+		Label             string   `json:"label"`
+		TitleTemplate     string   `json:"title_template"`
+		BodyTemplate      string   `json:"body_template"`
+		ShowSnippet       bool     `json:"show_snippet"`
+		DetectCodes       bool     `json:"detect_codes"`
+		NormalizePlusTags bool     `json:"normalize_plus_tags"`
+		FilterSets        []string `json:"filter_sets"`
+		SieveScript       string   `json:"sieve_script"`
+		WebmailURL        string   `json:"webmail_url"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&newAccount); err != nil {
@@ -1347,17 +2700,51 @@ func handleAddAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := setPassword(newAccount.Email, newAccount.Password); err != nil {
+	if err := validateNotificationTemplates(newAccount.TitleTemplate, newAccount.BodyTemplate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateSenderPatterns(append(newAccount.IncludeEmail, newAccount.ExcludeEmail...)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateAccountFilterSets(newAccount.FilterSets); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := parseSieve(newAccount.SieveScript); err != nil {
+		http.Error(w, "Invalid Sieve script: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if newAccount.Protocol == "jmap" && newAccount.AuthType != "" && newAccount.AuthType != "basic" && newAccount.AuthType != "bearer" {
+		http.Error(w, "Invalid auth_type", http.StatusBadRequest)
+		return
+	}
+
+	if isLocalProtocol(newAccount.Protocol) {
+		if newAccount.Path == "" {
+			http.Error(w, "Path is required for local mail sources", http.StatusBadRequest)
+			return
+		}
+	} else if err := setPassword(newAccount.Email, newAccount.Password); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to store password in keyring: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	acc := AccountConfig{
+		ID:                      newID(),
 		Email:                   newAccount.Email,
 		Server:                  newAccount.Server,
 		Port:                    newAccount.Port,
 		Username:                newAccount.Username,
 		Protocol:                newAccount.Protocol,
+		Path:                    newAccount.Path,
+		AuthType:                newAccount.AuthType,
+		JMAPPush:                newAccount.JMAPPush,
 		CheckInterval:           newAccount.CheckInterval,
 		CheckHistory:            1000,
 		EnableNotificationSound: true,
@@ -1368,18 +2755,30 @@ func handleAddAccount(w http.ResponseWriter, r *http.Request) {
 		ExcludeKeyword:          newAccount.ExcludeKeyword,
 		IncludeEmail:            newAccount.IncludeEmail,
 		ExcludeEmail:            newAccount.ExcludeEmail,
+		Label:                   newAccount.Label,
+		TitleTemplate:           newAccount.TitleTemplate,
+		BodyTemplate:            newAccount.BodyTemplate,
+		ShowSnippet:             newAccount.ShowSnippet,
+		DetectCodes:             newAccount.DetectCodes,
+		NormalizePlusTags:       newAccount.NormalizePlusTags,
+		FilterSets:              newAccount.FilterSets,
+		SieveScript:             newAccount.SieveScript,
+		WebmailURL:              newAccount.WebmailURL,
 		notifiedEmails:          make(map[string]bool),
 		stopChan:                make(chan bool),
 	}
 
+	mu.Lock()
 	config.Accounts = append(config.Accounts, acc)
-
-	if err := saveConfig(); err != nil {
+	added := &config.Accounts[len(config.Accounts)-1]
+	err := saveConfig()
+	mu.Unlock()
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	go startMonitoring(&config.Accounts[len(config.Accounts)-1])
+	go startMonitoring(added)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
@@ -1391,64 +2790,88 @@ func handleUpdateAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var update struct {
-		Index          int      `json:"index"`
-		Email          string   `json:"email"`
-		Server         string   `json:"server"`
-		Port           int      `json:"port"`
-		Username       string   `json:"username"`
-		Password       string   `json:"password"`
-		CheckInterval  int      `json:"check_interval"`
-		FolderMode     string   `json:"folder_mode"`
-		IncludeFolders []string `json:"include_folders"`
-		ExcludeFolders []string `json:"exclude_folders"`
-		IncludeKeyword []string `json:"include_keyword"`
-		ExcludeKeyword []string `json:"exclude_keyword"`
-		IncludeEmail   []string `json:"include_email"`
-		ExcludeEmail   []string `json:"exclude_email"`
+	var update accountUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateNotificationTemplates(update.TitleTemplate, update.BodyTemplate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+	if err := validateSenderPatterns(append(update.IncludeEmail, update.ExcludeEmail...)); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if update.Index < 0 || update.Index >= len(config.Accounts) {
-		http.Error(w, "Invalid index", http.StatusBadRequest)
+	if err := validateAccountFilterSets(update.FilterSets); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	acc := &config.Accounts[update.Index]
+	if _, err := parseSieve(update.SieveScript); err != nil {
+		http.Error(w, "Invalid Sieve script: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if update.Password != "" {
-		if err := setPassword(acc.Email, update.Password); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to update password in keyring: %v", err), http.StatusInternalServerError)
-			return
-		}
+	// Held until the monitor runs again, so that a delete cannot move the
+	// account while it is updated.
+	mu.Lock()
+	defer mu.Unlock()
+
+	var acc *AccountConfig
+	if update.ID != "" {
+		acc = findAccountByID(update.ID)
+	} else if update.Index >= 0 && update.Index < len(config.Accounts) {
+		acc = &config.Accounts[update.Index]
+	}
+	if acc == nil || acc.deleting {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Account not found"})
+		return
 	}
 
-	acc.Server = update.Server
-	acc.Port = update.Port
-	acc.Username = update.Username
-	acc.CheckInterval = update.CheckInterval
-	acc.FolderMode = update.FolderMode
-	acc.IncludeFolders = update.IncludeFolders
-	acc.ExcludeFolders = update.ExcludeFolders
-	acc.IncludeKeyword = update.IncludeKeyword
-	acc.ExcludeKeyword = update.ExcludeKeyword
-	acc.IncludeEmail = update.IncludeEmail
-	acc.ExcludeEmail = update.ExcludeEmail
+	protocol := update.Protocol
+	if protocol == "" {
+		protocol = acc.Protocol
+	}
+	switch protocol {
+	case "imap", "pop3", "jmap", "maildir", "mbox":
+	default:
+		http.Error(w, "Invalid protocol", http.StatusBadRequest)
+		return
+	}
 
-	if err := saveConfig(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if isLocalProtocol(protocol) && update.Path == "" {
+		http.Error(w, "Path is required for local mail sources", http.StatusBadRequest)
+		return
+	}
+
+	if protocol == "jmap" && update.AuthType != "" && update.AuthType != "basic" && update.AuthType != "bearer" {
+		http.Error(w, "Invalid auth_type", http.StatusBadRequest)
 		return
 	}
 
+	// Stop the monitor and wait for a running check, so that nothing uses the
+	// old identity while it is being moved.
 	acc.stopChan <- true
 	time.Sleep(100 * time.Millisecond)
+	acc.checkMu.Lock()
+	err := updateAccount(acc, update)
+	acc.checkMu.Unlock()
+
 	acc.stopChan = make(chan bool)
 	go startMonitoring(acc)
 
+	if err != nil {
+		acc.logger().Error("Failed to update account", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
@@ -1468,22 +2891,40 @@ func handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Index < 0 || req.Index >= len(config.Accounts) {
+	mu.Lock()
+	if req.Index < 0 || req.Index >= len(config.Accounts) || config.Accounts[req.Index].deleting {
+		mu.Unlock()
 		http.Error(w, "Invalid index", http.StatusBadRequest)
 		return
 	}
-
+	// Keeps updates from stopping the monitor a second time.
+	config.Accounts[req.Index].deleting = true
+	id := config.Accounts[req.Index].ID
 	email := config.Accounts[req.Index].Email
+	protocol := config.Accounts[req.Index].Protocol
+	stopChan := config.Accounts[req.Index].stopChan
+	mu.Unlock()
 
-	config.Accounts[req.Index].stopChan <- true
+	// The monitor may be busy with a check, so this waits without the lock.
+	stopChan <- true
 
-	if err := deletePassword(email); err != nil {
-		log.Printf("Failed to delete password from keyring: %v", err)
+	if !isLocalProtocol(protocol) {
+		if err := deletePassword(email); err != nil {
+			slog.Warn("Failed to delete password from keyring", "account", email, "error", err)
+		}
 	}
 
-	config.Accounts = append(config.Accounts[:req.Index], config.Accounts[req.Index+1:]...)
-
-	if err := saveConfig(); err != nil {
+	mu.Lock()
+	for i := range config.Accounts {
+		if config.Accounts[i].ID == id {
+			config.Accounts = append(config.Accounts[:i], config.Accounts[i+1:]...)
+			break
+		}
+	}
+	err := saveConfig()
+	mu.Unlock()
+	sieveScriptCache.Delete(id)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -1504,6 +2945,8 @@ func handleFetchFolders(w http.ResponseWriter, r *http.Request) {
 		Username string `json:"username"`
 		Password string `json:"password"`
 		Protocol string `json:"protocol"`
+		Path     string `json:"path"`
+		AuthType string `json:"auth_type"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1511,10 +2954,50 @@ func handleFetchFolders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Protocol == "pop3" {
+	if req.Protocol == "pop3" || req.Protocol == "mbox" {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": false,
-			"message": "POP3 does not support folder listing (inbox only)",
+			"message": fmt.Sprintf("%s does not support folder listing (inbox only)", strings.ToUpper(req.Protocol)),
+		})
+		return
+	}
+
+	if req.Protocol == "jmap" {
+		c := newJMAPClient(req.Server, req.Port, req.AuthType, req.Username, req.Password)
+		err := c.fetchSession()
+		var mailboxes []jmapMailbox
+		if err == nil {
+			mailboxes, err = c.mailboxes()
+		}
+		if err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": fmt.Sprintf("Failed to list mailboxes: %v", err),
+			})
+			return
+		}
+		folders := listJMAPFolders(mailboxes)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"folders": folders,
+			"message": fmt.Sprintf("Successfully retrieved %d folders", len(folders)),
+		})
+		return
+	}
+
+	if req.Protocol == "maildir" {
+		if _, err := testLocalSource(req.Protocol, req.Path); err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": fmt.Sprintf("Maildir not found: %v", err),
+			})
+			return
+		}
+		folders := listMaildirFolders(expandPath(req.Path))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"folders": folders,
+			"message": fmt.Sprintf("Successfully retrieved %d folders", len(folders)),
 		})
 		return
 	}
@@ -1575,6 +3058,8 @@ func handleTestConnection(w http.ResponseWriter, r *http.Request) {
 		Port     int    `json:"port"`
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Path     string `json:"path"`
+		AuthType string `json:"auth_type"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&test); err != nil {
@@ -1582,6 +3067,38 @@ func handleTestConnection(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isLocalProtocol(test.Protocol) {
+		message, err := testLocalSource(test.Protocol, test.Path)
+		if err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": fmt.Sprintf("Local source check failed: %v", err),
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": message,
+		})
+		return
+	}
+
+	if test.Protocol == "jmap" {
+		count, err := testJMAPConnection(test.Server, test.Port, test.AuthType, test.Username, test.Password)
+		if err != nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": fmt.Sprintf("JMAP connection failed: %v", err),
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("✅ JMAP connection successful! Found %d mailboxes", count),
+		})
+		return
+	}
+
 	if test.Protocol == "pop3" {
 		if err := testPOP3Connection(test.Server, test.Port, test.Username, test.Password); err != nil {
 			json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1685,36 +3202,87 @@ func handleRestart(w http.ResponseWriter, r *http.Request) {
 }
 
 func startMonitoring(acc *AccountConfig) {
-	log.Printf("[%s] Monitor started (protocol: %s, interval: %ds)", acc.Email, acc.Protocol, acc.CheckInterval)
+	acc.logger().Info("Monitor started", "protocol", acc.Protocol, "interval", acc.CheckInterval)
+	acc.mu.Lock()
+	acc.monitoring = true
+	acc.mu.Unlock()
+	publishEvent(eventMonitorResumed, acc.Email, nil)
 
 	acc.ticker = time.NewTicker(time.Duration(acc.CheckInterval) * time.Second)
 	defer acc.ticker.Stop()
 
-	if acc.Protocol == "pop3" {
-		checkNewEmailsPOP3(acc)
-	} else {
-		checkNewEmails(acc)
+	// Local sources and JMAP push accounts are checked as soon as something
+	// changes; the ticker remains as a fallback. Otherwise changes stays nil.
+	var changes <-chan struct{}
+	stopWatching := func() {}
+	if isLocalProtocol(acc.Protocol) {
+		changes, stopWatching = watchLocalSource(acc)
+	} else if acc.Protocol == "jmap" && acc.JMAPPush {
+		changes, stopWatching = watchJMAPPush(acc)
 	}
+	defer stopWatching()
+
+	checkIfActive(acc)
 
 	for {
 		select {
 		case <-acc.ticker.C:
-			if acc.Protocol == "pop3" {
-				checkNewEmailsPOP3(acc)
-			} else {
-				checkNewEmails(acc)
-			}
+			checkIfActive(acc)
+		case <-changes:
+			checkIfActive(acc)
 		case <-acc.stopChan:
-			log.Printf("[%s] Monitor stopped", acc.Email)
+			acc.logger().Info("Monitor stopped")
+			acc.mu.Lock()
+			acc.monitoring = false
+			acc.mu.Unlock()
+			publishEvent(eventMonitorPaused, acc.Email, nil)
 			return
 		}
 	}
 }
 
+func checkAccount(acc *AccountConfig) error {
+	acc.checkMu.Lock()
+	defer acc.checkMu.Unlock()
+
+	publishEvent(eventCheckStarted, acc.Email, nil)
+	start := time.Now()
+
+	var err error
+	switch {
+	case acc.Protocol == "pop3":
+		err = checkNewEmailsPOP3(acc)
+	case acc.Protocol == "jmap":
+		err = checkNewEmailsJMAP(acc)
+	case isLocalProtocol(acc.Protocol):
+		err = checkLocalSource(acc)
+	default:
+		err = checkNewEmails(acc)
+	}
+	metrics.observeCheck(acc.Email, time.Since(start), err)
+
+	acc.mu.Lock()
+	acc.lastError = ""
+	if err != nil {
+		acc.lastError = err.Error()
+	}
+	result := checkResult{UnreadCount: acc.unreadCount, Error: acc.lastError}
+	if !acc.lastCheckTime.IsZero() {
+		result.LastCheck = acc.lastCheckTime.Format("15:04:05")
+	}
+	acc.mu.Unlock()
+
+	if err != nil {
+		publishEvent(eventError, acc.Email, map[string]string{"message": err.Error()})
+	}
+	publishEvent(eventCheckFinished, acc.Email, result)
+	return err
+}
+
 func checkNewEmails(acc *AccountConfig) error {
 	c, err := connectToIMAP(acc)
 	if err != nil {
-		log.Printf("[%s] Connect error: %v", acc.Email, err)
+		acc.logger().Warn("Connect error", "error", err)
 		return err
 	}
 	defer c.Logout()
@@ -1726,7 +3294,7 @@ func checkNewEmails(acc *AccountConfig) error {
 	for _, folder := range folders {
 		mbox, err := c.Select(folder, false)
 		if err != nil {
-			log.Printf("[%s] Select %s error: %v", acc.Email, folder, err)
+			acc.logger().Warn("Select error", "folder", folder, "error", err)
 			continue
 		}
 
@@ -1746,12 +3314,15 @@ func checkNewEmails(acc *AccountConfig) error {
 		seqset := new(imap.SeqSet)
 		seqset.AddNum(ids...)
 
+		section, textSection, items := imapFetchItems(acc)
 		messages := make(chan *imap.Message, len(ids))
 		done := make(chan error, 1)
 		go func() {
-			done <- c.Fetch(seqset, []imap.FetchItem{imap.FetchEnvelope, imap.FetchUid}, messages)
+			done <- c.Fetch(seqset, items, messages)
 		}()
 
+		var matches []matchedMessage
+		var invites []imapCalendarPart
 		for msg := range messages {
 			if msg.Envelope != nil && msg.Uid > 0 {
 				emailID := generateEmailID(folder, msg.Uid, msg.Envelope.MessageId)
@@ -1759,9 +3330,43 @@ func checkNewEmails(acc *AccountConfig) error {
 				acc.mu.Lock()
 				alreadyNotified := acc.notifiedEmails[emailID]
 				acc.mu.Unlock()
+				if !alreadyNotified {
+					metrics.messageScanned(acc.Email)
+				}
 
-				if !alreadyNotified && applyFilters(acc, msg.Envelope) {
-					showNotification(acc, folder, msg.Envelope)
+				if !alreadyNotified {
+					header := parseHeaderSection(msg.GetBody(section))
+					text := ""
+					if textSection != nil {
+						text = messageTextFromHeader(header, msg.GetBody(textSection))
+					}
+					parts, calendarPath, calendarPart := imapMessageParts(msg.BodyStructure, msg.Size)
+					in := filterInput{
+						Senders: imapSenders(msg.Envelope),
+						Subject: msg.Envelope.Subject,
+						Header:  header.Values,
+						Body:    text,
+						Size:    int64(msg.Size),
+						Parts:   &parts,
+					}
+					ok, rule := filterMessage(acc, in)
+					if !ok {
+						continue
+					}
+					m := newIMAPMatch(folder, msg.Envelope)
+					m.Rule = rule
+					m.Auth = assessSender(in)
+					m.Parts = parts
+					if calendarPath != nil {
+						invites = append(invites, imapCalendarPart{index: len(matches), uid: msg.Uid, path: calendarPath, part: calendarPart})
+					}
+					m.Ref = strconv.FormatUint(uint64(msg.Uid), 10)
+					setThreadHeaders(&m, header.Get)
+					m.Priority = resolvePriority(acc, m, headerPriority(header.Get))
+					if acc.ShowSnippet || acc.DetectCodes {
+						setSnippetAndCode(acc, &m, cleanSnippet(text))
+					}
+					matches = append(matches, m)
 					acc.mu.Lock()
 					acc.notifiedEmails[emailID] = true
 					acc.mu.Unlock()
@@ -1770,6 +3375,9 @@ func checkNewEmails(acc *AccountConfig) error {
 			}
 		}
 		<-done
+
+		fetchIMAPInvites(c, matches, invites)
+		notifyMatches(acc, folder, matches)
 	}
 
 	acc.mu.Lock()
@@ -1784,10 +3392,40 @@ func checkNewEmails(acc *AccountConfig) error {
 	return nil
 }
 
+// imapFetchItems returns the header section, the text section (nil unless
+// snippets, codes or a Sieve body test need it) and the items to fetch for
+// them. The sections must not change afterwards: GetBody only finds a
+// section that is equal to the one that was fetched.
+func imapFetchItems(acc *AccountConfig) (*imap.BodySectionName, *imap.BodySectionName, []imap.FetchItem) {
+	section := &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{
+			Specifier: imap.HeaderSpecifier,
+			Fields:    notificationHeaderFields,
+		},
+		Peek: true,
+	}
+	// A Sieve script may test any header.
+	if acc.SieveScript != "" {
+		section.Fields = nil
+	}
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchUid, imap.FetchRFC822Size, imap.FetchBodyStructure, section.FetchItem()}
+
+	var textSection *imap.BodySectionName
+	if acc.ShowSnippet || acc.DetectCodes || sieveUsesBody(acc) {
+		textSection = &imap.BodySectionName{
+			BodyPartName: imap.BodyPartName{Specifier: imap.TextSpecifier},
+			Peek:         true,
+			Partial:      []int{0, snippetFetchBytes},
+		}
+		items = append(items, textSection.FetchItem())
+	}
+	return section, textSection, items
+}
+
 func checkNewEmailsPOP3(acc *AccountConfig) error {
 	password, err := getPassword(acc.Email)
 	if err != nil {
-		log.Printf("%s: Failed to get password: %v", acc.Email, err)
+		acc.logger().Error("Failed to get password", "error", err)
 		return err
 	}
 
@@ -1799,48 +3437,73 @@ func checkNewEmailsPOP3(acc *AccountConfig) error {
 
 	c, err := p.NewConn()
 	if err != nil {
-		log.Printf("%s: POP3 Connect error: %v", acc.Email, err)
+		acc.logger().Warn("POP3 connect error", "error", err)
 		return err
 	}
 	defer c.Quit()
 
 	if err := c.Auth(acc.Username, password); err != nil {
-		log.Printf("%s: POP3 Auth error: %v", acc.Email, err)
+		acc.logger().Warn("POP3 auth error", "error", err)
 		return err
 	}
 
 	msgCount, _, err := c.Stat()
 	if err != nil {
-		log.Printf("%s: POP3 Stat error: %v", acc.Email, err)
+		acc.logger().Warn("POP3 stat error", "error", err)
 		return err
 	}
 
+	// TOP only transfers the headers (and a few body lines when a snippet is
+	// wanted) instead of downloading every message in full.
+	topLines := 0
+	if acc.ShowSnippet || acc.DetectCodes || sieveUsesBody(acc) {
+		topLines = snippetPOP3Lines
+	}
+
 	newNotifications := false
+	var matches []matchedMessage
 	for i := 1; i <= msgCount; i++ {
-		msg, err := c.Retr(i)
+		msg, err := c.Top(i, topLines)
 		if err != nil {
 			continue
 		}
 
-		// Read the body from io.Reader
-		bodyBytes, err := io.ReadAll(msg.Body)
-		if err != nil {
-			continue
-		}
-		bodyStr := string(bodyBytes)
-
-		emailID := fmt.Sprintf("pop3-%d-%s", i, extractMessageID(bodyStr))
+		emailID := fmt.Sprintf("pop3-%d-%s", i, extractMessageID(msg.Header.Get))
 
 		acc.mu.Lock()
 		alreadyNotified := acc.notifiedEmails[emailID]
 		acc.mu.Unlock()
 
 		if !alreadyNotified {
-			from := msg.Header.Get("From")
-			subject := msg.Header.Get("Subject")
-
-			if applyFiltersPOP3(acc, from, subject) {
-				showNotificationPOP3(acc, from, subject)
+			metrics.messageScanned(acc.Email)
+			text := ""
+			if topLines > 0 {
+				text = messageText(msg)
+			}
+			in := entityFilterInput(msg.Header, text)
+			if list, err := c.List(i); err == nil && len(list) > 0 {
+				in.Size = int64(list[0].Size)
+			}
+			// The MIME structure costs a download, so it is read before
+			// filtering only when the Sieve script tests it.
+			if sieveUsesParts(acc) {
+				parts := pop3MessageParts(c, i, msg.Header, in.Size)
+				in.Parts = &parts
+			}
+			if ok, rule := filterMessage(acc, in); ok {
+				if in.Parts == nil {
+					parts := pop3MessageParts(c, i, msg.Header, in.Size)
+					in.Parts = &parts
+				}
+				m := newPOP3Match(msg.Header.Get)
+				m.Rule = rule
+				m.Auth = assessSender(in)
+				m.Parts = *in.Parts
+				m.Priority = resolvePriority(acc, m, headerPriority(msg.Header.Get))
+				if acc.ShowSnippet || acc.DetectCodes {
+					setSnippetAndCode(acc, &m, cleanSnippet(text))
+				}
+				matches = append(matches, m)
 				acc.mu.Lock()
 				acc.notifiedEmails[emailID] = true
 				acc.mu.Unlock()
@@ -1849,6 +3512,8 @@ func checkNewEmailsPOP3(acc *AccountConfig) error {
 		}
 	}
 
+	notifyMatches(acc, "INBOX", matches)
+
 	acc.mu.Lock()
 	acc.lastCheckTime = time.Now()
 	acc.unreadCount = msgCount
@@ -1906,25 +3571,7 @@ func connectToIMAP(acc *AccountConfig) (*client.Client, error) {
 }
 
 func getFoldersToCheck(acc *AccountConfig, c *client.Client) []string {
-	switch acc.FolderMode {
-	case "include":
-		return acc.IncludeFolders
-	case "exclude":
-		allFolders := listFolders(c)
-		excludeMap := make(map[string]bool)
-		for _, f := range acc.ExcludeFolders {
-			excludeMap[f] = true
-		}
-		var result []string
-		for _, f := range allFolders {
-			if !excludeMap[f] {
-				result = append(result, f)
-			}
-		}
-		return result
-	default:
-		return listFolders(c)
-	}
+	return selectFolders(acc, func() []string { return listFolders(c) })
 }
 
 func listFolders(c *client.Client) []string {
@@ -1943,93 +3590,79 @@ func listFolders(c *client.Client) []string {
 	return folders
 }
 
-func applyFilters(acc *AccountConfig, env *imap.Envelope) bool {
-	var senderEmail string
-	if len(env.From) > 0 && env.From[0].MailboxName != "" && env.From[0].HostName != "" {
-		senderEmail = env.From[0].MailboxName + "@" + env.From[0].HostName
-	}
-
-	subject := strings.ToLower(env.Subject)
-
-	for _, excludeEmail := range acc.ExcludeEmail {
-		if strings.EqualFold(senderEmail, excludeEmail) {
-			return false
-		}
-	}
+// filterInput is what the filters look at. Header, Body, Size and Parts are
+// only used by Sieve scripts; Body is empty unless the script tests it or a
+// snippet was fetched anyway, and Parts is nil when the MIME structure was
+// not read.
+type filterInput struct {
+	Senders []senderAddress
+	Subject string
+	Header  func(string) []string
+	Body    string
+	Size    int64
+	Parts   *messageParts
+}
 
-	for _, keyword := range acc.ExcludeKeyword {
-		if strings.Contains(subject, strings.ToLower(keyword)) {
-			return false
-		}
+// entityFilterInput builds the filter input of a parsed message (POP3,
+// Maildir and mbox).
+func entityFilterInput(h message.Header, text string) filterInput {
+	header := entityHeader(h)
+	return filterInput{
+		Senders: headerSenders(header),
+		Subject: header("Subject"),
+		Header:  h.Values,
+		Body:    text,
 	}
+}
 
-	hasIncludeFilters := len(acc.IncludeEmail) > 0 || len(acc.IncludeKeyword) > 0
-
-	if hasIncludeFilters {
-		if len(acc.IncludeEmail) > 0 {
-			for _, includeEmail := range acc.IncludeEmail {
-				if strings.EqualFold(senderEmail, includeEmail) {
-					return true
-				}
-			}
-		}
-
-		if len(acc.IncludeKeyword) > 0 {
-			for _, keyword := range acc.IncludeKeyword {
-				if strings.Contains(subject, strings.ToLower(keyword)) {
-					return true
-				}
-			}
+// filterMessage reports whether a message passes the account's filters and
+// which condition let it through or excluded it. Sender patterns are checked
+// against every From, Sender and Reply-To address. An account with a Sieve
+// script is filtered by the script alone.
+func filterMessage(acc *AccountConfig, in filterInput) (bool, string) {
+	if acc.SieveScript != "" {
+		script, err := accountSieve(acc)
+		if err != nil {
+			// loadConfig and the account handlers reject invalid scripts.
+			return true, "sieve error: " + err.Error()
 		}
-
-		return false
+		return script.evaluate(in)
 	}
 
-	return true
-}
-
-func applyFiltersPOP3(acc *AccountConfig, from, subject string) bool {
-	senderEmail := extractEmailAddress(from)
-	subjectLower := strings.ToLower(subject)
+	subjectLower := strings.ToLower(in.Subject)
+	senders := in.Senders
+	filters := effectiveFilters(acc)
 
-	for _, excludeEmail := range acc.ExcludeEmail {
-		if strings.EqualFold(senderEmail, excludeEmail) {
-			return false
-		}
+	if pattern, ok := findSenderPattern(filters.ExcludeEmail, senders, acc.NormalizePlusTags); ok {
+		return false, "excluded sender: " + pattern
 	}
 
-	for _, keyword := range acc.ExcludeKeyword {
+	for _, keyword := range filters.ExcludeKeyword {
 		if strings.Contains(subjectLower, strings.ToLower(keyword)) {
-			return false
+			return false, "excluded keyword: " + keyword
 		}
 	}
 
-	hasIncludeFilters := len(acc.IncludeEmail) > 0 || len(acc.IncludeKeyword) > 0
+	hasIncludeFilters := len(filters.IncludeEmail) > 0 || len(filters.IncludeKeyword) > 0
 
 	if hasIncludeFilters {
-		if len(acc.IncludeEmail) > 0 {
-			for _, includeEmail := range acc.IncludeEmail {
-				if strings.EqualFold(senderEmail, includeEmail) {
-					return true
-				}
-			}
+		if pattern, ok := findSenderPattern(filters.IncludeEmail, senders, acc.NormalizePlusTags); ok {
+			return true, "sender: " + pattern
 		}
 
-		if len(acc.IncludeKeyword) > 0 {
-			for _, keyword := range acc.IncludeKeyword {
-				if strings.Contains(subjectLower, strings.ToLower(keyword)) {
-					return true
-				}
+		for _, keyword := range filters.IncludeKeyword {
+			if strings.Contains(subjectLower, strings.ToLower(keyword)) {
+				return true, "keyword: " + keyword
 			}
 		}
 
-		return false
+		return false, "no include filter matched"
 	}
 
-	return true
+	return true, "all messages"
 }
 
-func showNotification(acc *AccountConfig, folder string, env *imap.Envelope) {
+func newIMAPMatch(folder string, env *imap.Envelope) matchedMessage {
 	var sender string
 	if len(env.From) > 0 {
 		if env.From[0].MailboxName != "" && env.From[0].HostName != "" {
@@ -2043,77 +3676,139 @@ func showNotification(acc *AccountConfig, folder string, env *imap.Envelope) {
 		sender = "Unknown"
 	}
 
-	subject := env.Subject
-	if subject == "" {
-		subject = "(No Subject)"
-	}
-
-	displaySubject := subject
-	if len(displaySubject) > 50 {
-		displaySubject = displaySubject[:47] + "..."
+	m := matchedMessage{
+		Folder:    folder,
+		Sender:    sender,
+		To:        imapAddressList(env.To),
+		Cc:        imapAddressList(env.Cc),
+		Subject:   env.Subject,
+		Date:      env.Date,
+		MessageID: env.MessageId,
+		Senders:   imapSenders(env),
 	}
-
-	title := fmt.Sprintf("📧 %s [%s]", acc.Email, folder)
-	message := fmt.Sprintf("From: %s\nSubject: %s", sender, displaySubject)
-
-	var err error
-	if acc.EnableNotificationSound {
-		err = beeep.Notify(title, message, "")
-	} else {
-		err = beeep.Alert(title, message, "")
+	if len(env.From) > 0 {
+		m.FromName = env.From[0].PersonalName
+		m.FromAddress = env.From[0].Address()
 	}
+	return m
+}
 
-	if err != nil {
-		log.Printf("[%s] Notification error: %v", acc.Email, err)
+func imapAddressList(addrs []*imap.Address) []string {
+	var result []string
+	for _, a := range addrs {
+		if addr := a.Address(); addr != "" {
+			result = append(result, addr)
+		}
 	}
-
-	log.Printf("[%s][%s] NEW EMAIL - From: %s | Subject: %s", acc.Email, folder, sender, subject)
+	return result
 }
 
-func showNotificationPOP3(acc *AccountConfig, from, subject string) {
+func newPOP3Match(header func(string) string) matchedMessage {
+	from := header("From")
 	sender := extractEmailAddress(from)
 	if sender == "" {
 		sender = from
 	}
 
+	m := matchedMessage{
+		Folder:      "INBOX",
+		Sender:      sender,
+		FromAddress: sender,
+		MessageID:   strings.TrimSpace(header("Message-ID")),
+		To:          parseAddressList(header("To")),
+		Cc:          parseAddressList(header("Cc")),
+		Subject:     header("Subject"),
+		Date:        time.Now(),
+	}
+	if addr, err := mail.ParseAddress(from); err == nil {
+		m.FromName = addr.Name
+		m.FromAddress = addr.Address
+	}
+	if date, err := mail.ParseDate(header("Date")); err == nil {
+		m.Date = date
+	}
+	setThreadHeaders(&m, header)
+	m.Senders = headerSenders(header)
+	return m
+}
+
+func parseAddressList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	addrs, err := mail.ParseAddressList(s)
+	if err != nil {
+		return []string{s}
+	}
+	result := make([]string, len(addrs))
+	for i, a := range addrs {
+		result[i] = a.Address
+	}
+	return result
+}
+
+func showNotification(acc *AccountConfig, m matchedMessage) {
+	subject := m.Subject
 	if subject == "" {
 		subject = "(No Subject)"
 	}
 
-	displaySubject := subject
-	if len(displaySubject) > 50 {
-		displaySubject = displaySubject[:47] + "..."
+	if m.Code != "" {
+		// The subject may contain the code, which must not reach the log.
+		acc.logger().Info("New email", "folder", m.Folder, "from", m.Sender)
+	} else {
+		acc.logger().Info("New email", "folder", m.Folder, "from", m.Sender, "subject", subject)
 	}
 
-	title := fmt.Sprintf("📧 %s [POP3]", acc.Email)
-	message := fmt.Sprintf("From: %s\nSubject: %s", sender, displaySubject)
+	if holdNotification(acc, m.Folder, m.Sender, messageSenders(m), subject, m.Priority) {
+		if quietHoursAction(acc) == "drop" {
+			recordNotification(acc, m, "dropped")
+		} else {
+			recordNotification(acc, m, "held")
+		}
+		return
+	}
+
+	title, message := renderNotification(acc, m)
+	var actions []notificationAction
+	if m.Code != "" {
+		code := m.Code
+		actions = append(actions, notificationAction{Label: "Copy code", Run: func() { copyCode(code) }})
+	}
+	actions = append(actions, muteNotificationActions(acc, m)...)
+	sendDesktopNotification(acc, title, message, m.Priority, actions...)
+	recordNotification(acc, m, "notified")
+}
+
+func sendDesktopNotification(acc *AccountConfig, title, message, priority string, actions ...notificationAction) {
+	level, custom := priorityLevel(priority)
 
 	var err error
-	if acc.EnableNotificationSound {
-		err = beeep.Notify(title, message, "")
+	if !custom && len(actions) == 0 {
+		if acc.EnableNotificationSound {
+			err = beeep.Notify(title, message, "")
+		} else {
+			err = beeep.Alert(title, message, "")
+		}
 	} else {
-		err = beeep.Alert(title, message, "")
+		if level.Sound != "" && (acc.EnableNotificationSound || priority == "critical") {
+			go playSound(level.Sound)
+		}
+		err = desktopNotify(title, message, level, actions...)
 	}
 
 	if err != nil {
-		log.Printf("[%s] Notification error: %v", acc.Email, err)
+		acc.logger().Warn("Notification error", "error", err)
 	}
+	metrics.notificationSent(acc.Email, "desktop", err)
 
-	log.Printf("[%s][POP3] NEW EMAIL - From: %s | Subject: %s", acc.Email, sender, subject)
-}
-
-func extractHeader(msg, header string) string {
-	lines := strings.Split(msg, "\r\n")
-	for _, line := range lines {
-		if strings.HasPrefix(strings.ToLower(line), strings.ToLower(header)+":") {
-			return strings.TrimSpace(strings.TrimPrefix(line, header+":"))
-		}
+	if interval := level.repeatInterval(); interval > 0 {
+		trackAlert(acc, title, message, priority, interval)
 	}
-	return ""
 }
 
-func extractMessageID(msg string) string {
-	msgID := extractHeader(msg, "Message-ID")
+func extractMessageID(header func(string) string) string {
+	msgID := strings.TrimSpace(header("Message-ID"))
 	if msgID != "" {
 		return msgID
 	}
@@ -2174,7 +3869,7 @@ func saveNotifiedEmails(acc *AccountConfig) error {
 
 func cleanupOldNotifications(acc *AccountConfig) {
 	if len(acc.notifiedEmails) > acc.CheckHistory {
-		log.Printf("[%s] Cleanup history (current: %d, max: %d)", acc.Email, len(acc.notifiedEmails), acc.CheckHistory)
+		acc.logger().Debug("Cleanup history", "current", len(acc.notifiedEmails), "max", acc.CheckHistory)
 		count := 0
 		for k := range acc.notifiedEmails {
 			if count > acc.CheckHistory/2 {
@@ -2194,17 +3889,17 @@ func sanitizeFilename(s string) string {
 func checkAllAccounts() {
 	var wg sync.WaitGroup
 	for i := range config.Accounts {
+		if config.Accounts[i].isPaused() {
+			continue
+		}
 		wg.Add(1)
 		go func(acc *AccountConfig) {
 			defer wg.Done()
-			if acc.Protocol == "pop3" {
-				checkNewEmailsPOP3(acc)
-			} else {
-				checkNewEmails(acc)
-			}
+			checkAccount(acc)
 		}(&config.Accounts[i])
 	}
 	wg.Wait()
+	publishEvent(eventCheckAllFinished, "", nil)
 	beeep.Notify("Email Monitor", "Manual check completed", "")
 }
 
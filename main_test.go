@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+const testFetchedHeader = "From: Support <support@example.com>\r\n" +
+	"Subject: Your account\r\n" +
+	"List-Id: Announcements <announce.example.com>\r\n" +
+	"Authentication-Results: mx.example.net; dmarc=fail header.from=example.com\r\n" +
+	"X-Mailer: test\r\n" +
+	"\r\n"
+
+// fetchedMessage answers a FETCH the way a server does: every body section
+// comes back under the name it was requested with, without .PEEK and with
+// only the origin of a partial fetch.
+func fetchedMessage(t *testing.T, items []imap.FetchItem, header, text string) *imap.Message {
+	t.Helper()
+	msg := imap.NewMessage(1, items)
+	for _, item := range items {
+		name, err := imap.ParseBodySectionName(item)
+		if err != nil {
+			continue
+		}
+		name.Peek = false
+		if len(name.Partial) > 1 {
+			name.Partial = name.Partial[:1]
+		}
+		switch name.Specifier {
+		case imap.HeaderSpecifier:
+			msg.Body[name] = bytes.NewBufferString(header)
+		case imap.TextSpecifier:
+			msg.Body[name] = bytes.NewBufferString(text)
+		}
+	}
+	return msg
+}
+
+func TestIMAPFetchSieveHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		acc  *AccountConfig
+	}{
+		{"filters", &AccountConfig{IncludeEmail: []string{"@example.com"}}},
+		{"sieve", &AccountConfig{SieveScript: `if header :contains "list-id" "announce" { keep; } else { discard; }`}},
+		{"sieve with body", &AccountConfig{SieveScript: `require "body"; if body :contains "reset" { keep; } else { discard; }`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section, textSection, items := imapFetchItems(tt.acc)
+			msg := fetchedMessage(t, items, testFetchedHeader, "Please reset your password.\r\n")
+
+			header := parseHeaderSection(msg.GetBody(section))
+			if header.Get("List-Id") == "" || header.Get("Authentication-Results") == "" {
+				t.Fatalf("fetched header section not found, got %v", header)
+			}
+			in := filterInput{
+				Senders: []senderAddress{{Address: "support@example.com", Name: "Support"}},
+				Subject: "Your account",
+				Header:  header.Values,
+			}
+			if textSection != nil {
+				in.Body = messageTextFromHeader(header, msg.GetBody(textSection))
+			}
+
+			if ok, rule := filterMessage(tt.acc, in); !ok {
+				t.Errorf("filterMessage = false (%s), want true", rule)
+			}
+			if v := assessSender(in); v.Status != "suspicious" {
+				t.Errorf("assessSender status = %q, want suspicious", v.Status)
+			}
+		})
+	}
+}
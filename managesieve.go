@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const manageSievePort = 4190
+
+// manageSieveConn is a minimal ManageSieve (RFC 5804) client, enough to log
+// in and download the active script.
+type manageSieveConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// manageSieveResponse holds the data lines that came before the OK.
+type manageSieveResponse struct {
+	lines []string
+}
+
+func (c *manageSieveConn) command(cmd string) (*manageSieveResponse, error) {
+	c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := io.WriteString(c.conn, cmd+"\r\n"); err != nil {
+		return nil, err
+	}
+	return c.readResponse()
+}
+
+func (c *manageSieveConn) readResponse() (*manageSieveResponse, error) {
+	resp := &manageSieveResponse{}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		word := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		if word == "OK" || word == "NO" || word == "BYE" {
+			if word != "OK" {
+				return resp, fmt.Errorf("server replied %s", line)
+			}
+			return resp, nil
+		}
+		resp.lines = append(resp.lines, line)
+	}
+}
+
+// readLine reads one line, following a trailing {n} or {n+} literal.
+func (c *manageSieveConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	if strings.HasSuffix(line, "}") {
+		if open := strings.LastIndex(line, "{"); open >= 0 {
+			n, err := strconv.Atoi(strings.TrimSuffix(line[open+1:len(line)-1], "+"))
+			if err == nil && n >= 0 {
+				literal := make([]byte, n)
+				if _, err := io.ReadFull(c.r, literal); err != nil {
+					return "", err
+				}
+				rest, err := c.r.ReadString('\n')
+				if err != nil {
+					return "", err
+				}
+				return line[:open] + quoteManageSieve(string(literal)) + strings.TrimRight(rest, "\r\n"), nil
+			}
+		}
+	}
+	return line, nil
+}
+
+var manageSieveQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// quoteManageSieve turns a literal back into a quoted string so that
+// manageSieveStrings can read both forms.
+func quoteManageSieve(s string) string {
+	return `"` + manageSieveQuoter.Replace(s) + `"`
+}
+
+// manageSieveStrings returns the quoted strings of a response line.
+func manageSieveStrings(line string) []string {
+	var values []string
+	for i := 0; i < len(line); i++ {
+		if line[i] != '"' {
+			continue
+		}
+		var b strings.Builder
+		j := i + 1
+		for ; j < len(line) && line[j] != '"'; j++ {
+			if line[j] == '\\' && j+1 < len(line) {
+				j++
+			}
+			b.WriteByte(line[j])
+		}
+		values = append(values, b.String())
+		i = j
+	}
+	return values
+}
+
+// fetchManageSieveScript logs in to the ManageSieve server, upgrading to TLS
+// with STARTTLS, and returns the name and content of the active script.
+// server defaults to the account's server on port 4190.
+func fetchManageSieveScript(acc *AccountConfig, server string) (string, string, error) {
+	password, err := getPassword(acc.Email)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get password from keyring: %v", err)
+	}
+
+	if server == "" {
+		server = net.JoinHostPort(acc.Server, strconv.Itoa(manageSievePort))
+	} else if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, strconv.Itoa(manageSievePort))
+	}
+	host, _, _ := net.SplitHostPort(server)
+
+	conn, err := net.DialTimeout("tcp", server, 30*time.Second)
+	if err != nil {
+		return "", "", fmt.Errorf("Connection failed: %v", err)
+	}
+	c := &manageSieveConn{conn: conn, r: bufio.NewReader(conn)}
+	defer func() { c.conn.Close() }()
+
+	c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	greeting, err := c.readResponse()
+	if err != nil {
+		return "", "", fmt.Errorf("Connection failed: %v", err)
+	}
+	if !manageSieveHasCapability(greeting, "STARTTLS") {
+		return "", "", fmt.Errorf("server does not offer STARTTLS")
+	}
+	if _, err := c.command("STARTTLS"); err != nil {
+		return "", "", fmt.Errorf("STARTTLS failed: %v", err)
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+	if err := tlsConn.Handshake(); err != nil {
+		return "", "", fmt.Errorf("STARTTLS failed: %v", err)
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+	// The server repeats its capabilities after the TLS handshake.
+	if _, err := c.readResponse(); err != nil {
+		return "", "", fmt.Errorf("STARTTLS failed: %v", err)
+	}
+
+	auth := base64.StdEncoding.EncodeToString([]byte("\x00" + acc.Username + "\x00" + password))
+	if _, err := c.command(`AUTHENTICATE "PLAIN" "` + auth + `"`); err != nil {
+		return "", "", fmt.Errorf("Authentication failed: %v", err)
+	}
+	defer c.command("LOGOUT")
+
+	list, err := c.command("LISTSCRIPTS")
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to list scripts: %v", err)
+	}
+	name := ""
+	for _, line := range list.lines {
+		values := manageSieveStrings(line)
+		if len(values) > 0 && strings.HasSuffix(strings.ToUpper(strings.TrimSpace(line)), " ACTIVE") {
+			name = values[0]
+			break
+		}
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("no active script on the server")
+	}
+
+	script, err := c.command("GETSCRIPT " + quoteManageSieve(name))
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to download script %s: %v", name, err)
+	}
+	if len(script.lines) == 0 {
+		return name, "", nil
+	}
+	values := manageSieveStrings(script.lines[0])
+	if len(values) == 0 {
+		return "", "", fmt.Errorf("unexpected GETSCRIPT response")
+	}
+	return name, values[0], nil
+}
+
+func manageSieveHasCapability(resp *manageSieveResponse, capability string) bool {
+	for _, line := range resp.lines {
+		if values := manageSieveStrings(line); len(values) > 0 && strings.EqualFold(values[0], capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAccountSieve downloads the account's active Sieve script over
+// ManageSieve. The script is returned for the editor, not saved.
+func handleAccountSieve(w http.ResponseWriter, r *http.Request) {
+	acc := accountFromRequest(w, r)
+	if acc == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if acc.Protocol != "" && acc.Protocol != "imap" && acc.Protocol != "pop3" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": "ManageSieve is only available for IMAP and POP3 accounts",
+		})
+		return
+	}
+
+	name, script, err := fetchManageSieveScript(acc, r.URL.Query().Get("server"))
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"name":    name,
+		"script":  script,
+		"message": fmt.Sprintf("Fetched active script %s", name),
+	})
+}
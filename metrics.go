@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var checkDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// metricsRegistry holds the counters for /metrics. Gauges such as the unread
+// count are read from the accounts at scrape time instead.
+type metricsRegistry struct {
+	mu            sync.Mutex
+	checks        map[[2]string]uint64 // account, result
+	durations     map[string]*histogram
+	errors        map[[2]string]uint64 // account, type
+	scanned       map[string]uint64
+	notifications map[[3]string]uint64 // account, sink, outcome
+	lastSuccess   map[string]time.Time
+}
+
+var metrics = &metricsRegistry{
+	checks:        make(map[[2]string]uint64),
+	durations:     make(map[string]*histogram),
+	errors:        make(map[[2]string]uint64),
+	scanned:       make(map[string]uint64),
+	notifications: make(map[[3]string]uint64),
+	lastSuccess:   make(map[string]time.Time),
+}
+
+func (m *metricsRegistry) observeCheck(account string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := m.durations[account]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(checkDurationBuckets))}
+		m.durations[account] = h
+	}
+	seconds := d.Seconds()
+	for i, le := range checkDurationBuckets {
+		if seconds <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+
+	if err != nil {
+		m.checks[[2]string{account, "error"}]++
+		m.errors[[2]string{account, classifyError(err)}]++
+		return
+	}
+	m.checks[[2]string{account, "success"}]++
+	m.lastSuccess[account] = time.Now()
+}
+
+func (m *metricsRegistry) messageScanned(account string) {
+	m.mu.Lock()
+	m.scanned[account]++
+	m.mu.Unlock()
+}
+
+func (m *metricsRegistry) notificationSent(account, sink string, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	m.mu.Lock()
+	m.notifications[[3]string{account, sink, outcome}]++
+	m.mu.Unlock()
+}
+
+// classifyError maps a check error onto a small, fixed set of label values.
+// Most errors are wrapped with %v, so the message is inspected as a fallback.
+func classifyError(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "timeout"):
+		return "timeout"
+	case strings.Contains(msg, "no such host"):
+		return "dns"
+	case strings.Contains(msg, "x509") || strings.Contains(msg, "tls:") || strings.Contains(msg, "certificate"):
+		return "tls"
+	case strings.Contains(msg, "keyring"):
+		return "keyring"
+	case strings.Contains(msg, "auth") || strings.Contains(msg, "login") || strings.Contains(msg, "password") ||
+		strings.Contains(msg, "credentials") || strings.Contains(msg, "401"):
+		return "auth"
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || strings.Contains(msg, "connection refused") || strings.Contains(msg, "dial") {
+		return "connection"
+	}
+	return "protocol"
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys[K comparable](m map[K]uint64, less func(a, b K) bool) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+	return keys
+}
+
+func lessLabels(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func (m *metricsRegistry) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(w, "email_monitor_checks_total", "counter", "Mailbox checks by result.")
+	for _, k := range sortedKeys(m.checks, func(a, b [2]string) bool { return lessLabels(a[:], b[:]) }) {
+		fmt.Fprintf(w, "email_monitor_checks_total{account=\"%s\",result=\"%s\"} %d\n", escapeLabel(k[0]), k[1], m.checks[k])
+	}
+
+	writeMetricHeader(w, "email_monitor_check_duration_seconds", "histogram", "Time taken by mailbox checks.")
+	accounts := make([]string, 0, len(m.durations))
+	for a := range m.durations {
+		accounts = append(accounts, a)
+	}
+	sort.Strings(accounts)
+	for _, a := range accounts {
+		h := m.durations[a]
+		var cumulative uint64
+		for i, le := range checkDurationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "email_monitor_check_duration_seconds_bucket{account=\"%s\",le=\"%s\"} %d\n", escapeLabel(a), formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(w, "email_monitor_check_duration_seconds_bucket{account=\"%s\",le=\"+Inf\"} %d\n", escapeLabel(a), h.count)
+		fmt.Fprintf(w, "email_monitor_check_duration_seconds_sum{account=\"%s\"} %s\n", escapeLabel(a), formatFloat(h.sum))
+		fmt.Fprintf(w, "email_monitor_check_duration_seconds_count{account=\"%s\"} %d\n", escapeLabel(a), h.count)
+	}
+
+	writeMetricHeader(w, "email_monitor_check_errors_total", "counter", "Failed checks by error type.")
+	for _, k := range sortedKeys(m.errors, func(a, b [2]string) bool { return lessLabels(a[:], b[:]) }) {
+		fmt.Fprintf(w, "email_monitor_check_errors_total{account=\"%s\",type=\"%s\"} %d\n", escapeLabel(k[0]), k[1], m.errors[k])
+	}
+
+	writeMetricHeader(w, "email_monitor_messages_scanned_total", "counter", "New messages evaluated against the filters.")
+	for _, k := range sortedKeys(m.scanned, func(a, b string) bool { return a < b }) {
+		fmt.Fprintf(w, "email_monitor_messages_scanned_total{account=\"%s\"} %d\n", escapeLabel(k), m.scanned[k])
+	}
+
+	writeMetricHeader(w, "email_monitor_notifications_total", "counter", "Notifications sent by sink and outcome.")
+	for _, k := range sortedKeys(m.notifications, func(a, b [3]string) bool { return lessLabels(a[:], b[:]) }) {
+		fmt.Fprintf(w, "email_monitor_notifications_total{account=\"%s\",sink=\"%s\",outcome=\"%s\"} %d\n", escapeLabel(k[0]), k[1], k[2], m.notifications[k])
+	}
+
+	writeMetricHeader(w, "email_monitor_unread_messages", "gauge", "Unread messages at the last check.")
+	for i := range config.Accounts {
+		acc := &config.Accounts[i]
+		acc.mu.RLock()
+		fmt.Fprintf(w, "email_monitor_unread_messages{account=\"%s\"} %d\n", escapeLabel(acc.Email), acc.unreadCount)
+		acc.mu.RUnlock()
+	}
+
+	writeMetricHeader(w, "email_monitor_last_success_timestamp_seconds", "gauge", "Unix time of the last successful check.")
+	for i := range config.Accounts {
+		email := config.Accounts[i].Email
+		if t, ok := m.lastSuccess[email]; ok {
+			fmt.Fprintf(w, "email_monitor_last_success_timestamp_seconds{account=\"%s\"} %d\n", escapeLabel(email), t.Unix())
+		}
+	}
+
+	writeMetricHeader(w, "email_monitor_history_size", "gauge", "Message IDs kept in the notification history.")
+	for i := range config.Accounts {
+		acc := &config.Accounts[i]
+		acc.mu.RLock()
+		fmt.Fprintf(w, "email_monitor_history_size{account=\"%s\"} %d\n", escapeLabel(acc.Email), len(acc.notifiedEmails))
+		acc.mu.RUnlock()
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w)
+}
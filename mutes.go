@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MuteRule suppresses notifications for a sender, a domain, a mailing list
+// or a thread, optionally until a given time.
+type MuteRule struct {
+	ID      string     `json:"id"`
+	Type    string     `json:"type"` // "sender", "domain", "list" or "thread"
+	Value   string     `json:"value"`
+	Account string     `json:"account,omitempty"` // empty applies to all accounts
+	Until   *time.Time `json:"until,omitempty"`
+	Created time.Time  `json:"created"`
+	Note    string     `json:"note,omitempty"` // e.g. the subject of a muted thread
+}
+
+var (
+	mutesMu sync.RWMutex
+
+	messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+)
+
+func (r MuteRule) active(now time.Time) bool {
+	return r.Until == nil || now.Before(*r.Until)
+}
+
+func (r MuteRule) matches(account string, m matchedMessage) bool {
+	if r.Account != "" && !strings.EqualFold(r.Account, account) {
+		return false
+	}
+	switch r.Type {
+	case "sender":
+		return strings.EqualFold(m.FromAddress, r.Value)
+	case "domain":
+		domain, value := senderDomain(m.FromAddress), strings.ToLower(r.Value)
+		return domain == value || strings.HasSuffix(domain, "."+value)
+	case "list":
+		return m.ListID != "" && strings.EqualFold(m.ListID, r.Value)
+	case "thread":
+		if m.MessageID == r.Value || m.InReplyTo == r.Value {
+			return true
+		}
+		for _, id := range m.References {
+			if id == r.Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func validateMuteRules(rules []MuteRule) error {
+	for _, r := range rules {
+		switch r.Type {
+		case "sender", "domain", "list", "thread":
+		default:
+			return fmt.Errorf("mute %s: invalid type %q (want sender, domain, list or thread)", r.ID, r.Type)
+		}
+		if strings.TrimSpace(r.Value) == "" {
+			return fmt.Errorf("mute %s: value is required", r.ID)
+		}
+	}
+	return nil
+}
+
+// normalizeMuteValue lowercases the values that are compared without case,
+// so equal mutes are recognised as duplicates.
+func normalizeMuteValue(r *MuteRule) {
+	if r.Type == "sender" || r.Type == "domain" || r.Type == "list" {
+		r.Value = strings.ToLower(strings.TrimSpace(r.Value))
+	}
+}
+
+// assignMuteIDs normalizes mutes written by hand in the config and gives
+// them IDs, so they can be removed from the dashboard. It reports whether the
+// config changed.
+func assignMuteIDs() bool {
+	mutesMu.Lock()
+	defer mutesMu.Unlock()
+
+	changed := false
+	for i := range config.Mutes {
+		r := &config.Mutes[i]
+		value := r.Value
+		normalizeMuteValue(r)
+		if r.Value != value {
+			changed = true
+		}
+		if r.ID == "" {
+			r.ID = newID()
+			r.Created = time.Now()
+			changed = true
+		}
+	}
+	return changed
+}
+
+func senderDomain(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return strings.ToLower(address[i+1:])
+	}
+	return ""
+}
+
+// setThreadHeaders fills in the List-Id and thread headers used by mutes.
+func setThreadHeaders(m *matchedMessage, header func(string) string) {
+	m.ListID = normalizeListID(header("List-Id"))
+	if ids := messageIDPattern.FindAllString(header("In-Reply-To"), 1); len(ids) > 0 {
+		m.InReplyTo = ids[0]
+	}
+	m.References = messageIDPattern.FindAllString(header("References"), -1)
+}
+
+// normalizeListID returns the identifier part of a List-Id header, e.g.
+// "golang-nuts.googlegroups.com" for "Go Nuts <golang-nuts.googlegroups.com>".
+func normalizeListID(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, "<"); i >= 0 {
+		if j := strings.Index(s[i:], ">"); j > 0 {
+			s = s[i+1 : i+j]
+		}
+	}
+	return strings.ToLower(s)
+}
+
+// threadRoot identifies the thread a message belongs to: the first
+// References entry, else In-Reply-To, else the message's own Message-ID.
+// Later replies carry the root in References, so muting it covers the thread.
+func threadRoot(m matchedMessage) string {
+	if len(m.References) > 0 {
+		return m.References[0]
+	}
+	if m.InReplyTo != "" {
+		return m.InReplyTo
+	}
+	return strings.TrimSpace(m.MessageID)
+}
+
+// findMute returns the first active mute matching the message.
+func findMute(acc *AccountConfig, m matchedMessage) (MuteRule, bool) {
+	mutesMu.RLock()
+	defer mutesMu.RUnlock()
+
+	now := time.Now()
+	for _, r := range config.Mutes {
+		if r.active(now) && r.matches(acc.Email, m) {
+			return r, true
+		}
+	}
+	return MuteRule{}, false
+}
+
+// dropMuted records muted messages and returns the others.
+func dropMuted(acc *AccountConfig, matches []matchedMessage) []matchedMessage {
+	var result []matchedMessage
+	for _, m := range matches {
+		if r, ok := findMute(acc, m); ok {
+			acc.logger().Debug("Muted", "folder", m.Folder, "from", m.Sender, "mute", r.Type+" "+r.Value)
+			recordNotification(acc, m, "muted")
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+func currentMutes() []MuteRule {
+	mutesMu.RLock()
+	defer mutesMu.RUnlock()
+	return append([]MuteRule(nil), config.Mutes...)
+}
+
+// pruneMutes removes expired mutes and reports whether any were removed.
+func pruneMutes() bool {
+	mutesMu.Lock()
+	defer mutesMu.Unlock()
+
+	now := time.Now()
+	kept := config.Mutes[:0]
+	for _, r := range config.Mutes {
+		if r.active(now) {
+			kept = append(kept, r)
+		}
+	}
+	pruned := len(kept) != len(config.Mutes)
+	config.Mutes = kept
+	return pruned
+}
+
+// addMute saves a new mute. Muting the same thing again replaces the expiry
+// of the existing mute instead of adding a duplicate.
+func addMute(r MuteRule) (MuteRule, error) {
+	normalizeMuteValue(&r)
+	if err := validateMuteRules([]MuteRule{r}); err != nil {
+		return r, err
+	}
+	pruneMutes()
+
+	mutesMu.Lock()
+	found := false
+	for i, existing := range config.Mutes {
+		if existing.Type == r.Type && existing.Value == r.Value && strings.EqualFold(existing.Account, r.Account) {
+			config.Mutes[i].Until = r.Until
+			r = config.Mutes[i]
+			found = true
+			break
+		}
+	}
+	if !found {
+		r.ID = newID()
+		r.Created = time.Now()
+		config.Mutes = append(config.Mutes, r)
+	}
+	mutesMu.Unlock()
+
+	if err := saveConfig(); err != nil {
+		return r, err
+	}
+	slog.Info("Muted", "type", r.Type, "value", r.Value, "account", r.Account)
+	return r, nil
+}
+
+func removeMute(id string) (bool, error) {
+	mutesMu.Lock()
+	removed := false
+	for i, r := range config.Mutes {
+		if r.ID == id {
+			config.Mutes = append(config.Mutes[:i], config.Mutes[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	mutesMu.Unlock()
+
+	if !removed {
+		return false, nil
+	}
+	return true, saveConfig()
+}
+
+// muteFromNotification builds a mute of the given type for the message of a
+// notification record.
+func muteFromNotification(rec NotificationRecord, typ string) (MuteRule, error) {
+	r := MuteRule{Type: typ, Account: rec.Account}
+	switch typ {
+	case "sender":
+		r.Value = rec.FromAddress
+		r.Account = ""
+	case "domain":
+		r.Value = senderDomain(rec.FromAddress)
+		r.Account = ""
+	case "list":
+		r.Value = rec.ListID
+	case "thread":
+		r.Value = rec.ThreadID
+		r.Note = rec.Subject
+	}
+	if r.Value == "" {
+		return r, fmt.Errorf("the message has no %s to mute", typ)
+	}
+	return r, nil
+}
+
+// muteActions lists the mute actions available for a message.
+func muteActions(m matchedMessage) []string {
+	var actions []string
+	if m.FromAddress != "" {
+		actions = append(actions, "mute-sender", "mute-domain")
+	}
+	if m.ListID != "" {
+		actions = append(actions, "mute-list")
+	}
+	if threadRoot(m) != "" {
+		actions = append(actions, "mute-thread")
+	}
+	return actions
+}
+
+// muteNotificationActions are the buttons offered on desktop notifications.
+func muteNotificationActions(acc *AccountConfig, m matchedMessage) []notificationAction {
+	var actions []notificationAction
+	if thread := threadRoot(m); thread != "" {
+		rule := MuteRule{Type: "thread", Value: thread, Account: acc.Email, Note: m.Subject}
+		actions = append(actions, notificationAction{Label: "Mute thread", Run: func() {
+			if _, err := addMute(rule); err != nil {
+				slog.Error("Failed to mute thread", "error", err)
+			}
+		}})
+	}
+	if m.FromAddress != "" {
+		rule := MuteRule{Type: "sender", Value: m.FromAddress}
+		actions = append(actions, notificationAction{Label: "Mute sender", Run: func() {
+			if _, err := addMute(rule); err != nil {
+				slog.Error("Failed to mute sender", "error", err)
+			}
+		}})
+	}
+	return actions
+}
+
+func handleMutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		if pruneMutes() {
+			if err := saveConfig(); err != nil {
+				slog.Error("Failed to save config", "error", err)
+			}
+		}
+		mutes := currentMutes()
+		if mutes == nil {
+			mutes = []MuteRule{}
+		}
+		json.NewEncoder(w).Encode(mutes)
+
+	case http.MethodPost:
+		var req struct {
+			Type    string `json:"type"`
+			Value   string `json:"value"`
+			Account string `json:"account"`
+			Note    string `json:"note"`
+			For     string `json:"for"` // a duration such as "1w", or an RFC 3339 time
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rule := MuteRule{Type: req.Type, Value: req.Value, Account: req.Account, Note: req.Note}
+		if req.For != "" {
+			until, err := parseUntil(req.For)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+				return
+			}
+			rule.Until = &until
+		}
+
+		rule, err := addMute(rule)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Muted " + rule.Value, "mute": rule})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleDeleteMute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	removed, err := removeMute(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Mute not found"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Mute removed"})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandWrittenMutes(t *testing.T) {
+	useTempAppDir(t)
+	savedMutes := config.Mutes
+	t.Cleanup(func() { config.Mutes = savedMutes })
+	config.Mutes = []MuteRule{
+		{Type: "domain", Value: " Example.com "},
+		{Type: "sender", Value: "News@Shop.com"},
+		{ID: "kept", Type: "thread", Value: "<Root@Example.com>"},
+	}
+
+	if !assignMuteIDs() {
+		t.Fatal("assignMuteIDs reported no change")
+	}
+	if assignMuteIDs() {
+		t.Error("assignMuteIDs changed normalized mutes again")
+	}
+	if config.Mutes[0].ID == "" || config.Mutes[1].ID == "" || config.Mutes[2].ID != "kept" {
+		t.Errorf("IDs = %q, %q, %q", config.Mutes[0].ID, config.Mutes[1].ID, config.Mutes[2].ID)
+	}
+	if config.Mutes[0].Value != "example.com" || config.Mutes[2].Value != "<Root@Example.com>" {
+		t.Errorf("values = %q, %q", config.Mutes[0].Value, config.Mutes[2].Value)
+	}
+
+	acc := &AccountConfig{Email: "me@example.org"}
+	for _, from := range []string{"alerts@mail.EXAMPLE.com", "news@shop.com"} {
+		if _, ok := findMute(acc, matchedMessage{FromAddress: from}); !ok {
+			t.Errorf("%s not muted", from)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/mutes/"+config.Mutes[0].ID+"/delete", nil)
+	req.SetPathValue("id", config.Mutes[0].ID)
+	handleDeleteMute(w, req)
+	if w.Code != 200 || len(config.Mutes) != 2 {
+		t.Errorf("delete = %d %s, %d mutes left", w.Code, w.Body, len(config.Mutes))
+	}
+}
+
+func TestMuteRuleDomainIgnoresCase(t *testing.T) {
+	r := MuteRule{Type: "domain", Value: "Example.com"}
+	for from, want := range map[string]bool{
+		"a@example.com":      true,
+		"a@Sub.Example.COM":  true,
+		"a@notexample.com":   false,
+		"a@example.com.evil": false,
+	} {
+		if got := r.matches("me@example.org", matchedMessage{FromAddress: from}); got != want {
+			t.Errorf("matches(%s) = %v, want %v", from, got, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxRecentNotifications = 500
+
+// notificationHeaderFields are fetched (with PEEK) alongside the envelope of
+// every unseen IMAP message.
+var notificationHeaderFields = []string{
+	"X-Priority", "Importance", "Priority",
+	"Content-Type", "Content-Transfer-Encoding",
+	"List-Id", "In-Reply-To", "References",
+	"Authentication-Results", "ARC-Authentication-Results", "ARC-Seal",
+}
+
+type matchedMessage struct {
+	Folder      string
+	Sender      string
+	FromName    string
+	FromAddress string
+	Senders     []senderAddress // From, Sender and Reply-To, for sender patterns
+	To          []string
+	Cc          []string
+	Subject     string
+	Date        time.Time
+	Snippet     string
+	Code        string
+	CodeID      int // in detectedCodes
+	Priority    string
+	MessageID   string
+	InReplyTo   string
+	References  []string
+	ListID      string
+	Rule        string // the filter condition that let the message through
+	Auth        authVerdict
+	Parts       messageParts
+	// Ref locates the message for dashboard actions: the UID for IMAP, the
+	// email ID for JMAP and the unique file name for Maildir.
+	Ref string
+}
+
+// notificationAction is a button shown on a desktop notification where the
+// platform supports it.
+type notificationAction struct {
+	Label string
+	Run   func()
+}
+
+type NotificationRecord struct {
+	ID          int64            `json:"id"`
+	Account     string           `json:"account"`
+	Folder      string           `json:"folder"`
+	Sender      string           `json:"sender"`
+	FromAddress string           `json:"from_address,omitempty"`
+	Subject     string           `json:"subject"`
+	Date        time.Time        `json:"date"`
+	Time        time.Time        `json:"time"`
+	Snippet     string           `json:"snippet,omitempty"`
+	Code        string           `json:"code,omitempty"`    // only filled in by withLiveCode
+	CodeID      int              `json:"code_id,omitempty"` // see detectedCodes
+	Priority    string           `json:"priority"`
+	Status      string           `json:"status"` // "notified", "held", "dropped", "batched" or "digest"
+	Rule        string           `json:"rule,omitempty"`
+	Auth        authVerdict      `json:"auth"`
+	Attachments []attachmentInfo `json:"attachments,omitempty"`
+	Size        int64            `json:"size,omitempty"`
+	Invite      *calendarInvite  `json:"invite,omitempty"`
+	MessageID   string           `json:"message_id,omitempty"`
+	ListID      string           `json:"list_id,omitempty"`
+	ThreadID    string           `json:"thread_id,omitempty"` // see threadRoot
+	WebmailURL  string           `json:"webmail_url,omitempty"`
+	Actions     []string         `json:"actions,omitempty"` // supported message actions, see handleNotificationAction
+	Read        bool             `json:"read,omitempty"`
+	Archived    bool             `json:"archived,omitempty"`
+	ref         string
+}
+
+var (
+	recentNotifications   []NotificationRecord
+	recentNotificationsMu sync.RWMutex
+	nextNotificationID    int64
+)
+
+func recordNotification(acc *AccountConfig, m matchedMessage, status string) {
+	rec := NotificationRecord{
+		Account:     acc.Email,
+		Folder:      m.Folder,
+		Sender:      m.Sender,
+		FromAddress: m.FromAddress,
+		Subject:     redactCode(m.Subject, m.Code),
+		Date:        m.Date,
+		Time:        time.Now(),
+		Snippet:     redactCode(m.Snippet, m.Code),
+		CodeID:      m.CodeID,
+		Priority:    m.Priority,
+		Status:      status,
+		Rule:        m.Rule,
+		Auth:        m.Auth,
+		Attachments: m.Parts.Attachments,
+		Size:        m.Parts.Size,
+		Invite:      m.Parts.Invite,
+		MessageID:   m.MessageID,
+		ListID:      m.ListID,
+		ThreadID:    threadRoot(m),
+		WebmailURL:  webmailURL(acc, m),
+		Actions:     messageActions(acc, m),
+		ref:         m.Ref,
+	}
+
+	recentNotificationsMu.Lock()
+	nextNotificationID++
+	rec.ID = nextNotificationID
+	recentNotifications = append(recentNotifications, rec)
+	if len(recentNotifications) > maxRecentNotifications {
+		recentNotifications = recentNotifications[len(recentNotifications)-maxRecentNotifications:]
+	}
+	recentNotificationsMu.Unlock()
+
+	publishEvent(eventNotification, acc.Email, rec)
+}
+
+// notificationsSince returns the records added for account since t, oldest
+// first.
+func notificationsSince(account string, t time.Time) []NotificationRecord {
+	recentNotificationsMu.Lock()
+	defer recentNotificationsMu.Unlock()
+
+	records := []NotificationRecord{}
+	for _, rec := range recentNotifications {
+		if rec.Account == account && !rec.Time.Before(t) {
+			records = append(records, withLiveCode(rec))
+		}
+	}
+	return records
+}
+
+// withLiveCode adds the record's one-time code, and puts it back into the
+// subject and snippet, while it has not expired. Codes are never stored in
+// the records themselves, which outlive them.
+func withLiveCode(rec NotificationRecord) NotificationRecord {
+	rec.Code = liveCode(rec.CodeID)
+	if rec.Code != "" {
+		rec.Subject = strings.ReplaceAll(rec.Subject, redactedCode, rec.Code)
+		rec.Snippet = strings.ReplaceAll(rec.Snippet, redactedCode, rec.Code)
+	}
+	return rec
+}
+
+// renameNotificationAccount keeps the recent notifications of a renamed
+// account visible under its new email.
+func renameNotificationAccount(from, to string) {
+	recentNotificationsMu.Lock()
+	defer recentNotificationsMu.Unlock()
+
+	for i := range recentNotifications {
+		if recentNotifications[i].Account == from {
+			recentNotifications[i].Account = to
+		}
+	}
+}
+
+func handleNotifications(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	account := r.URL.Query().Get("account")
+	folder := r.URL.Query().Get("folder")
+	rule := r.URL.Query().Get("rule")
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	recentNotificationsMu.RLock()
+	result := []NotificationRecord{}
+	for i := len(recentNotifications) - 1; i >= 0 && len(result) < limit; i-- {
+		rec := recentNotifications[i]
+		if account != "" && rec.Account != account {
+			continue
+		}
+		if folder != "" && rec.Folder != folder {
+			continue
+		}
+		if rule != "" && rec.Rule != rule {
+			continue
+		}
+		result = append(result, rec)
+	}
+	recentNotificationsMu.RUnlock()
+
+	for i := range result {
+		result[i] = withLiveCode(result[i])
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func parseHeaderSection(r io.Reader) textproto.MIMEHeader {
+	if r == nil {
+		return textproto.MIMEHeader{}
+	}
+	header, err := textproto.NewReader(bufio.NewReader(r)).ReadMIMEHeader()
+	if err != nil && header == nil {
+		return textproto.MIMEHeader{}
+	}
+	return header
+}
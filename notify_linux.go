@@ -0,0 +1,127 @@
+//go:build linux
+
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/esiqveland/notify"
+	"github.com/gen2brain/beeep"
+	"github.com/godbus/dbus/v5"
+)
+
+var (
+	dbusNotifier     notify.Notifier
+	dbusNotifierMu   sync.Mutex
+	pendingActionsMu sync.Mutex
+	pendingActions   = map[uint32][]notificationAction{}
+)
+
+// getNotifier returns a long-lived notifier so that ActionInvoked signals for
+// notification buttons can be delivered back to us.
+func getNotifier() (notify.Notifier, error) {
+	dbusNotifierMu.Lock()
+	defer dbusNotifierMu.Unlock()
+
+	if dbusNotifier != nil {
+		return dbusNotifier, nil
+	}
+
+	conn, err := dbus.SessionBusPrivate()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	n, err := notify.New(conn,
+		notify.WithOnAction(onNotificationAction),
+		notify.WithOnClosed(func(s *notify.NotificationClosedSignal) {
+			pendingActionsMu.Lock()
+			delete(pendingActions, s.ID)
+			pendingActionsMu.Unlock()
+		}),
+	)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	dbusNotifier = n
+	return n, nil
+}
+
+func onNotificationAction(s *notify.ActionInvokedSignal) {
+	pendingActionsMu.Lock()
+	actions := pendingActions[s.ID]
+	pendingActionsMu.Unlock()
+
+	idx, err := strconv.Atoi(s.ActionKey)
+	if err != nil || idx < 0 || idx >= len(actions) {
+		return
+	}
+	go actions[idx].Run()
+}
+
+// desktopNotify sends a notification over D-Bus so that the freedesktop
+// urgency hint, expiry timeout and action buttons are honoured, falling back
+// to beeep when no session bus is available.
+func desktopNotify(title, message string, level PriorityLevel, actions ...notificationAction) error {
+	notifier, err := getNotifier()
+	if err != nil {
+		return beeepNotify(title, message, level)
+	}
+
+	n := notify.Notification{
+		AppName:       "Email Monitor",
+		Summary:       title,
+		Body:          message,
+		ExpireTimeout: notify.ExpireTimeoutSetByNotificationServer,
+	}
+
+	switch level.Urgency {
+	case "low":
+		n.SetUrgency(notify.UrgencyLow)
+	case "critical":
+		n.SetUrgency(notify.UrgencyCritical)
+	default:
+		n.SetUrgency(notify.UrgencyNormal)
+	}
+
+	if level.persistent() {
+		n.ExpireTimeout = notify.ExpireTimeoutNever
+	} else if level.ExpireSeconds > 0 {
+		n.ExpireTimeout = time.Duration(level.ExpireSeconds) * time.Second
+	}
+
+	for i, a := range actions {
+		n.Actions = append(n.Actions, notify.Action{Key: strconv.Itoa(i), Label: a.Label})
+	}
+
+	id, err := notifier.SendNotification(n)
+	if err != nil {
+		slog.Warn("D-Bus notification failed, falling back", "error", err)
+		return beeepNotify(title, message, level)
+	}
+	if len(actions) > 0 {
+		pendingActionsMu.Lock()
+		pendingActions[id] = actions
+		pendingActionsMu.Unlock()
+	}
+	return nil
+}
+
+func beeepNotify(title, message string, level PriorityLevel) error {
+	if level.Urgency == "critical" {
+		return beeep.Alert(title, message, "")
+	}
+	return beeep.Notify(title, message, "")
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "github.com/gen2brain/beeep"
+
+// desktopNotify ignores actions: beeep has no buttons on these platforms, so
+// codes are copied from the tray menu or the dashboard instead.
+func desktopNotify(title, message string, level PriorityLevel, actions ...notificationAction) error {
+	if level.Urgency == "critical" {
+		return beeep.Alert(title, message, "")
+	}
+	return beeep.Notify(title, message, "")
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+const (
+	detectedCodeTTL = 10 * time.Minute
+	// redactedCode stands in for a code in the subject and snippet of
+	// notification records, which outlive it.
+	redactedCode = "••••••"
+)
+
+var (
+	otpKeyword   = regexp.MustCompile(`(?i)\b(code|codes|verification|verify|otp|passcode|one[- ]time|pin|2fa|mfa|security|login|sign[- ]in|confirm(ation)?|token)\b`)
+	otpCandidate = regexp.MustCompile(`\b([0-9]{3}[- ][0-9]{3}|[0-9]{4,8}|[A-Za-z0-9]{4,8})\b`)
+	otpYear      = regexp.MustCompile(`^(19|20)[0-9]{2}$`)
+)
+
+type detectedCode struct {
+	ID      int       `json:"id"`
+	Account string    `json:"account"`
+	Sender  string    `json:"sender"`
+	Subject string    `json:"subject"`
+	Code    string    `json:"code"`
+	Time    time.Time `json:"time"`
+}
+
+var (
+	detectedCodes   []detectedCode
+	detectedCodesMu sync.Mutex
+	nextCodeID      = 1
+)
+
+// detectOTP looks for a one-time code close to a keyword such as "code" or
+// "verification". Pure digit codes are preferred over alphanumeric ones.
+func detectOTP(texts ...string) string {
+	var alnum string
+	for _, text := range texts {
+		keywords := otpKeyword.FindAllStringIndex(text, -1)
+		if len(keywords) == 0 {
+			continue
+		}
+
+		for _, loc := range otpCandidate.FindAllStringIndex(text, -1) {
+			if !nearKeyword(loc, keywords, 40) {
+				continue
+			}
+			candidate := text[loc[0]:loc[1]]
+			if otpKeyword.MatchString(candidate) {
+				continue
+			}
+
+			digits := strings.NewReplacer("-", "", " ", "").Replace(candidate)
+			if isDigits(digits) {
+				if otpYear.MatchString(digits) {
+					continue
+				}
+				return digits
+			}
+
+			// Alphanumeric codes must mix letters and digits, otherwise
+			// ordinary words would match.
+			if alnum == "" && strings.IndexFunc(candidate, isDigitRune) >= 0 && candidate == strings.ToUpper(candidate) {
+				alnum = candidate
+			}
+		}
+	}
+	return alnum
+}
+
+// setSnippetAndCode runs code detection on the decoded body text and keeps
+// the snippet only if the account asked for it. Codes of muted messages are
+// not kept, since they are not notified.
+func setSnippetAndCode(acc *AccountConfig, m *matchedMessage, snippet string) {
+	if acc.ShowSnippet {
+		m.Snippet = snippet
+	}
+	if _, muted := findMute(acc, *m); acc.DetectCodes && !muted {
+		m.Code = detectOTP(m.Subject, snippet)
+		if m.Code != "" {
+			m.CodeID = recordDetectedCode(acc, *m)
+		}
+	}
+}
+
+func nearKeyword(loc []int, keywords [][]int, distance int) bool {
+	for _, k := range keywords {
+		if loc[0] >= k[1] && loc[0]-k[1] <= distance {
+			return true
+		}
+		if k[0] >= loc[1] && k[0]-loc[1] <= distance {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigitRune(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool { return !isDigitRune(r) }) < 0
+}
+
+// recordDetectedCode keeps a code for detectedCodeTTL and returns its ID.
+// This is the only place codes are kept; notification records refer to it.
+func recordDetectedCode(acc *AccountConfig, m matchedMessage) int {
+	detectedCodesMu.Lock()
+	defer detectedCodesMu.Unlock()
+
+	pruneDetectedCodes()
+	id := nextCodeID
+	detectedCodes = append(detectedCodes, detectedCode{
+		ID:      id,
+		Account: acc.Email,
+		Sender:  m.Sender,
+		Subject: m.Subject,
+		Code:    m.Code,
+		Time:    time.Now(),
+	})
+	nextCodeID++
+	return id
+}
+
+// redactCode replaces code in s, also where it is written as "123-456" or
+// "123 456", which detectOTP joins.
+func redactCode(s, code string) string {
+	if code == "" || s == "" {
+		return s
+	}
+	chars := strings.Split(code, "")
+	for i, c := range chars {
+		chars[i] = regexp.QuoteMeta(c)
+	}
+	re := regexp.MustCompile(`\b` + strings.Join(chars, `[- ]?`) + `\b`)
+	return re.ReplaceAllString(s, redactedCode)
+}
+
+// liveCode returns the code with the given ID, or "" once it has expired.
+func liveCode(id int) string {
+	if id == 0 {
+		return ""
+	}
+	detectedCodesMu.Lock()
+	defer detectedCodesMu.Unlock()
+
+	pruneDetectedCodes()
+	for _, c := range detectedCodes {
+		if c.ID == id {
+			return c.Code
+		}
+	}
+	return ""
+}
+
+// pruneDetectedCodes drops expired codes. detectedCodesMu must be held.
+func pruneDetectedCodes() {
+	kept := detectedCodes[:0]
+	for _, c := range detectedCodes {
+		if time.Since(c.Time) < detectedCodeTTL {
+			kept = append(kept, c)
+		}
+	}
+	detectedCodes = kept
+}
+
+func copyToClipboard(text string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		for _, candidate := range [][]string{
+			{"wl-copy"},
+			{"xclip", "-selection", "clipboard"},
+			{"xsel", "--clipboard", "--input"},
+		} {
+			if _, err := exec.LookPath(candidate[0]); err == nil {
+				cmd = exec.Command(candidate[0], candidate[1:]...)
+				break
+			}
+		}
+		if cmd == nil {
+			return fmt.Errorf("no clipboard tool found (install wl-clipboard, xclip or xsel)")
+		}
+	case "darwin":
+		cmd = exec.Command("pbcopy")
+	case "windows":
+		cmd = exec.Command("clip")
+	default:
+		return fmt.Errorf("clipboard not supported on %s", runtime.GOOS)
+	}
+
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+func copyCode(code string) {
+	if err := copyToClipboard(code); err != nil {
+		slog.Warn("Failed to copy code to clipboard", "error", err)
+		return
+	}
+	slog.Info("Copied one-time code to clipboard")
+}
+
+func setupCodesMenu() {
+	mCopy := systray.AddMenuItem("📋 Copy Last Code", "Copy the most recent one-time code")
+
+	go func() {
+		for {
+			<-mCopy.ClickedCh
+			detectedCodesMu.Lock()
+			pruneDetectedCodes()
+			var code string
+			if len(detectedCodes) > 0 {
+				code = detectedCodes[len(detectedCodes)-1].Code
+			}
+			detectedCodesMu.Unlock()
+
+			if code != "" {
+				copyCode(code)
+			}
+		}
+	}()
+}
+
+func handleCodes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	detectedCodesMu.Lock()
+	pruneDetectedCodes()
+	codes := make([]detectedCode, 0, len(detectedCodes))
+	for i := len(detectedCodes) - 1; i >= 0; i-- {
+		codes = append(codes, detectedCodes[i])
+	}
+	detectedCodesMu.Unlock()
+
+	json.NewEncoder(w).Encode(codes)
+}
+
+func handleCopyCode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code := liveCode(req.ID)
+	w.Header().Set("Content-Type", "application/json")
+	if code == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Code not found or expired"})
+		return
+	}
+	if err := copyToClipboard(code); err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Code copied to clipboard"})
+}
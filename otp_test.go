@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectOTP(t *testing.T) {
+	tests := []struct {
+		subject, text string
+		want          string
+	}{
+		{"Your verification code", "Use 482913 to sign in.", "482913"},
+		{"Sign-in attempt", "Your code is 123-456", "123456"},
+		{"Your login code: A7K9Q2", "", "A7K9Q2"},
+		{"Security alert", "Code 2024 expires soon, use 5531 instead", "5531"},
+		{"Invoice 123456", "Thanks for your order", ""},
+		{"Meeting notes", "See you at 1530 tomorrow", ""},
+		{"Confirm your account", "Click the link below.", ""},
+	}
+	for _, tt := range tests {
+		if got := detectOTP(tt.subject, tt.text); got != tt.want {
+			t.Errorf("detectOTP(%q, %q) = %q, want %q", tt.subject, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNotificationCodeExpires(t *testing.T) {
+	acc := &AccountConfig{Email: "otp-test@example.com", DetectCodes: true}
+	m := matchedMessage{Folder: "INBOX", Sender: "noreply@example.com", Subject: "Your verification code"}
+	setSnippetAndCode(acc, &m, "Use 482913 to sign in.")
+	if m.Code != "482913" || m.CodeID == 0 {
+		t.Fatalf("code not detected: %q (id %d)", m.Code, m.CodeID)
+	}
+
+	start := time.Now()
+	recordNotification(acc, m, "notified")
+
+	recentNotificationsMu.RLock()
+	stored := recentNotifications[len(recentNotifications)-1]
+	recentNotificationsMu.RUnlock()
+	if stored.Code != "" {
+		t.Errorf("stored record keeps the code %q", stored.Code)
+	}
+
+	events.mu.Lock()
+	ev := events.backlog[len(events.backlog)-1]
+	events.mu.Unlock()
+	payload, _ := json.Marshal(ev.Data)
+	var published NotificationRecord
+	json.Unmarshal(payload, &published)
+	if published.Code != "" || published.CodeID != m.CodeID {
+		t.Errorf("event payload = %s, want a code_id and no code", payload)
+	}
+
+	served := func() string {
+		w := httptest.NewRecorder()
+		handleNotifications(w, httptest.NewRequest("GET", "/api/notifications?account="+acc.Email, nil))
+		var records []NotificationRecord
+		if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil || len(records) == 0 {
+			t.Fatalf("unexpected response %s", w.Body)
+		}
+		return records[0].Code
+	}
+	if code := served(); code != "482913" {
+		t.Errorf("served code = %q before expiry, want 482913", code)
+	}
+	if since := notificationsSince(acc.Email, start); len(since) != 1 || since[0].Code != "482913" {
+		t.Errorf("notificationsSince = %+v, want the live code", since)
+	}
+
+	detectedCodesMu.Lock()
+	for i := range detectedCodes {
+		if detectedCodes[i].ID == m.CodeID {
+			detectedCodes[i].Time = time.Now().Add(-detectedCodeTTL - time.Second)
+		}
+	}
+	detectedCodesMu.Unlock()
+
+	if code := served(); code != "" {
+		t.Errorf("served code = %q after expiry, want none", code)
+	}
+}
+
+func TestMutedSenderCodeNotKept(t *testing.T) {
+	savedMutes := config.Mutes
+	t.Cleanup(func() { config.Mutes = savedMutes })
+	config.Mutes = []MuteRule{{ID: "m1", Type: "domain", Value: "spam.example"}}
+
+	acc := &AccountConfig{Email: "otp-mute@example.com", DetectCodes: true}
+	m := matchedMessage{FromAddress: "codes@spam.example", Subject: "Your verification code"}
+	setSnippetAndCode(acc, &m, "Use 482913 to sign in.")
+	if m.Code != "" || m.CodeID != 0 {
+		t.Fatalf("muted message kept code %q (id %d)", m.Code, m.CodeID)
+	}
+	detectedCodesMu.Lock()
+	defer detectedCodesMu.Unlock()
+	for _, c := range detectedCodes {
+		if c.Account == acc.Email {
+			t.Errorf("code %q of a muted message offered", c.Code)
+		}
+	}
+}
+
+func TestNotificationCodeRedacted(t *testing.T) {
+	acc := &AccountConfig{Email: "otp-redact@example.com", DetectCodes: true, ShowSnippet: true}
+	m := matchedMessage{Folder: "INBOX", Sender: "noreply@example.com", Subject: "Your login code: 123-456"}
+	setSnippetAndCode(acc, &m, "Enter 123 456 to sign in. Order 7654321 ships today.")
+	if m.Code != "123456" {
+		t.Fatalf("code = %q, want 123456", m.Code)
+	}
+	recordNotification(acc, m, "notified")
+
+	recentNotificationsMu.RLock()
+	stored := recentNotifications[len(recentNotifications)-1]
+	recentNotificationsMu.RUnlock()
+	wantSubject, wantSnippet := "Your login code: "+redactedCode, "Enter "+redactedCode+" to sign in. Order 7654321 ships today."
+	if stored.Subject != wantSubject || stored.Snippet != wantSnippet {
+		t.Errorf("stored subject %q, snippet %q", stored.Subject, stored.Snippet)
+	}
+
+	events.mu.Lock()
+	payload, _ := json.Marshal(events.backlog[len(events.backlog)-1].Data)
+	events.mu.Unlock()
+	for _, code := range []string{"123456", "123-456", "123 456"} {
+		if strings.Contains(string(payload), code) {
+			t.Errorf("event payload contains the code: %s", payload)
+		}
+	}
+
+	live := withLiveCode(stored)
+	if live.Subject != "Your login code: 123456" || !strings.HasPrefix(live.Snippet, "Enter 123456 ") {
+		t.Errorf("live subject %q, snippet %q", live.Subject, live.Snippet)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message"
+	"github.com/knadh/go-pop3"
+)
+
+const (
+	defaultPreviewLimit = 20
+	maxPreviewLimit     = 100
+)
+
+// filterProposal is the filter configuration to try out. Fields that are
+// not sent keep the account's saved values.
+type filterProposal struct {
+	IncludeKeyword    []string `json:"include_keyword"`
+	ExcludeKeyword    []string `json:"exclude_keyword"`
+	IncludeEmail      []string `json:"include_email"`
+	ExcludeEmail      []string `json:"exclude_email"`
+	FilterSets        []string `json:"filter_sets"`
+	NormalizePlusTags bool     `json:"normalize_plus_tags"`
+	FolderMode        string   `json:"folder_mode"`
+	IncludeFolders    []string `json:"include_folders"`
+	ExcludeFolders    []string `json:"exclude_folders"`
+	SieveScript       string   `json:"sieve_script"`
+	Limit             int      `json:"limit"`
+}
+
+// filterVerdict tells whether a recent message would notify and why.
+type filterVerdict struct {
+	Folder  string      `json:"folder"`
+	From    string      `json:"from"`
+	Subject string      `json:"subject"`
+	Date    time.Time   `json:"date"`
+	Notify  bool        `json:"notify"`
+	Reason  string      `json:"reason"`
+	Auth    authVerdict `json:"auth"`
+}
+
+// previewMessage is a recent message with what the filters look at.
+type previewMessage struct {
+	in filterInput
+	m  matchedMessage
+}
+
+func handleFilterPreview(w http.ResponseWriter, r *http.Request) {
+	acc := accountFromRequest(w, r)
+	if acc == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	p := filterProposal{
+		IncludeKeyword:    acc.IncludeKeyword,
+		ExcludeKeyword:    acc.ExcludeKeyword,
+		IncludeEmail:      acc.IncludeEmail,
+		ExcludeEmail:      acc.ExcludeEmail,
+		FilterSets:        acc.FilterSets,
+		NormalizePlusTags: acc.NormalizePlusTags,
+		FolderMode:        acc.FolderMode,
+		IncludeFolders:    acc.IncludeFolders,
+		ExcludeFolders:    acc.ExcludeFolders,
+		SieveScript:       acc.SieveScript,
+		Limit:             defaultPreviewLimit,
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if p.Limit <= 0 || p.Limit > maxPreviewLimit {
+		p.Limit = defaultPreviewLimit
+	}
+
+	err := validateSenderPatterns(append(append([]string(nil), p.IncludeEmail...), p.ExcludeEmail...))
+	if err == nil {
+		err = validateAccountFilterSets(p.FilterSets)
+	}
+	if err == nil {
+		if _, err = parseSieve(p.SieveScript); err != nil {
+			err = fmt.Errorf("Invalid Sieve script: %v", err)
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+
+	// The proposal only carries filter and folder settings; connecting
+	// still uses the saved account.
+	proposed := &AccountConfig{
+		Email:             acc.Email,
+		IncludeKeyword:    p.IncludeKeyword,
+		ExcludeKeyword:    p.ExcludeKeyword,
+		IncludeEmail:      p.IncludeEmail,
+		ExcludeEmail:      p.ExcludeEmail,
+		FilterSets:        p.FilterSets,
+		NormalizePlusTags: p.NormalizePlusTags,
+		FolderMode:        p.FolderMode,
+		IncludeFolders:    p.IncludeFolders,
+		ExcludeFolders:    p.ExcludeFolders,
+		SieveScript:       p.SieveScript,
+	}
+
+	messages, err := recentMessages(acc, proposed, p.Limit)
+	if err != nil {
+		acc.logger().Warn("Filter preview failed", "error", err)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+
+	verdicts := make([]filterVerdict, 0, len(messages))
+	notify := 0
+	for _, msg := range messages {
+		v := filterVerdict{
+			Folder:  msg.m.Folder,
+			From:    msg.m.Sender,
+			Subject: msg.m.Subject,
+			Date:    msg.m.Date,
+		}
+		v.Notify, v.Reason = filterMessage(proposed, msg.in)
+		v.Auth = assessSender(msg.in)
+		if v.Notify {
+			if mute, ok := findMute(acc, msg.m); ok {
+				v.Notify, v.Reason = false, "muted: "+mute.Type+" "+mute.Value
+			}
+		}
+		if v.Notify {
+			notify++
+		}
+		verdicts = append(verdicts, v)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"messages": verdicts,
+		"message":  fmt.Sprintf("%d of the last %d messages would notify", notify, len(verdicts)),
+	})
+}
+
+// recentMessages fetches the newest messages, read or not, from the folders
+// selected by proposed, with the text and MIME structure if its Sieve script
+// tests them. Nothing
+// is marked as read.
+func recentMessages(acc, proposed *AccountConfig, limit int) ([]previewMessage, error) {
+	var messages []previewMessage
+	var err error
+	switch acc.Protocol {
+	case "pop3":
+		messages, err = recentPOP3Messages(acc, proposed, limit)
+	case "jmap":
+		messages, err = recentJMAPMessages(acc, proposed, limit)
+	case "maildir":
+		messages, err = recentMaildirMessages(acc, proposed, limit)
+	case "mbox":
+		messages, err = recentMboxMessages(acc, limit)
+	default:
+		messages, err = recentIMAPMessages(acc, proposed, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].m.Date.After(messages[j].m.Date)
+	})
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// recentIMAPMessages examines each folder read-only and fetches the envelope,
+// size and headers of its last messages with BODY.PEEK.
+func recentIMAPMessages(acc, proposed *AccountConfig, limit int) ([]previewMessage, error) {
+	c, err := connectToIMAP(acc)
+	if err != nil {
+		return nil, fmt.Errorf("Connection failed: %v", err)
+	}
+	defer c.Logout()
+
+	section := &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier},
+		Peek:         true,
+	}
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchUid, imap.FetchRFC822Size, imap.FetchBodyStructure, section.FetchItem()}
+
+	var textSection *imap.BodySectionName
+	if sieveUsesBody(proposed) {
+		textSection = &imap.BodySectionName{
+			BodyPartName: imap.BodyPartName{Specifier: imap.TextSpecifier},
+			Peek:         true,
+			Partial:      []int{0, snippetFetchBytes},
+		}
+		items = append(items, textSection.FetchItem())
+	}
+
+	var messages []previewMessage
+	for _, folder := range selectFolders(proposed, func() []string { return listFolders(c) }) {
+		mbox, err := c.Select(folder, true)
+		if err != nil {
+			acc.logger().Warn("Select error", "folder", folder, "error", err)
+			continue
+		}
+		if mbox.Messages == 0 {
+			continue
+		}
+
+		from := uint32(1)
+		if mbox.Messages > uint32(limit) {
+			from = mbox.Messages - uint32(limit) + 1
+		}
+		seqset := new(imap.SeqSet)
+		seqset.AddRange(from, mbox.Messages)
+
+		fetched := make(chan *imap.Message, limit)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Fetch(seqset, items, fetched)
+		}()
+		for msg := range fetched {
+			if msg.Envelope == nil {
+				continue
+			}
+			header := parseHeaderSection(msg.GetBody(section))
+			parts, _, _ := imapMessageParts(msg.BodyStructure, msg.Size)
+			in := filterInput{
+				Senders: imapSenders(msg.Envelope),
+				Subject: msg.Envelope.Subject,
+				Header:  header.Values,
+				Size:    int64(msg.Size),
+				Parts:   &parts,
+			}
+			if textSection != nil {
+				in.Body = messageTextFromHeader(header, msg.GetBody(textSection))
+			}
+			m := newIMAPMatch(folder, msg.Envelope)
+			setThreadHeaders(&m, header.Get)
+			messages = append(messages, previewMessage{in: in, m: m})
+		}
+		if err := <-done; err != nil {
+			acc.logger().Warn("Fetch error", "folder", folder, "error", err)
+		}
+	}
+	return messages, nil
+}
+
+func recentPOP3Messages(acc, proposed *AccountConfig, limit int) ([]previewMessage, error) {
+	c, err := connectToPOP3(acc)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Quit()
+
+	count, _, err := c.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := 0
+	if sieveUsesBody(proposed) {
+		lines = snippetPOP3Lines
+	}
+
+	usesParts := sieveUsesParts(proposed)
+	var messages []previewMessage
+	for i := count; i > 0 && i > count-limit; i-- {
+		msg, err := c.Top(i, lines)
+		if err != nil {
+			continue
+		}
+		var size int64
+		if list, err := c.List(i); err == nil && len(list) > 0 {
+			size = int64(list[0].Size)
+		}
+		pm := entityPreviewMessage("INBOX", msg, size)
+		if usesParts {
+			parts := pop3MessageParts(c, i, msg.Header, size)
+			pm.in.Parts = &parts
+		}
+		messages = append(messages, pm)
+	}
+	return messages, nil
+}
+
+// connectToPOP3 logs in with the password from the keyring.
+func connectToPOP3(acc *AccountConfig) (*pop3.Conn, error) {
+	password, err := getPassword(acc.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	p := pop3.New(pop3.Opt{
+		Host:       acc.Server,
+		Port:       acc.Port,
+		TLSEnabled: true,
+	})
+	c, err := p.NewConn()
+	if err != nil {
+		return nil, fmt.Errorf("Connection failed: %v", err)
+	}
+	if err := c.Auth(acc.Username, password); err != nil {
+		c.Quit()
+		return nil, fmt.Errorf("Authentication failed: %v", err)
+	}
+	return c, nil
+}
+
+func recentJMAPMessages(acc, proposed *AccountConfig, limit int) ([]previewMessage, error) {
+	c, err := connectToJMAP(acc)
+	if err != nil {
+		return nil, fmt.Errorf("Connection failed: %v", err)
+	}
+	mailboxes, err := c.mailboxes()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list mailboxes: %v", err)
+	}
+
+	names := jmapFolderNames(mailboxes)
+	selected := make(map[string]bool)
+	for _, folder := range selectFolders(proposed, func() []string { return listJMAPFolders(mailboxes) }) {
+		selected[folder] = true
+	}
+	var conditions []interface{}
+	for _, mb := range mailboxes {
+		if selected[names[mb.ID]] {
+			conditions = append(conditions, map[string]interface{}{"inMailbox": mb.ID})
+		}
+	}
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+
+	emails, _, err := c.queryEmails(map[string]interface{}{"operator": "OR", "conditions": conditions}, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []previewMessage
+	for _, e := range emails {
+		folder := ""
+		for id := range e.MailboxIDs {
+			if selected[names[id]] {
+				folder = names[id]
+				break
+			}
+		}
+		messages = append(messages, previewMessage{in: jmapFilterInput(e), m: newJMAPMatch(folder, e)})
+	}
+	return messages, nil
+}
+
+// recentMaildirMessages reads the most recently delivered files of each
+// folder. Reading a file does not change its flags.
+func recentMaildirMessages(acc, proposed *AccountConfig, limit int) ([]previewMessage, error) {
+	root := expandPath(acc.Path)
+	if !isMaildir(root) {
+		return nil, fmt.Errorf("%s is not a Maildir", root)
+	}
+
+	type file struct {
+		path    string
+		modTime time.Time
+		size    int64
+	}
+
+	var messages []previewMessage
+	for _, folder := range selectFolders(proposed, func() []string { return listMaildirFolders(root) }) {
+		var files []file
+		for _, sub := range []string{"new", "cur"} {
+			dir := filepath.Join(maildirFolderPath(root, folder), sub)
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, e := range entries {
+				if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+					continue
+				}
+				if info, err := e.Info(); err == nil {
+					files = append(files, file{filepath.Join(dir, e.Name()), info.ModTime(), info.Size()})
+				}
+			}
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+		if len(files) > limit {
+			files = files[:limit]
+		}
+
+		for _, f := range files {
+			r, err := os.Open(f.path)
+			if err != nil {
+				continue
+			}
+			e, err := message.Read(r)
+			if e == nil || (err != nil && !message.IsUnknownCharset(err)) {
+				r.Close()
+				continue
+			}
+			messages = append(messages, entityPreviewMessage(folder, e, f.size))
+			r.Close()
+		}
+	}
+	return messages, nil
+}
+
+func recentMboxMessages(acc *AccountConfig, limit int) ([]previewMessage, error) {
+	f, err := os.Open(expandPath(acc.Path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := readFrom(f, 0)
+	if err != nil {
+		return nil, err
+	}
+	msgs, _ := splitMbox(data)
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+
+	var messages []previewMessage
+	for _, msg := range msgs {
+		e, err := message.Read(bytes.NewReader(msg.raw))
+		if e == nil || (err != nil && !message.IsUnknownCharset(err)) {
+			continue
+		}
+		messages = append(messages, entityPreviewMessage("INBOX", e, int64(len(msg.raw))))
+	}
+	return messages, nil
+}
+
+func entityPreviewMessage(folder string, e *message.Entity, size int64) previewMessage {
+	text, parts := messageContent(e)
+	parts.Size = size
+	in := entityFilterInput(e.Header, text)
+	in.Size = size
+	in.Parts = &parts
+
+	m := newPOP3Match(entityHeader(e.Header))
+	m.Folder = folder
+	return previewMessage{in: in, m: m}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+var priorityOrder = map[string]int{
+	"low":      0,
+	"normal":   1,
+	"high":     2,
+	"critical": 3,
+}
+
+type PriorityRule struct {
+	Priority string   `json:"priority"`
+	Senders  []string `json:"senders,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+	Folders  []string `json:"folders,omitempty"`
+	Auth     string   `json:"auth,omitempty"` // "verified", "unverified" or "suspicious"
+
+	HasAttachment   bool     `json:"has_attachment,omitempty"`
+	AttachmentTypes []string `json:"attachment_types,omitempty"` // "application/pdf", "image/*" or "*.pdf"
+	Invite          bool     `json:"invite,omitempty"`           // has a calendar part
+	MinSize         int64    `json:"min_size,omitempty"`         // bytes
+}
+
+// PriorityLevel is how a priority is shown. Fields left out of the config
+// keep their default, so Persistent and RepeatSeconds are pointers to tell
+// an explicit false or 0 from a missing value.
+type PriorityLevel struct {
+	Sound         string `json:"sound,omitempty"`
+	Urgency       string `json:"urgency,omitempty"`        // "low", "normal" or "critical"
+	ExpireSeconds int    `json:"expire_seconds,omitempty"` // 0 leaves it to the notification server
+	Persistent    *bool  `json:"persistent,omitempty"`     // never expire
+	RepeatSeconds *int   `json:"repeat_seconds,omitempty"` // repeat until acknowledged
+}
+
+func (level PriorityLevel) persistent() bool {
+	return level.Persistent != nil && *level.Persistent
+}
+
+func (level PriorityLevel) repeatInterval() time.Duration {
+	if level.RepeatSeconds == nil {
+		return 0
+	}
+	return time.Duration(*level.RepeatSeconds) * time.Second
+}
+
+var (
+	criticalPersistent    = true
+	criticalRepeatSeconds = 300
+)
+
+var defaultPriorityLevels = map[string]PriorityLevel{
+	"low":      {Urgency: "low"},
+	"normal":   {Urgency: "normal"},
+	"high":     {Urgency: "critical"},
+	"critical": {Urgency: "critical", Persistent: &criticalPersistent, RepeatSeconds: &criticalRepeatSeconds},
+}
+
+type pendingAlert struct {
+	ID         int       `json:"id"`
+	Account    string    `json:"account"`
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+	Priority   string    `json:"priority"`
+	Created    time.Time `json:"created"`
+	Repeats    int       `json:"repeats"`
+	nextRepeat time.Time
+	interval   time.Duration
+}
+
+var (
+	pendingAlerts   []*pendingAlert
+	pendingAlertsMu sync.Mutex
+	nextAlertID     = 1
+)
+
+func validatePriority(p string) error {
+	if _, ok := priorityOrder[p]; !ok {
+		return fmt.Errorf("invalid priority %q (expected low, normal, high or critical)", p)
+	}
+	return nil
+}
+
+func validatePriorityLevels(levels map[string]PriorityLevel) error {
+	for name, level := range levels {
+		if err := validatePriority(name); err != nil {
+			return err
+		}
+		switch level.Urgency {
+		case "", "low", "normal", "critical":
+		default:
+			return fmt.Errorf("invalid urgency %q for priority %s", level.Urgency, name)
+		}
+		if level.ExpireSeconds < 0 || (level.RepeatSeconds != nil && *level.RepeatSeconds < 0) {
+			return fmt.Errorf("expire_seconds and repeat_seconds must not be negative")
+		}
+	}
+	return nil
+}
+
+func validatePriorityRules(rules []PriorityRule) error {
+	for _, rule := range rules {
+		if err := validatePriority(rule.Priority); err != nil {
+			return err
+		}
+		if err := validateSenderPatterns(rule.Senders); err != nil {
+			return err
+		}
+		for _, t := range rule.AttachmentTypes {
+			if _, err := path.Match(strings.ToLower(t), ""); err != nil {
+				return fmt.Errorf("invalid attachment type %q: %v", t, err)
+			}
+		}
+		switch rule.Auth {
+		case "", "verified", "unverified", "suspicious":
+		default:
+			return fmt.Errorf("invalid auth %q (expected verified, unverified or suspicious)", rule.Auth)
+		}
+	}
+	return nil
+}
+
+// priorityLevel returns the settings for a priority, with the configured
+// fields over the defaults, and whether they differ from the plain
+// notification used when priorities are not configured.
+func priorityLevel(priority string) (PriorityLevel, bool) {
+	if priority == "" {
+		priority = "normal"
+	}
+	level := defaultPriorityLevels[priority]
+	configured, ok := config.Priorities[priority]
+	if !ok {
+		return level, priority != "normal"
+	}
+
+	if configured.Sound != "" {
+		level.Sound = configured.Sound
+	}
+	if configured.Urgency != "" {
+		level.Urgency = configured.Urgency
+	}
+	if configured.ExpireSeconds > 0 {
+		level.ExpireSeconds = configured.ExpireSeconds
+		// An expiry asks for the notification to go away.
+		level.Persistent = nil
+	}
+	if configured.Persistent != nil {
+		level.Persistent = configured.Persistent
+	}
+	if configured.RepeatSeconds != nil {
+		level.RepeatSeconds = configured.RepeatSeconds
+	}
+	return level, true
+}
+
+// headerPriority maps the X-Priority, Importance and Priority headers onto a
+// priority level.
+func headerPriority(get func(string) string) string {
+	if xp := strings.TrimSpace(get("X-Priority")); xp != "" {
+		switch xp[0] {
+		case '1', '2':
+			return "high"
+		case '4', '5':
+			return "low"
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(get("Importance"))) {
+	case "high":
+		return "high"
+	case "low":
+		return "low"
+	}
+
+	switch strings.ToLower(strings.TrimSpace(get("Priority"))) {
+	case "urgent":
+		return "high"
+	case "non-urgent":
+		return "low"
+	}
+
+	return "normal"
+}
+
+func (rule PriorityRule) matches(m matchedMessage, normalizePlus bool) bool {
+	if rule.Auth != "" && rule.Auth != m.Auth.Status {
+		return false
+	}
+	if rule.HasAttachment && len(m.Parts.Attachments) == 0 {
+		return false
+	}
+	if rule.Invite && !m.Parts.Calendar {
+		return false
+	}
+	if rule.MinSize > 0 && m.Parts.Size < rule.MinSize {
+		return false
+	}
+	if len(rule.AttachmentTypes) > 0 {
+		found := false
+		for _, a := range m.Parts.Attachments {
+			for _, t := range rule.AttachmentTypes {
+				found = found || matchAttachmentType(t, a)
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(rule.Folders) > 0 {
+		found := false
+		for _, f := range rule.Folders {
+			if f == m.Folder {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(rule.Senders) > 0 {
+		if _, ok := findSenderPattern(rule.Senders, messageSenders(m), normalizePlus); !ok {
+			return false
+		}
+	}
+
+	if len(rule.Keywords) > 0 {
+		subject := strings.ToLower(m.Subject)
+		found := false
+		for _, k := range rule.Keywords {
+			if strings.Contains(subject, strings.ToLower(k)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resolvePriority picks the priority of the first matching rule, falling
+// back to the priority the message headers asked for.
+func resolvePriority(acc *AccountConfig, m matchedMessage, fromHeaders string) string {
+	for _, rule := range acc.PriorityRules {
+		if rule.matches(m, acc.NormalizePlusTags) {
+			return rule.Priority
+		}
+	}
+	if fromHeaders == "" {
+		return "normal"
+	}
+	return fromHeaders
+}
+
+func playSound(path string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		if _, err := exec.LookPath("paplay"); err == nil {
+			cmd = exec.Command("paplay", path)
+		} else {
+			cmd = exec.Command("aplay", "-q", path)
+		}
+	case "darwin":
+		cmd = exec.Command("afplay", path)
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-Command",
+			fmt.Sprintf("(New-Object Media.SoundPlayer '%s').PlaySync()", strings.ReplaceAll(path, "'", "''")))
+	default:
+		return
+	}
+
+	if err := cmd.Run(); err != nil {
+		slog.Warn("Failed to play sound", "path", path, "error", err)
+	}
+}
+
+func trackAlert(acc *AccountConfig, title, message, priority string, repeat time.Duration) {
+	pendingAlertsMu.Lock()
+	defer pendingAlertsMu.Unlock()
+
+	pendingAlerts = append(pendingAlerts, &pendingAlert{
+		ID:         nextAlertID,
+		Account:    acc.Email,
+		Title:      title,
+		Message:    message,
+		Priority:   priority,
+		Created:    time.Now(),
+		nextRepeat: time.Now().Add(repeat),
+		interval:   repeat,
+	})
+	nextAlertID++
+}
+
+func acknowledgeAlerts(id int) int {
+	pendingAlertsMu.Lock()
+	defer pendingAlertsMu.Unlock()
+
+	kept := pendingAlerts[:0]
+	acked := 0
+	for _, a := range pendingAlerts {
+		if id == 0 || a.ID == id {
+			acked++
+			continue
+		}
+		kept = append(kept, a)
+	}
+	pendingAlerts = kept
+
+	if acked > 0 {
+		slog.Info("Acknowledged alerts", "count", acked)
+	}
+	return acked
+}
+
+func runAlertLoop() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+
+		pendingAlertsMu.Lock()
+		var due []pendingAlert
+		for _, a := range pendingAlerts {
+			if now.After(a.nextRepeat) {
+				a.nextRepeat = now.Add(a.interval)
+				a.Repeats++
+				due = append(due, *a)
+			}
+		}
+		pendingAlertsMu.Unlock()
+
+		for _, a := range due {
+			level, _ := priorityLevel(a.Priority)
+			if level.Sound != "" {
+				go playSound(level.Sound)
+			}
+			err := desktopNotify(fmt.Sprintf("🔁 %s", a.Title), a.Message, level)
+			if err != nil {
+				slog.Warn("Notification error", "account", a.Account, "error", err)
+			}
+			metrics.notificationSent(a.Account, "desktop", err)
+		}
+	}
+}
+
+func setupAlertsMenu() {
+	mAck := systray.AddMenuItem("✅ Acknowledge Alerts", "Stop repeating unacknowledged alerts")
+
+	go func() {
+		for {
+			<-mAck.ClickedCh
+			acknowledgeAlerts(0)
+		}
+	}()
+}
+
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pendingAlertsMu.Lock()
+	alerts := make([]pendingAlert, 0, len(pendingAlerts))
+	for _, a := range pendingAlerts {
+		alerts = append(alerts, *a)
+	}
+	pendingAlertsMu.Unlock()
+
+	json.NewEncoder(w).Encode(alerts)
+}
+
+func handleAcknowledgeAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// An id of 0 acknowledges every pending alert.
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	acked := acknowledgeAlerts(req.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "acknowledged", "count": acked})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPriorityLevel(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities string
+		priority   string
+		want       PriorityLevel
+		persistent bool
+		repeat     time.Duration
+		custom     bool
+	}{
+		{
+			name:     "default normal",
+			priority: "",
+			want:     PriorityLevel{Urgency: "normal"},
+		},
+		{
+			name:       "default critical",
+			priority:   "critical",
+			want:       PriorityLevel{Urgency: "critical"},
+			persistent: true,
+			repeat:     5 * time.Minute,
+			custom:     true,
+		},
+		{
+			name:       "sound only keeps critical defaults",
+			priorities: `{"critical": {"sound": "alarm.wav"}}`,
+			priority:   "critical",
+			want:       PriorityLevel{Sound: "alarm.wav", Urgency: "critical"},
+			persistent: true,
+			repeat:     5 * time.Minute,
+			custom:     true,
+		},
+		{
+			name:       "explicit off",
+			priorities: `{"critical": {"persistent": false, "repeat_seconds": 0}}`,
+			priority:   "critical",
+			want:       PriorityLevel{Urgency: "critical"},
+			custom:     true,
+		},
+		{
+			name:       "expiry replaces persistence",
+			priorities: `{"critical": {"expire_seconds": 30}}`,
+			priority:   "critical",
+			want:       PriorityLevel{Urgency: "critical", ExpireSeconds: 30},
+			repeat:     5 * time.Minute,
+			custom:     true,
+		},
+		{
+			name:       "configured normal",
+			priorities: `{"normal": {"urgency": "low", "repeat_seconds": 60}}`,
+			priority:   "normal",
+			want:       PriorityLevel{Urgency: "low"},
+			repeat:     time.Minute,
+			custom:     true,
+		},
+		{
+			name:       "other level untouched",
+			priorities: `{"low": {"expire_seconds": 5}}`,
+			priority:   "high",
+			want:       PriorityLevel{Urgency: "critical"},
+			custom:     true,
+		},
+	}
+
+	saved := config.Priorities
+	defer func() { config.Priorities = saved }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Priorities = nil
+			if tt.priorities != "" {
+				if err := json.Unmarshal([]byte(tt.priorities), &config.Priorities); err != nil {
+					t.Fatal(err)
+				}
+			}
+			level, custom := priorityLevel(tt.priority)
+			if level.Sound != tt.want.Sound || level.Urgency != tt.want.Urgency || level.ExpireSeconds != tt.want.ExpireSeconds {
+				t.Errorf("priorityLevel(%q) = %+v, want %+v", tt.priority, level, tt.want)
+			}
+			if level.persistent() != tt.persistent {
+				t.Errorf("persistent = %v, want %v", level.persistent(), tt.persistent)
+			}
+			if level.repeatInterval() != tt.repeat {
+				t.Errorf("repeat = %v, want %v", level.repeatInterval(), tt.repeat)
+			}
+			if custom != tt.custom {
+				t.Errorf("custom = %v, want %v", custom, tt.custom)
+			}
+		})
+	}
+}
+
+func TestValidatePriorityLevels(t *testing.T) {
+	var levels map[string]PriorityLevel
+	if err := json.Unmarshal([]byte(`{"critical": {"repeat_seconds": -1}}`), &levels); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePriorityLevels(levels); err == nil {
+		t.Error("negative repeat_seconds accepted")
+	}
+	if err := validatePriorityLevels(map[string]PriorityLevel{"urgent": {}}); err == nil {
+		t.Error("unknown priority accepted")
+	}
+}
+
+func TestResolvePrioritySenderPatterns(t *testing.T) {
+	header := map[string]string{
+		"From":     `"Vendor Billing" <billing+eu@vendor.com>`,
+		"Reply-To": "support@help.vendor.com",
+	}
+	m := newPOP3Match(func(key string) string { return header[key] })
+
+	tests := []struct {
+		senders       []string
+		normalizePlus bool
+		want          string
+	}{
+		{[]string{"@vendor.com"}, false, "high"},
+		{[]string{"*.vendor.com"}, false, "high"},
+		{[]string{"support@help.vendor.com"}, false, "high"},
+		{[]string{"Vendor Billing"}, false, "high"},
+		{[]string{"/^billing/"}, false, "high"},
+		{[]string{"billing@vendor.com"}, false, "normal"},
+		{[]string{"billing@vendor.com"}, true, "high"},
+		{[]string{"@other.com"}, false, "normal"},
+	}
+	for _, tt := range tests {
+		acc := &AccountConfig{
+			NormalizePlusTags: tt.normalizePlus,
+			PriorityRules:     []PriorityRule{{Priority: "high", Senders: tt.senders}},
+		}
+		if got := resolvePriority(acc, m, ""); got != tt.want {
+			t.Errorf("senders %q (normalize %v): priority %q, want %q", tt.senders, tt.normalizePlus, got, tt.want)
+		}
+	}
+
+	if err := validatePriorityRules([]PriorityRule{{Priority: "high", Senders: []string{"/([/"}}}); err == nil {
+		t.Error("invalid sender pattern accepted")
+	}
+}
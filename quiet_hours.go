@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+type QuietHours struct {
+	Schedules   []QuietSchedule `json:"schedules"`
+	Action      string          `json:"action,omitempty"` // "summarize" or "drop"
+	VIPSenders  []string        `json:"vip_senders,omitempty"`
+	VIPKeywords []string        `json:"vip_keywords,omitempty"`
+}
+
+type QuietSchedule struct {
+	Days     []string `json:"days,omitempty"` // "mon".."sun", empty means every day
+	Start    string   `json:"start"`          // "22:00"
+	End      string   `json:"end"`            // "07:00"
+	TimeZone string   `json:"time_zone,omitempty"`
+}
+
+type heldNotification struct {
+	Folder  string
+	Sender  string
+	Subject string
+	Time    time.Time
+}
+
+var (
+	dndUntil time.Time
+	dndMu    sync.RWMutex
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) > 3 {
+		name = name[:3]
+	}
+	wd, ok := weekdayNames[name]
+	return wd, ok
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateQuietHours(qh *QuietHours) error {
+	if qh == nil {
+		return nil
+	}
+	switch qh.Action {
+	case "", "summarize", "drop":
+	default:
+		return fmt.Errorf("invalid quiet hours action %q", qh.Action)
+	}
+	for _, s := range qh.Schedules {
+		if _, err := parseClock(s.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(s.End); err != nil {
+			return err
+		}
+		for _, d := range s.Days {
+			if _, ok := parseWeekday(d); !ok {
+				return fmt.Errorf("invalid weekday %q", d)
+			}
+		}
+		if s.TimeZone != "" {
+			if _, err := time.LoadLocation(s.TimeZone); err != nil {
+				return fmt.Errorf("invalid time zone %q: %v", s.TimeZone, err)
+			}
+		}
+	}
+	return validateSenderPatterns(qh.VIPSenders)
+}
+
+func (s QuietSchedule) hasDay(d time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, name := range s.Days {
+		if wd, ok := parseWeekday(name); ok && wd == d {
+			return true
+		}
+	}
+	return false
+}
+
+// active reports whether t falls inside the schedule. Ranges that wrap past
+// midnight belong to the day on which they start.
+func (s QuietSchedule) active(t time.Time) bool {
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(s.End)
+	if err != nil {
+		return false
+	}
+
+	if s.TimeZone != "" {
+		if loc, err := time.LoadLocation(s.TimeZone); err == nil {
+			t = t.In(loc)
+		}
+	}
+	now := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	switch {
+	case start == end:
+		return s.hasDay(today)
+	case start < end:
+		return s.hasDay(today) && now >= start && now < end
+	default:
+		return (s.hasDay(today) && now >= start) || (s.hasDay(yesterday) && now < end)
+	}
+}
+
+func dndActive(t time.Time) bool {
+	dndMu.RLock()
+	defer dndMu.RUnlock()
+	return t.Before(dndUntil)
+}
+
+func setDoNotDisturb(d time.Duration) {
+	dndMu.Lock()
+	if d <= 0 {
+		dndUntil = time.Time{}
+	} else {
+		dndUntil = time.Now().Add(d)
+	}
+	dndMu.Unlock()
+
+	if d <= 0 {
+		slog.Info("Do not disturb disabled")
+	} else {
+		slog.Info("Do not disturb enabled", "duration", d.String())
+	}
+}
+
+func isQuietTime(acc *AccountConfig, t time.Time) bool {
+	if dndActive(t) {
+		return true
+	}
+	for _, qh := range []*QuietHours{config.QuietHours, acc.QuietHours} {
+		if qh == nil {
+			continue
+		}
+		for _, s := range qh.Schedules {
+			if s.active(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func quietHoursAction(acc *AccountConfig) string {
+	if acc.QuietHours != nil && acc.QuietHours.Action != "" {
+		return acc.QuietHours.Action
+	}
+	if config.QuietHours != nil && config.QuietHours.Action != "" {
+		return config.QuietHours.Action
+	}
+	return "summarize"
+}
+
+func isVIP(acc *AccountConfig, senders []senderAddress, subject string) bool {
+	subjectLower := strings.ToLower(subject)
+
+	for _, qh := range []*QuietHours{config.QuietHours, acc.QuietHours} {
+		if qh == nil {
+			continue
+		}
+		if _, ok := findSenderPattern(qh.VIPSenders, senders, acc.NormalizePlusTags); ok {
+			return true
+		}
+		for _, keyword := range qh.VIPKeywords {
+			if strings.Contains(subjectLower, strings.ToLower(keyword)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// holdNotification returns true if the notification was held back or
+// dropped because the account is in a quiet period. Critical messages always
+// break through.
+func holdNotification(acc *AccountConfig, folder, sender string, senders []senderAddress, subject, priority string) bool {
+	now := time.Now()
+	if !isQuietTime(acc, now) || isVIP(acc, senders, subject) || priority == "critical" {
+		return false
+	}
+
+	if quietHoursAction(acc) == "drop" {
+		acc.logger().Info("Quiet hours - dropped notification", "folder", folder, "sender", sender)
+		return true
+	}
+
+	acc.mu.Lock()
+	acc.heldNotifications = append(acc.heldNotifications, heldNotification{
+		Folder:  folder,
+		Sender:  sender,
+		Subject: subject,
+		Time:    now,
+	})
+	acc.mu.Unlock()
+
+	acc.logger().Info("Quiet hours - held notification", "folder", folder, "sender", sender)
+	return true
+}
+
+func releaseHeldNotifications(acc *AccountConfig) {
+	if isQuietTime(acc, time.Now()) {
+		return
+	}
+
+	acc.mu.Lock()
+	held := acc.heldNotifications
+	acc.heldNotifications = nil
+	acc.mu.Unlock()
+
+	if len(held) == 0 {
+		return
+	}
+
+	var lines []string
+	for i, n := range held {
+		if i == 5 {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(held)-i))
+			break
+		}
+		if n.Sender == "" {
+			lines = append(lines, n.Subject)
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: %s", n.Sender, n.Subject))
+		}
+	}
+
+	title := fmt.Sprintf("📧 %s - %d while quiet", acc.Email, len(held))
+	sendDesktopNotification(acc, title, strings.Join(lines, "\n"), "normal")
+
+	acc.logger().Info("Quiet hours ended - summarized held notifications", "count", len(held))
+}
+
+func runQuietHoursLoop() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, id := range accountIDs() {
+			withAccount(id, releaseHeldNotifications)
+		}
+	}
+}
+
+func setupDoNotDisturbMenu() {
+	mDND := systray.AddMenuItem("🔕 Do Not Disturb", "Pause notifications")
+	m30 := mDND.AddSubMenuItem("For 30 minutes", "")
+	m60 := mDND.AddSubMenuItem("For 1 hour", "")
+	m240 := mDND.AddSubMenuItem("For 4 hours", "")
+	mOff := mDND.AddSubMenuItem("Resume notifications", "")
+
+	go func() {
+		for {
+			select {
+			case <-m30.ClickedCh:
+				setDoNotDisturb(30 * time.Minute)
+			case <-m60.ClickedCh:
+				setDoNotDisturb(time.Hour)
+			case <-m240.ClickedCh:
+				setDoNotDisturb(4 * time.Hour)
+			case <-mOff.ClickedCh:
+				setDoNotDisturb(0)
+			}
+		}
+	}()
+}
+
+func handleDoNotDisturb(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Minutes int `json:"minutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		setDoNotDisturb(time.Duration(req.Minutes) * time.Minute)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dndMu.RLock()
+	until := dndUntil
+	dndMu.RUnlock()
+
+	resp := map[string]interface{}{
+		"active": time.Now().Before(until),
+		"until":  "",
+	}
+	if time.Now().Before(until) {
+		resp["until"] = until.Format("15:04:05")
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestIsVIPSenderPatterns(t *testing.T) {
+	saved := config.QuietHours
+	t.Cleanup(func() { config.QuietHours = saved })
+	config.QuietHours = &QuietHours{VIPSenders: []string{"@pager.example.com", "On-Call"}}
+
+	acc := &AccountConfig{
+		NormalizePlusTags: true,
+		QuietHours:        &QuietHours{VIPSenders: []string{"boss@example.com", "*.alerts.example.net"}},
+	}
+	tests := []struct {
+		senders []senderAddress
+		want    bool
+	}{
+		{[]senderAddress{{Address: "x@pager.example.com"}}, true},
+		{[]senderAddress{{Name: "On-Call Bot", Address: "bot@example.org"}}, true},
+		{[]senderAddress{{Address: "boss+urgent@example.com"}}, true},
+		{[]senderAddress{{Address: "noreply@example.org"}, {Address: "ops@eu.alerts.example.net"}}, true},
+		{[]senderAddress{{Address: "someone@example.com"}}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isVIP(acc, tt.senders, "hello"); got != tt.want {
+			t.Errorf("isVIP(%+v) = %v, want %v", tt.senders, got, tt.want)
+		}
+	}
+
+	if err := validateQuietHours(&QuietHours{VIPSenders: []string{"[a-"}}); err == nil {
+		t.Error("invalid VIP pattern accepted")
+	}
+}
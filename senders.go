@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap"
+)
+
+// senderAddress is one address from the From, Sender or Reply-To header.
+type senderAddress struct {
+	Name    string
+	Address string
+}
+
+// maxSenderPatternCache bounds senderPatternCache. Patterns tried in the
+// filter preview are matched too, so the cache is emptied when it is full;
+// saved patterns are compiled again on their next use.
+const maxSenderPatternCache = 256
+
+var (
+	// senderPatternCache holds compiled /regex/ patterns, keyed by the
+	// pattern as written in the config.
+	senderPatternCache   = make(map[string]*regexp.Regexp)
+	senderPatternCacheMu sync.Mutex
+)
+
+func imapSenders(env *imap.Envelope) []senderAddress {
+	var senders []senderAddress
+	for _, list := range [][]*imap.Address{env.From, env.Sender, env.ReplyTo} {
+		for _, a := range list {
+			senders = append(senders, senderAddress{Name: a.PersonalName, Address: a.Address()})
+		}
+	}
+	return senders
+}
+
+// messageSenders returns the senders sender patterns are matched against,
+// falling back to the From address.
+func messageSenders(m matchedMessage) []senderAddress {
+	if len(m.Senders) > 0 {
+		return m.Senders
+	}
+	if m.FromAddress == "" && m.FromName == "" {
+		return nil
+	}
+	return []senderAddress{{Name: m.FromName, Address: m.FromAddress}}
+}
+
+func headerSenders(header func(string) string) []senderAddress {
+	var senders []senderAddress
+	for _, key := range []string{"From", "Sender", "Reply-To"} {
+		value := header(key)
+		if value == "" {
+			continue
+		}
+		addrs, err := mail.ParseAddressList(value)
+		if err != nil {
+			// Keep malformed headers usable, like extractEmailAddress does.
+			senders = append(senders, senderAddress{Address: extractEmailAddress(value)})
+			continue
+		}
+		for _, a := range addrs {
+			senders = append(senders, senderAddress{Name: a.Name, Address: a.Address})
+		}
+	}
+	return senders
+}
+
+// stripPlusTag removes a "+tag" from the local part, so that
+// "user+news@example.com" becomes "user@example.com".
+func stripPlusTag(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return address
+	}
+	if plus := strings.Index(address[:at], "+"); plus >= 0 {
+		return address[:plus] + address[at:]
+	}
+	return address
+}
+
+func isRegexPattern(pattern string) bool {
+	return len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+func isGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+func senderRegexp(pattern string) (*regexp.Regexp, error) {
+	senderPatternCacheMu.Lock()
+	re, ok := senderPatternCache[pattern]
+	senderPatternCacheMu.Unlock()
+	if ok {
+		return re, nil
+	}
+
+	re, err := compileSenderRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	senderPatternCacheMu.Lock()
+	if len(senderPatternCache) >= maxSenderPatternCache {
+		clear(senderPatternCache)
+	}
+	senderPatternCache[pattern] = re
+	senderPatternCacheMu.Unlock()
+	return re, nil
+}
+
+func compileSenderRegexp(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+}
+
+// validateSenderPatterns checks the regex and glob syntax of include_email
+// and exclude_email entries.
+func validateSenderPatterns(patterns []string) error {
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		switch {
+		case isRegexPattern(p):
+			if _, err := compileSenderRegexp(p); err != nil {
+				return fmt.Errorf("sender pattern %s: %v", p, err)
+			}
+		case isGlobPattern(p):
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("sender pattern %s: %v", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matchSender reports whether a sender matches a pattern:
+//
+//	user@example.com    the address
+//	@example.com        any address at the domain
+//	*.example.com       any address at the domain or a subdomain
+//	noreply-*@*.com     a glob; without an "@" it may also match the display name
+//	/^billing@/         a case-insensitive regex against the address or display name
+//	GitHub              text contained in the display name
+//
+// With normalizePlus, plus tags are ignored on both sides.
+func matchSender(pattern string, s senderAddress, normalizePlus bool) bool {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return false
+	}
+	address := strings.ToLower(s.Address)
+	if normalizePlus {
+		address = stripPlusTag(address)
+	}
+	domain := senderDomain(address)
+
+	switch {
+	case isRegexPattern(pattern):
+		re, err := senderRegexp(pattern)
+		if err != nil {
+			return false
+		}
+		return (address != "" && re.MatchString(address)) || (s.Name != "" && re.MatchString(s.Name))
+	case strings.HasPrefix(pattern, "*.") && !isGlobPattern(pattern[2:]):
+		suffix := strings.ToLower(pattern[2:])
+		return domain != "" && (domain == suffix || strings.HasSuffix(domain, "."+suffix))
+	case isGlobPattern(pattern):
+		pattern = strings.ToLower(pattern)
+		if ok, _ := path.Match(pattern, address); ok && address != "" {
+			return true
+		}
+		if strings.Contains(pattern, "@") || s.Name == "" {
+			return false
+		}
+		ok, _ := path.Match(pattern, strings.ToLower(s.Name))
+		return ok
+	case strings.HasPrefix(pattern, "@"):
+		return domain != "" && strings.EqualFold(domain, pattern[1:])
+	case strings.Contains(pattern, "@"):
+		pattern = strings.ToLower(pattern)
+		if normalizePlus {
+			pattern = stripPlusTag(pattern)
+		}
+		return address == pattern
+	}
+	return s.Name != "" && strings.Contains(strings.ToLower(s.Name), strings.ToLower(pattern))
+}
+
+// findSenderPattern returns the first pattern matching any of the senders.
+func findSenderPattern(patterns []string, senders []senderAddress, normalizePlus bool) (string, bool) {
+	for _, p := range patterns {
+		for _, s := range senders {
+			if matchSender(p, s, normalizePlus) {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMatchSender(t *testing.T) {
+	github := senderAddress{Name: "GitHub", Address: "Notifications@GitHub.com"}
+	tagged := senderAddress{Name: "Jane Doe", Address: "jane+news@example.com"}
+	sub := senderAddress{Address: "alerts@eu.mail.example.com"}
+	bare := senderAddress{Address: "noreply-billing@shop.com"}
+
+	tests := []struct {
+		pattern       string
+		sender        senderAddress
+		normalizePlus bool
+		want          bool
+	}{
+		{"notifications@github.com", github, false, true},
+		{" notifications@github.com ", github, false, true},
+		{"other@github.com", github, false, false},
+		{"jane@example.com", tagged, false, false},
+		{"jane@example.com", tagged, true, true},
+		{"jane+other@example.com", tagged, true, true},
+		{"@github.com", github, false, true},
+		{"@example.com", sub, false, false},
+		{"*.example.com", sub, false, true},
+		{"*.example.com", tagged, false, true},
+		{"*.ample.com", tagged, false, false},
+		{"noreply-*@*.com", bare, false, true},
+		{"noreply-*@*.com", github, false, false},
+		{"git*", github, false, true},
+		{"jane*", tagged, false, true},
+		{"jane*@*", senderAddress{Name: "jane", Address: "doe@example.com"}, false, false},
+		{"/^notifications@/", github, false, true},
+		{"/^git/", github, false, true},
+		{"/^billing@/", bare, false, false},
+		{"/([/", github, false, false},
+		{"Hub", github, false, true},
+		{"hub", senderAddress{Address: "hub@example.com"}, false, false},
+		{"", github, false, false},
+	}
+	for _, tt := range tests {
+		if got := matchSender(tt.pattern, tt.sender, tt.normalizePlus); got != tt.want {
+			t.Errorf("matchSender(%q, %+v, %v) = %v, want %v", tt.pattern, tt.sender, tt.normalizePlus, got, tt.want)
+		}
+	}
+}
+
+func TestFindSenderPattern(t *testing.T) {
+	header := map[string]string{
+		"From":     `"Jira" <jira@atlassian.net>`,
+		"Reply-To": "team+jira@example.com, Ops <ops@example.com>",
+	}
+	senders := headerSenders(func(key string) string { return header[key] })
+	if len(senders) != 3 {
+		t.Fatalf("headerSenders = %+v, want 3 senders", senders)
+	}
+
+	tests := []struct {
+		patterns []string
+		want     string
+		found    bool
+	}{
+		{[]string{"@example.org", "Ops"}, "Ops", true},
+		{[]string{"team@example.com", "@atlassian.net"}, "team@example.com", true},
+		{[]string{"@example.org"}, "", false},
+	}
+	for _, tt := range tests {
+		got, found := findSenderPattern(tt.patterns, senders, true)
+		if got != tt.want || found != tt.found {
+			t.Errorf("findSenderPattern(%q) = %q, %v, want %q, %v", tt.patterns, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestValidateSenderPatterns(t *testing.T) {
+	if err := validateSenderPatterns([]string{"@example.com", "*.example.com", "/^a+$/", "GitHub"}); err != nil {
+		t.Errorf("valid patterns rejected: %v", err)
+	}
+	for _, p := range []string{"/([/", "[a-"} {
+		if err := validateSenderPatterns([]string{p}); err == nil {
+			t.Errorf("validateSenderPatterns(%q) accepted", p)
+		}
+	}
+}
+
+func TestSenderPatternCache(t *testing.T) {
+	cached := func() int {
+		senderPatternCacheMu.Lock()
+		defer senderPatternCacheMu.Unlock()
+		return len(senderPatternCache)
+	}
+	before := cached()
+	for i := range 50 {
+		if err := validateSenderPatterns([]string{fmt.Sprintf("/^draft%d@/", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := cached(); n != before {
+		t.Errorf("validation cached %d patterns", n-before)
+	}
+
+	for i := range 2 * maxSenderPatternCache {
+		matchSender(fmt.Sprintf("/^preview%d@/", i), senderAddress{Address: "a@example.com"}, false)
+	}
+	if n := cached(); n > maxSenderPatternCache {
+		t.Errorf("%d patterns cached, want at most %d", n, maxSenderPatternCache)
+	}
+}
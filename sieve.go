@@ -0,0 +1,1011 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Notification rules can be written as a Sieve script (RFC 5228) with the
+// envelope, body (RFC 5173), regex, fileinto, imap4flags, copy and enotify
+// (RFC 5435) extensions. "keep" and "notify" notify about the message;
+// "discard", "fileinto", "redirect" and "reject" cancel the implicit keep, so
+// the message is not notified unless it was also kept. Flag actions have no
+// effect.
+
+var sieveExtensions = map[string]bool{
+	"fileinto": true, "envelope": true, "body": true, "regex": true, "enotify": true,
+	"imap4flags": true, "reject": true, "copy": true, "comparator-i;octet": true, "comparator-i;ascii-casemap": true,
+}
+
+// sieveScriptCache holds the parsed script of each saved account, keyed by
+// the account ID, and is replaced when the script changes. Drafts checked by
+// the editor or the filter preview are parsed without it, so they are not
+// kept.
+var sieveScriptCache sync.Map
+
+type cachedSieveScript struct {
+	src    string
+	script *sieveScript
+}
+
+type sieveError struct {
+	Line int
+	Msg  string
+}
+
+func (e *sieveError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+func sieveErrorf(line int, format string, args ...interface{}) error {
+	return &sieveError{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Lexer
+
+const (
+	sieveIdent = iota
+	sieveTag
+	sieveNumber
+	sieveString
+	sievePunct
+	sieveEOF
+)
+
+type sieveToken struct {
+	kind int
+	text string
+	num  int64
+	line int
+}
+
+func lexSieve(src string) ([]sieveToken, error) {
+	var tokens []sieveToken
+	line := 1
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, sieveErrorf(line, "unterminated comment")
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"':
+			var b strings.Builder
+			start := line
+			i++
+			for {
+				if i >= len(src) {
+					return nil, sieveErrorf(start, "unterminated string")
+				}
+				if src[i] == '"' {
+					i++
+					break
+				}
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				if src[i] == '\n' {
+					line++
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			tokens = append(tokens, sieveToken{kind: sieveString, text: b.String(), line: start})
+		case strings.HasPrefix(strings.ToLower(src[i:]), "text:"):
+			// Multi-line string: everything after the rest of this line up to
+			// a line containing only ".", with leading ".." unstuffed.
+			start := line
+			eol := strings.Index(src[i:], "\n")
+			if eol < 0 {
+				return nil, sieveErrorf(line, "unterminated multi-line string")
+			}
+			i += eol + 1
+			line++
+			var lines []string
+			for {
+				if i >= len(src) {
+					return nil, sieveErrorf(start, "unterminated multi-line string")
+				}
+				end := strings.Index(src[i:], "\n")
+				if end < 0 {
+					end = len(src) - i
+				}
+				l := strings.TrimSuffix(src[i:i+end], "\r")
+				i += end + 1
+				line++
+				if l == "." {
+					break
+				}
+				lines = append(lines, strings.TrimPrefix(l, "."))
+			}
+			text := strings.Join(lines, "\r\n")
+			if len(lines) > 0 {
+				text += "\r\n"
+			}
+			tokens = append(tokens, sieveToken{kind: sieveString, text: text, line: start})
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && src[j] >= '0' && src[j] <= '9' {
+				j++
+			}
+			n, err := strconv.ParseInt(src[i:j], 10, 64)
+			if err != nil {
+				return nil, sieveErrorf(line, "invalid number %s", src[i:j])
+			}
+			if j < len(src) {
+				switch src[j] {
+				case 'K', 'k':
+					n <<= 10
+					j++
+				case 'M', 'm':
+					n <<= 20
+					j++
+				case 'G', 'g':
+					n <<= 30
+					j++
+				}
+			}
+			tokens = append(tokens, sieveToken{kind: sieveNumber, text: src[i:j], num: n, line: line})
+			i = j
+		case c == ':' || isSieveIdentChar(c, true):
+			j := i
+			if c == ':' {
+				j++
+			}
+			for j < len(src) && isSieveIdentChar(src[j], j == i || (c == ':' && j == i+1)) {
+				j++
+			}
+			if c == ':' && j == i+1 {
+				return nil, sieveErrorf(line, "expected a tag name after \":\"")
+			}
+			kind := sieveIdent
+			if c == ':' {
+				kind = sieveTag
+			}
+			tokens = append(tokens, sieveToken{kind: kind, text: strings.ToLower(src[i:j]), line: line})
+			i = j
+		case strings.ContainsRune(";,[]{}()", rune(c)):
+			tokens = append(tokens, sieveToken{kind: sievePunct, text: string(c), line: line})
+			i++
+		default:
+			return nil, sieveErrorf(line, "unexpected character %q", c)
+		}
+	}
+	return append(tokens, sieveToken{kind: sieveEOF, line: line}), nil
+}
+
+func isSieveIdentChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// Parser: a generic tree of commands and tests, checked by compileSieve.
+
+type sieveArg struct {
+	tag  string   // ":is", for tagged arguments
+	strs []string // a string or a string list
+	num  int64
+	kind int // sieveTag, sieveString or sieveNumber
+	list bool
+	line int
+}
+
+type sieveNode struct {
+	name  string
+	line  int
+	args  []sieveArg
+	tests []*sieveNode
+	block []*sieveNode
+	// hasBlock distinguishes "if x {}" from a command without a block.
+	hasBlock bool
+}
+
+type sieveParser struct {
+	tokens []sieveToken
+	pos    int
+}
+
+func (p *sieveParser) peek() sieveToken { return p.tokens[p.pos] }
+
+func (p *sieveParser) next() sieveToken {
+	t := p.tokens[p.pos]
+	if t.kind != sieveEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *sieveParser) isPunct(s string) bool {
+	t := p.peek()
+	return t.kind == sievePunct && t.text == s
+}
+
+func (p *sieveParser) expect(s string) error {
+	t := p.next()
+	if t.kind != sievePunct || t.text != s {
+		return sieveErrorf(t.line, "expected %q, found %s", s, describeSieveToken(t))
+	}
+	return nil
+}
+
+func describeSieveToken(t sieveToken) string {
+	switch t.kind {
+	case sieveEOF:
+		return "end of script"
+	case sieveString:
+		return "a string"
+	}
+	return strconv.Quote(t.text)
+}
+
+func (p *sieveParser) commands(inBlock bool) ([]*sieveNode, error) {
+	var cmds []*sieveNode
+	for {
+		t := p.peek()
+		if t.kind == sieveEOF {
+			if inBlock {
+				return nil, sieveErrorf(t.line, "missing \"}\"")
+			}
+			return cmds, nil
+		}
+		if inBlock && p.isPunct("}") {
+			return cmds, nil
+		}
+		if t.kind != sieveIdent {
+			return nil, sieveErrorf(t.line, "expected a command, found %s", describeSieveToken(t))
+		}
+		p.next()
+		cmd := &sieveNode{name: t.text, line: t.line}
+		if err := p.arguments(cmd); err != nil {
+			return nil, err
+		}
+		if p.isPunct("{") {
+			p.next()
+			block, err := p.commands(true)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("}"); err != nil {
+				return nil, err
+			}
+			cmd.block, cmd.hasBlock = block, true
+		} else if err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+}
+
+// arguments parses tagged, string, list and number arguments followed by an
+// optional test or test list.
+func (p *sieveParser) arguments(n *sieveNode) error {
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == sieveTag:
+			p.next()
+			n.args = append(n.args, sieveArg{tag: t.text, kind: sieveTag, line: t.line})
+		case t.kind == sieveNumber:
+			p.next()
+			n.args = append(n.args, sieveArg{num: t.num, kind: sieveNumber, line: t.line})
+		case t.kind == sieveString:
+			p.next()
+			n.args = append(n.args, sieveArg{strs: []string{t.text}, kind: sieveString, line: t.line})
+		case p.isPunct("["):
+			p.next()
+			arg := sieveArg{kind: sieveString, list: true, line: t.line}
+			for {
+				s := p.next()
+				if s.kind != sieveString {
+					return sieveErrorf(s.line, "expected a string in list, found %s", describeSieveToken(s))
+				}
+				arg.strs = append(arg.strs, s.text)
+				if p.isPunct("]") {
+					p.next()
+					break
+				}
+				if err := p.expect(","); err != nil {
+					return err
+				}
+			}
+			n.args = append(n.args, arg)
+		case t.kind == sieveIdent:
+			test, err := p.test()
+			if err != nil {
+				return err
+			}
+			n.tests = []*sieveNode{test}
+			return nil
+		case p.isPunct("("):
+			p.next()
+			for {
+				test, err := p.test()
+				if err != nil {
+					return err
+				}
+				n.tests = append(n.tests, test)
+				if p.isPunct(")") {
+					p.next()
+					break
+				}
+				if err := p.expect(","); err != nil {
+					return err
+				}
+			}
+			return nil
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *sieveParser) test() (*sieveNode, error) {
+	t := p.next()
+	if t.kind != sieveIdent {
+		return nil, sieveErrorf(t.line, "expected a test, found %s", describeSieveToken(t))
+	}
+	n := &sieveNode{name: t.text, line: t.line}
+	return n, p.arguments(n)
+}
+
+// Compiled script
+
+type sieveScript struct {
+	commands  []*sieveCommand
+	usesBody  bool
+	usesParts bool // tests the attachment or invite headers
+}
+
+type sieveCommand struct {
+	name     string // "if", "keep", "discard", "stop", "notify", "fileinto", "redirect", "reject" or "flag"
+	line     int
+	branches []sieveBranch
+	arg      string // fileinto folder, redirect address or notify method
+	copy     bool
+}
+
+// sieveBranch is an if, elsif or else (cond is nil) block.
+type sieveBranch struct {
+	cond  *sieveTest
+	block []*sieveCommand
+}
+
+type sieveTest struct {
+	name        string
+	children    []*sieveTest
+	matchType   string // ":is", ":contains", ":matches" or ":regex"
+	octet       bool   // i;octet comparator instead of i;ascii-casemap
+	addressPart string // ":all", ":localpart" or ":domain"
+	names       []string
+	keys        []string
+	patterns    []*regexp.Regexp // compiled keys for :matches and :regex
+	over        bool
+	size        int64
+}
+
+func parseSieve(src string) (*sieveScript, error) {
+	tokens, err := lexSieve(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &sieveParser{tokens: tokens}
+	nodes, err := p.commands(false)
+	if err != nil {
+		return nil, err
+	}
+
+	script := &sieveScript{}
+	c := &sieveCompiler{script: script, required: map[string]bool{}}
+	if script.commands, err = c.commands(nodes, true); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+// accountSieve returns the parsed script of an account. Accounts without an
+// ID, such as the proposal of a filter preview, are parsed every time.
+func accountSieve(acc *AccountConfig) (*sieveScript, error) {
+	if acc.ID != "" {
+		if c, ok := sieveScriptCache.Load(acc.ID); ok && c.(cachedSieveScript).src == acc.SieveScript {
+			return c.(cachedSieveScript).script, nil
+		}
+	}
+	script, err := parseSieve(acc.SieveScript)
+	if err == nil && acc.ID != "" {
+		sieveScriptCache.Store(acc.ID, cachedSieveScript{src: acc.SieveScript, script: script})
+	}
+	return script, err
+}
+
+type sieveCompiler struct {
+	script   *sieveScript
+	required map[string]bool
+}
+
+func (c *sieveCompiler) require(line int, ext string) error {
+	if !c.required[ext] {
+		return sieveErrorf(line, "%q requires require \"%s\"", ext, ext)
+	}
+	return nil
+}
+
+func (c *sieveCompiler) commands(nodes []*sieveNode, top bool) ([]*sieveCommand, error) {
+	var cmds []*sieveCommand
+	requireAllowed := top
+	for i, n := range nodes {
+		if n.name != "require" {
+			requireAllowed = false
+		}
+		if n.hasBlock && n.name != "if" && n.name != "elsif" && n.name != "else" {
+			return nil, sieveErrorf(n.line, "%s does not take a block", n.name)
+		}
+
+		switch n.name {
+		case "require":
+			if !requireAllowed {
+				return nil, sieveErrorf(n.line, "require must come before other commands")
+			}
+			if len(n.args) != 1 || n.args[0].kind != sieveString || len(n.tests) > 0 {
+				return nil, sieveErrorf(n.line, "require expects a string list")
+			}
+			for _, ext := range n.args[0].strs {
+				if !sieveExtensions[ext] {
+					return nil, sieveErrorf(n.line, "unsupported extension %q", ext)
+				}
+				c.required[ext] = true
+			}
+
+		case "if", "elsif", "else":
+			if !n.hasBlock {
+				return nil, sieveErrorf(n.line, "%s needs a block", n.name)
+			}
+			var cond *sieveTest
+			if n.name == "else" {
+				if len(n.args) > 0 || len(n.tests) > 0 {
+					return nil, sieveErrorf(n.line, "else does not take a test")
+				}
+			} else {
+				if len(n.args) > 0 || len(n.tests) != 1 {
+					return nil, sieveErrorf(n.line, "%s expects one test", n.name)
+				}
+				var err error
+				if cond, err = c.test(n.tests[0]); err != nil {
+					return nil, err
+				}
+			}
+			block, err := c.commands(n.block, false)
+			if err != nil {
+				return nil, err
+			}
+			branch := sieveBranch{cond: cond, block: block}
+
+			if n.name == "if" {
+				cmds = append(cmds, &sieveCommand{name: "if", line: n.line, branches: []sieveBranch{branch}})
+				continue
+			}
+			var prev *sieveCommand
+			if i > 0 && len(cmds) > 0 && (nodes[i-1].name == "if" || nodes[i-1].name == "elsif") {
+				prev = cmds[len(cmds)-1]
+			}
+			if prev == nil || prev.name != "if" {
+				return nil, sieveErrorf(n.line, "%s without if", n.name)
+			}
+			prev.branches = append(prev.branches, branch)
+
+		case "keep", "discard", "stop":
+			if len(n.args) > 0 || len(n.tests) > 0 {
+				return nil, sieveErrorf(n.line, "%s does not take arguments", n.name)
+			}
+			cmds = append(cmds, &sieveCommand{name: n.name, line: n.line})
+
+		case "fileinto", "redirect", "reject", "notify":
+			ext := n.name
+			if ext == "redirect" {
+				ext = ""
+			} else if ext == "notify" {
+				ext = "enotify"
+			}
+			if ext != "" {
+				if err := c.require(n.line, ext); err != nil {
+					return nil, err
+				}
+			}
+			cmd := &sieveCommand{name: n.name, line: n.line}
+			if err := c.actionArgs(n, cmd); err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, cmd)
+
+		case "setflag", "addflag", "removeflag":
+			if err := c.require(n.line, "imap4flags"); err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, &sieveCommand{name: "flag", line: n.line})
+
+		default:
+			return nil, sieveErrorf(n.line, "unknown command %q", n.name)
+		}
+	}
+	return cmds, nil
+}
+
+// actionArgs checks the arguments of fileinto, redirect, reject and notify
+// and keeps the one that is shown in the reason.
+func (c *sieveCompiler) actionArgs(n *sieveNode, cmd *sieveCommand) error {
+	if len(n.tests) > 0 {
+		return sieveErrorf(n.line, "%s does not take a test", n.name)
+	}
+	var positional []string
+	for i := 0; i < len(n.args); i++ {
+		a := n.args[i]
+		switch {
+		case a.kind == sieveString:
+			if a.list {
+				return sieveErrorf(a.line, "%s expects a string, not a list", n.name)
+			}
+			positional = append(positional, a.strs[0])
+		case a.tag == ":copy" && (n.name == "fileinto" || n.name == "redirect"):
+			if err := c.require(a.line, "copy"); err != nil {
+				return err
+			}
+			cmd.copy = true
+		case a.tag == ":flags" && n.name == "fileinto":
+			if err := c.require(a.line, "imap4flags"); err != nil {
+				return err
+			}
+			if i+1 >= len(n.args) || n.args[i+1].kind != sieveString {
+				return sieveErrorf(a.line, ":flags expects a string list")
+			}
+			i++
+		case n.name == "notify" && (a.tag == ":from" || a.tag == ":importance" || a.tag == ":message"):
+			if i+1 >= len(n.args) || n.args[i+1].kind != sieveString || n.args[i+1].list {
+				return sieveErrorf(a.line, "%s expects a string", a.tag)
+			}
+			i++
+		case n.name == "notify" && a.tag == ":options":
+			if i+1 >= len(n.args) || n.args[i+1].kind != sieveString {
+				return sieveErrorf(a.line, ":options expects a string list")
+			}
+			i++
+		default:
+			return sieveErrorf(a.line, "unexpected argument for %s", n.name)
+		}
+	}
+	if len(positional) != 1 {
+		return sieveErrorf(n.line, "%s expects one string argument", n.name)
+	}
+	cmd.arg = positional[0]
+	return nil
+}
+
+func (c *sieveCompiler) test(n *sieveNode) (*sieveTest, error) {
+	t := &sieveTest{name: n.name, matchType: ":is", addressPart: ":all"}
+
+	switch n.name {
+	case "true", "false":
+		if len(n.args) > 0 || len(n.tests) > 0 {
+			return nil, sieveErrorf(n.line, "%s does not take arguments", n.name)
+		}
+		return t, nil
+	case "not", "allof", "anyof":
+		if len(n.args) > 0 || len(n.tests) == 0 || (n.name == "not" && len(n.tests) != 1) {
+			return nil, sieveErrorf(n.line, "%s expects a test", n.name)
+		}
+		for _, child := range n.tests {
+			ct, err := c.test(child)
+			if err != nil {
+				return nil, err
+			}
+			t.children = append(t.children, ct)
+		}
+		return t, nil
+	case "header", "address", "envelope", "exists", "size", "body":
+	default:
+		return nil, sieveErrorf(n.line, "unknown test %q", n.name)
+	}
+	if len(n.tests) > 0 {
+		return nil, sieveErrorf(n.line, "%s does not take a test", n.name)
+	}
+	if n.name == "envelope" || n.name == "body" {
+		if err := c.require(n.line, n.name); err != nil {
+			return nil, err
+		}
+	}
+	if n.name == "body" {
+		c.script.usesBody = true
+	}
+
+	var positional [][]string
+	for i := 0; i < len(n.args); i++ {
+		a := n.args[i]
+		switch {
+		case a.kind == sieveString:
+			positional = append(positional, a.strs)
+		case a.kind == sieveNumber:
+			if n.name != "size" {
+				return nil, sieveErrorf(a.line, "unexpected number")
+			}
+			t.size = a.num
+		case a.tag == ":is" || a.tag == ":contains" || a.tag == ":matches" || a.tag == ":regex":
+			if n.name == "exists" || n.name == "size" {
+				return nil, sieveErrorf(a.line, "%s does not take %s", n.name, a.tag)
+			}
+			if a.tag == ":regex" {
+				if err := c.require(a.line, "regex"); err != nil {
+					return nil, err
+				}
+			}
+			t.matchType = a.tag
+		case a.tag == ":comparator":
+			if i+1 >= len(n.args) || n.args[i+1].kind != sieveString || n.args[i+1].list {
+				return nil, sieveErrorf(a.line, ":comparator expects a string")
+			}
+			i++
+			switch n.args[i].strs[0] {
+			case "i;ascii-casemap":
+				t.octet = false
+			case "i;octet":
+				t.octet = true
+			default:
+				return nil, sieveErrorf(a.line, "unsupported comparator %q", n.args[i].strs[0])
+			}
+		case (a.tag == ":all" || a.tag == ":localpart" || a.tag == ":domain") && (n.name == "address" || n.name == "envelope"):
+			t.addressPart = a.tag
+		case (a.tag == ":over" || a.tag == ":under") && n.name == "size":
+			t.over = a.tag == ":over"
+		case (a.tag == ":text" || a.tag == ":raw") && n.name == "body":
+		case a.tag == ":content" && n.name == "body":
+			if i+1 >= len(n.args) || n.args[i+1].kind != sieveString {
+				return nil, sieveErrorf(a.line, ":content expects a string list")
+			}
+			i++
+		default:
+			return nil, sieveErrorf(a.line, "unexpected argument %s for %s", a.tag, n.name)
+		}
+	}
+
+	want := 2
+	switch n.name {
+	case "exists", "body":
+		want = 1
+	case "size":
+		want = 0
+	}
+	if len(positional) != want {
+		return nil, sieveErrorf(n.line, "%s expects %d string arguments", n.name, want)
+	}
+	switch n.name {
+	case "exists":
+		t.names = positional[0]
+	case "body":
+		t.keys = positional[0]
+	case "size":
+		hasSize := false
+		for _, a := range n.args {
+			hasSize = hasSize || a.kind == sieveNumber
+		}
+		if !hasSize {
+			return nil, sieveErrorf(n.line, "size expects :over or :under and a number")
+		}
+	default:
+		t.names, t.keys = positional[0], positional[1]
+	}
+	if n.name == "header" || n.name == "exists" {
+		for _, name := range t.names {
+			c.script.usesParts = c.script.usesParts || isPartsHeader(name)
+		}
+	}
+
+	for _, key := range t.keys {
+		var expr string
+		switch t.matchType {
+		case ":matches":
+			expr = sieveGlobToRegexp(key)
+		case ":regex":
+			expr = key
+		default:
+			continue
+		}
+		if !t.octet {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile("(?s)" + expr)
+		if err != nil {
+			return nil, sieveErrorf(n.line, "invalid pattern %q: %v", key, err)
+		}
+		t.patterns = append(t.patterns, re)
+	}
+	return t, nil
+}
+
+// sieveGlobToRegexp converts a :matches key, where "*" and "?" are wildcards
+// and "\" escapes the next character.
+func sieveGlobToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// Evaluation
+
+type sieveRun struct {
+	in           filterInput
+	keep         string // reason of the first keep or notify
+	cancel       string // reason of the first action that cancelled the implicit keep
+	implicitKeep bool
+}
+
+// evaluate runs the script and reports whether the message should notify,
+// with the action that decided it.
+func (s *sieveScript) evaluate(in filterInput) (bool, string) {
+	run := &sieveRun{in: in, implicitKeep: true}
+	run.commands(s.commands)
+
+	switch {
+	case run.keep != "":
+		return true, run.keep
+	case run.implicitKeep:
+		return true, "sieve: implicit keep"
+	}
+	return false, run.cancel
+}
+
+// commands returns false after stop.
+func (r *sieveRun) commands(cmds []*sieveCommand) bool {
+	for _, cmd := range cmds {
+		reason := fmt.Sprintf("sieve line %d: %s", cmd.line, cmd.name)
+		if cmd.arg != "" {
+			reason += " " + cmd.arg
+		}
+		switch cmd.name {
+		case "if":
+			for _, b := range cmd.branches {
+				if b.cond == nil || r.test(b.cond) {
+					if !r.commands(b.block) {
+						return false
+					}
+					break
+				}
+			}
+		case "stop":
+			return false
+		case "keep", "notify":
+			if r.keep == "" {
+				r.keep = reason
+			}
+		case "discard", "fileinto", "redirect", "reject":
+			if cmd.copy {
+				continue
+			}
+			if r.cancel == "" {
+				r.cancel = reason
+			}
+			r.implicitKeep = false
+		}
+	}
+	return true
+}
+
+func (r *sieveRun) test(t *sieveTest) bool {
+	switch t.name {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "not":
+		return !r.test(t.children[0])
+	case "allof":
+		for _, c := range t.children {
+			if !r.test(c) {
+				return false
+			}
+		}
+		return true
+	case "anyof":
+		for _, c := range t.children {
+			if r.test(c) {
+				return true
+			}
+		}
+		return false
+	case "exists":
+		for _, name := range t.names {
+			if len(r.in.headerValues(name)) == 0 {
+				return false
+			}
+		}
+		return true
+	case "size":
+		if t.over {
+			return r.in.Size > t.size
+		}
+		return r.in.Size < t.size
+	case "body":
+		return t.match(r.in.Body)
+	case "header":
+		for _, name := range t.names {
+			for _, v := range r.in.headerValues(name) {
+				if t.match(v) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	// address and envelope
+	names := t.names
+	if t.name == "envelope" {
+		names = nil
+		for _, part := range t.names {
+			switch strings.ToLower(part) {
+			case "from":
+				names = append(names, "Return-Path")
+			case "to":
+				names = append(names, "Delivered-To", "X-Original-To", "Envelope-To")
+			}
+		}
+	}
+	for _, name := range names {
+		for _, v := range r.in.headerValues(name) {
+			for _, addr := range sieveAddresses(v) {
+				if t.match(sieveAddressPart(addr, t.addressPart)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (t *sieveTest) match(value string) bool {
+	for i, key := range t.keys {
+		switch t.matchType {
+		case ":is":
+			if value == key || (!t.octet && strings.EqualFold(value, key)) {
+				return true
+			}
+		case ":contains":
+			if t.octet {
+				if strings.Contains(value, key) {
+					return true
+				}
+			} else if strings.Contains(strings.ToLower(value), strings.ToLower(key)) {
+				return true
+			}
+		default:
+			if t.patterns[i].MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headerValues returns the decoded values of a header.
+func (in filterInput) headerValues(name string) []string {
+	if values, ok := in.Parts.headerValues(name); ok {
+		return values
+	}
+	if strings.EqualFold(name, authHeaderName) {
+		v := assessSender(in)
+		return append([]string{v.Status}, v.Warnings...)
+	}
+	if in.Header == nil {
+		return nil
+	}
+	dec := new(mime.WordDecoder)
+	var values []string
+	for _, v := range in.Header(name) {
+		if decoded, err := dec.DecodeHeader(v); err == nil {
+			v = decoded
+		}
+		values = append(values, strings.TrimSpace(v))
+	}
+	return values
+}
+
+func sieveAddresses(value string) []string {
+	if addrs, err := mail.ParseAddressList(value); err == nil {
+		result := make([]string, len(addrs))
+		for i, a := range addrs {
+			result[i] = a.Address
+		}
+		return result
+	}
+	// Return-Path is "<addr>" and may be "<>".
+	return []string{extractEmailAddress(value)}
+}
+
+func sieveAddressPart(address, part string) string {
+	at := strings.LastIndex(address, "@")
+	switch {
+	case part == ":localpart" && at >= 0:
+		return address[:at]
+	case part == ":domain":
+		if at < 0 {
+			return ""
+		}
+		return address[at+1:]
+	}
+	return address
+}
+
+// sieveUsesBody reports whether the account's script needs the message text.
+func sieveUsesBody(acc *AccountConfig) bool {
+	if acc.SieveScript == "" {
+		return false
+	}
+	s, err := accountSieve(acc)
+	return err == nil && s.usesBody
+}
+
+func sieveUsesParts(acc *AccountConfig) bool {
+	if acc.SieveScript == "" {
+		return false
+	}
+	s, err := accountSieve(acc)
+	return err == nil && s.usesParts
+}
+
+// handleSieveValidate checks a script for the dashboard editor.
+func handleSieveValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Script string `json:"script"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := parseSieve(req.Script); err != nil {
+		result := map[string]interface{}{"success": false, "message": err.Error()}
+		if se, ok := err.(*sieveError); ok {
+			result["line"] = se.Line
+		}
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Script is valid"})
+}
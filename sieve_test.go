@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestParseSieveErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		line   int
+		msg    string
+	}{
+		{`if header :contains "subject" "x" { keep; }` + "\nfrobnicate;", 2, "frobnicate"},
+		{`if body :contains "x" { keep; }`, 1, `require "body"`},
+		{"require \"regex\";\nif header :regex \"subject\" \"(\" { keep; }", 2, "invalid pattern"},
+		{`if header :contains "subject" { keep; }`, 1, "expects 2 string arguments"},
+		{`if size :over { keep; }`, 1, "size expects"},
+		{"keep;\nrequire \"body\";", 2, "require"},
+		{`if not true false { keep; }`, 1, ""},
+		{`if header :comparator "i;unicode" "subject" "x" { keep; }`, 1, "unsupported comparator"},
+		{"keep", 1, ""},
+		{`notify "mailto:me@example.org";`, 1, `require "enotify"`},
+	}
+	for _, tt := range tests {
+		_, err := parseSieve(tt.script)
+		var serr *sieveError
+		if !errors.As(err, &serr) {
+			t.Errorf("parseSieve(%q) = %v, want a sieve error", tt.script, err)
+			continue
+		}
+		if serr.Line != tt.line || !strings.Contains(serr.Msg, tt.msg) {
+			t.Errorf("parseSieve(%q) = %v, want line %d containing %q", tt.script, err, tt.line, tt.msg)
+		}
+	}
+}
+
+func TestSieveEvaluate(t *testing.T) {
+	header := textproto.MIMEHeader{
+		"From":        {`"Alerts" <alerts@Monitoring.example.com>`},
+		"To":          {"me@example.org, team@example.org"},
+		"Subject":     {"=?UTF-8?Q?Server_down=3A_db1?="},
+		"List-Id":     {"<ops.example.com>"},
+		"Return-Path": {"<bounce@mailer.example.net>"},
+	}
+	in := filterInput{
+		Subject: "Server down: db1",
+		Header:  header.Values,
+		Body:    "The database server db1 stopped responding.",
+		Size:    2048,
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		notify bool
+		reason string
+	}{
+		{"empty script keeps", ``, true, "sieve: implicit keep"},
+		{"discard", `discard;`, false, "sieve line 1: discard"},
+		{"keep wins over discard", "discard;\nkeep;", true, "sieve line 2: keep"},
+		{"stop", "if true { stop; }\ndiscard;", true, "sieve: implicit keep"},
+		{"fileinto :copy keeps", `require ["fileinto", "copy"]; fileinto :copy "Ops";`, true, "sieve: implicit keep"},
+		{"decoded header :is is case-insensitive", `if header :is "subject" "server DOWN: db1" { keep; } else { discard; }`, true, "sieve line 1: keep"},
+		{"i;octet is case-sensitive", `if header :comparator "i;octet" :contains "subject" "DOWN" { keep; } else { discard; }`, false, "sieve line 1: discard"},
+		{"matches", `if header :matches "list-id" "*ops.*" { keep; } else { discard; }`, true, "sieve line 1: keep"},
+		{"regex", `require "regex"; if header :regex "subject" "db[0-9]+$" { keep; } else { discard; }`, true, "sieve line 1: keep"},
+		{"address :domain", `if address :domain :is "from" "monitoring.example.com" { keep; } else { discard; }`, true, "sieve line 1: keep"},
+		{"address :localpart of any recipient", `if address :localpart :is "to" "team" { keep; } else { discard; }`, true, "sieve line 1: keep"},
+		{"envelope from", `require "envelope"; if envelope :domain :is "from" "mailer.example.net" { keep; } else { discard; }`, true, "sieve line 1: keep"},
+		{"exists", `if exists ["list-id", "x-missing"] { keep; } else { discard; }`, false, "sieve line 1: discard"},
+		{"size :over", `if size :over 1K { keep; } else { discard; }`, true, "sieve line 1: keep"},
+		{"size :under", `if size :under 1K { keep; } else { discard; }`, false, "sieve line 1: discard"},
+		{"body", `require "body"; if body :contains "stopped responding" { keep; } else { discard; }`, true, "sieve line 1: keep"},
+		{"allof", `if allof (header :contains "subject" "down", not exists "x-missing") { keep; } else { discard; }`, true, "sieve line 1: keep"},
+		{"anyof", `if anyof (false, header :contains "subject" "up") { keep; } else { discard; }`, false, "sieve line 1: discard"},
+		{"elsif", "require \"enotify\"; if false { discard; }\nelsif header :contains \"subject\" \"db1\" { notify \"mailto:me@example.org\"; }\nelse { discard; }", true, "sieve line 2: notify"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSieve(tt.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notify, reason := s.evaluate(in)
+			if notify != tt.notify || !strings.HasPrefix(reason, tt.reason) {
+				t.Errorf("evaluate = %v, %q, want %v, %q", notify, reason, tt.notify, tt.reason)
+			}
+		})
+	}
+}
+
+func TestSieveScriptCache(t *testing.T) {
+	cached := func() int {
+		n := 0
+		sieveScriptCache.Range(func(any, any) bool { n++; return true })
+		return n
+	}
+	before := cached()
+
+	for i := range 20 {
+		body := fmt.Sprintf(`{"script": "if header :contains \"subject\" \"draft %d\" { keep; }"}`, i)
+		w := httptest.NewRecorder()
+		handleSieveValidate(w, httptest.NewRequest("POST", "/api/sieve/validate", strings.NewReader(body)))
+		if !strings.Contains(w.Body.String(), `"success":true`) {
+			t.Fatalf("draft %d rejected: %s", i, w.Body)
+		}
+	}
+	if n := cached(); n != before {
+		t.Errorf("validating drafts cached %d scripts", n-before)
+	}
+
+	acc := &AccountConfig{ID: "sieve-cache-test", SieveScript: `discard;`}
+	t.Cleanup(func() { sieveScriptCache.Delete(acc.ID) })
+	first, err := accountSieve(acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := accountSieve(acc); again != first {
+		t.Error("unchanged script parsed again")
+	}
+	acc.SieveScript = `keep;`
+	if ok, _ := filterMessage(acc, filterInput{}); !ok {
+		t.Error("changed script not used")
+	}
+	if n := cached(); n != before+1 {
+		t.Errorf("%d scripts cached for one account", n-before)
+	}
+}
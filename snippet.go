@@ -0,0 +1,124 @@
+package main
+
+import (
+	"html"
+	"io"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+)
+
+const (
+	snippetFetchBytes = 2048
+	snippetPOP3Lines  = 40
+	snippetLength     = 200
+)
+
+var (
+	htmlDropBlocks  = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlBreaks      = regexp.MustCompile(`(?i)<(br|/p|/div|/tr|/li|/h[1-6])[^>]*>`)
+	htmlTags        = regexp.MustCompile(`(?s)<[^>]*>`)
+	quoteHeaderLine = regexp.MustCompile(`(?i)^(on .+ wrote:|-+ ?original message ?-+|-+ ?forwarded message ?-+|_{10,})$`)
+	whitespaceRun   = regexp.MustCompile(`\s+`)
+)
+
+// messageTextFromHeader decodes a (possibly truncated) IMAP body section
+// using the message's Content-Type and Content-Transfer-Encoding headers.
+func messageTextFromHeader(header textproto.MIMEHeader, body io.Reader) string {
+	if body == nil {
+		return ""
+	}
+	e, err := message.New(message.HeaderFromMap(header), body)
+	if e == nil || (err != nil && !message.IsUnknownCharset(err)) {
+		return ""
+	}
+	return messageText(e)
+}
+
+// messageText returns the first text part as plain text. Snippets and Sieve
+// body tests use it.
+func messageText(e *message.Entity) string {
+	text, isHTML := findTextPart(e, 0)
+	if isHTML {
+		text = stripHTML(text)
+	}
+	return text
+}
+
+func snippetFromEntity(e *message.Entity) string {
+	return cleanSnippet(messageText(e))
+}
+
+// findTextPart returns the first text/plain part, or the first text/html part
+// if the message has no plain text.
+func findTextPart(e *message.Entity, depth int) (string, bool) {
+	if depth > 5 {
+		return "", false
+	}
+
+	if mr := e.MultipartReader(); mr != nil {
+		var htmlText string
+		for {
+			part, err := mr.NextPart()
+			if err != nil || part == nil {
+				break
+			}
+			text, isHTML := findTextPart(part, depth+1)
+			if text == "" {
+				continue
+			}
+			if !isHTML {
+				return text, false
+			}
+			if htmlText == "" {
+				htmlText = text
+			}
+		}
+		return htmlText, htmlText != ""
+	}
+
+	mediaType, _, _ := e.Header.ContentType()
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", false
+	}
+	if disp, _, _ := e.Header.ContentDisposition(); disp == "attachment" {
+		return "", false
+	}
+
+	// The section is truncated, so a decoding error near the end is
+	// expected; keep whatever was decoded before it.
+	data, _ := io.ReadAll(io.LimitReader(e.Body, snippetFetchBytes*2))
+	return string(data), mediaType == "text/html"
+}
+
+func stripHTML(s string) string {
+	s = htmlDropBlocks.ReplaceAllString(s, "")
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	s = htmlTags.ReplaceAllString(s, "")
+	return html.UnescapeString(s)
+}
+
+// cleanSnippet drops quoted replies and signatures, collapses whitespace and
+// bounds the result to snippetLength characters.
+func cleanSnippet(text string) string {
+	var kept []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "--" || quoteHeaderLine.MatchString(trimmed) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, trimmed)
+	}
+
+	snippet := strings.TrimSpace(whitespaceRun.ReplaceAllString(strings.Join(kept, " "), " "))
+	return strings.ToValidUTF8(truncateText(snippet, snippetLength), "")
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultTitleTemplate = `{{if .Code}}🔑 {{.Code}} · {{end}}📧 {{.Account}} [{{.Folder}}]`
+	defaultBodyTemplate  = "{{if .Code}}Code: {{.Code}}\n{{end}}{{if .Invite}}📅 {{.Invite}}\n{{end}}From: {{.From}}\nSubject: {{truncate 50 .Subject}}{{if .Attachments}}\n📎 {{truncate 80 (join .Attachments \", \")}}{{end}}{{if .Snippet}}\n{{.Snippet}}{{end}}"
+)
+
+type NotificationTemplateData struct {
+	Account     string
+	Label       string
+	Folder      string
+	From        string
+	FromName    string
+	FromAddress string
+	To          []string
+	Cc          []string
+	Subject     string
+	Date        time.Time
+	Snippet     string
+	Code        string
+	Priority    string
+	Attachments []string // names, or types for unnamed attachments
+	Size        int64
+	Invite      *calendarInvite
+}
+
+var templateFuncs = template.FuncMap{
+	"truncate": func(n int, s string) string { return truncateText(s, n) },
+	"join":     strings.Join,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"default": func(def, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+}
+
+func parseNotificationTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+func validateNotificationTemplates(title, body string) error {
+	if _, err := parseNotificationTemplate("title", title); err != nil {
+		return fmt.Errorf("invalid title_template: %v", err)
+	}
+	if _, err := parseNotificationTemplate("body", body); err != nil {
+		return fmt.Errorf("invalid body_template: %v", err)
+	}
+	return nil
+}
+
+func renderTemplate(name, text string, data NotificationTemplateData) (string, error) {
+	tmpl, err := parseNotificationTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func notificationTemplates(acc *AccountConfig) (string, string) {
+	title := acc.TitleTemplate
+	if title == "" {
+		title = defaultTitleTemplate
+		if acc.Protocol == "pop3" {
+			title = `{{if .Code}}🔑 {{.Code}} · {{end}}📧 {{.Account}} [POP3]`
+		}
+	}
+	body := acc.BodyTemplate
+	if body == "" {
+		body = defaultBodyTemplate
+	}
+	return title, body
+}
+
+func newTemplateData(acc *AccountConfig, m matchedMessage) NotificationTemplateData {
+	subject := m.Subject
+	if subject == "" {
+		subject = "(No Subject)"
+	}
+	label := acc.Label
+	if label == "" {
+		label = acc.Email
+	}
+
+	return NotificationTemplateData{
+		Account:     acc.Email,
+		Label:       label,
+		Folder:      m.Folder,
+		From:        m.Sender,
+		FromName:    m.FromName,
+		FromAddress: m.FromAddress,
+		To:          m.To,
+		Cc:          m.Cc,
+		Subject:     subject,
+		Date:        m.Date,
+		Snippet:     m.Snippet,
+		Code:        m.Code,
+		Priority:    m.Priority,
+		Attachments: m.Parts.attachmentNames(),
+		Size:        m.Parts.Size,
+		Invite:      m.Parts.Invite,
+	}
+}
+
+// renderNotification builds the title and body for a message, falling back
+// to the default templates if the account's templates fail to render.
+func renderNotification(acc *AccountConfig, m matchedMessage) (string, string) {
+	data := newTemplateData(acc, m)
+	titleTmpl, bodyTmpl := notificationTemplates(acc)
+
+	title, err := renderTemplate("title", titleTmpl, data)
+	if err != nil {
+		title, _ = renderTemplate("title", defaultTitleTemplate, data)
+	}
+	body, err2 := renderTemplate("body", bodyTmpl, data)
+	if err2 != nil {
+		body, _ = renderTemplate("body", defaultBodyTemplate, data)
+	}
+	if err == nil {
+		err = err2
+	}
+	if err != nil {
+		acc.logger().Warn("Notification template error", "error", err)
+	}
+
+	// Custom templates cannot hide the warning on suspicious mail.
+	if m.Auth.Status == "suspicious" {
+		title = "⚠️ " + title
+		body = "⚠️ " + strings.Join(m.Auth.Warnings, "; ") + "\n" + body
+	}
+
+	return title, body
+}
+
+// graphemeExtends reports whether r continues the grapheme cluster started
+// by the previous rune rather than beginning a new one.
+func graphemeExtends(prev, r rune) bool {
+	switch {
+	case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r), unicode.Is(unicode.Mc, r):
+		return true
+	case r == '\u200d' || prev == '\u200d':
+		return true
+	case r >= 0xfe00 && r <= 0xfe0f:
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff:
+		return true
+	case r >= 0xe0020 && r <= 0xe007f:
+		return true
+	case prev == '\r' && r == '\n':
+		return true
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// splitGraphemes splits s into user-perceived characters. It covers combining
+// marks, emoji modifiers and ZWJ sequences, and flag pairs, which is enough to
+// avoid cutting a notification in the middle of a character.
+func splitGraphemes(s string) []string {
+	var clusters []string
+	start := 0
+	var prev rune
+	regional := 0
+
+	for i, r := range s {
+		if i > 0 {
+			joined := graphemeExtends(prev, r) || (isRegionalIndicator(r) && regional%2 == 1)
+			if !joined {
+				clusters = append(clusters, s[start:i])
+				start = i
+			}
+		}
+		if isRegionalIndicator(r) {
+			regional++
+		} else {
+			regional = 0
+		}
+		prev = r
+	}
+	if start < len(s) {
+		clusters = append(clusters, s[start:])
+	}
+	return clusters
+}
+
+func truncateText(s string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	clusters := splitGraphemes(s)
+	if len(clusters) <= max {
+		return s
+	}
+	if max <= 3 {
+		return strings.Join(clusters[:max], "")
+	}
+	return strings.Join(clusters[:max-3], "") + "..."
+}
+
+func handleTemplatePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email         string `json:"email"`
+		Label         string `json:"label"`
+		Protocol      string `json:"protocol"`
+		TitleTemplate string `json:"title_template"`
+		BodyTemplate  string `json:"body_template"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	acc := &AccountConfig{
+		Email:         req.Email,
+		Label:         req.Label,
+		Protocol:      req.Protocol,
+		TitleTemplate: req.TitleTemplate,
+		BodyTemplate:  req.BodyTemplate,
+	}
+	sample := matchedMessage{
+		Folder:      "INBOX",
+		Sender:      "jane@example.com",
+		FromName:    "Jane Doe",
+		FromAddress: "jane@example.com",
+		To:          []string{req.Email},
+		Subject:     "Quarterly report – numbers for Q3 are in 📊 (please review before Friday)",
+		Date:        time.Now(),
+		Snippet:     "Hi, the Q3 numbers are attached. Revenue is up 12% compared to last quarter.",
+		Priority:    "normal",
+		Parts: messageParts{
+			Attachments: []attachmentInfo{{Name: "Q3-report.pdf", Type: "application/pdf", Size: 482133}},
+			Size:        661204,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	titleTmpl, bodyTmpl := notificationTemplates(acc)
+	data := newTemplateData(acc, sample)
+	title, err := renderTemplate("title", titleTmpl, data)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": fmt.Sprintf("Title template: %v", err)})
+		return
+	}
+	body, err := renderTemplate("body", bodyTmpl, data)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": fmt.Sprintf("Body template: %v", err)})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"title":   title,
+		"body":    body,
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+const defaultWebPort = 8765
+
+type WebSettings struct {
+	ListenAddress string `json:"listen_address,omitempty"` // default "127.0.0.1", or "0.0.0.0" with allow_lan
+	Port          int    `json:"port,omitempty"`
+	TLS           bool   `json:"tls,omitempty"`
+	AllowLAN      bool   `json:"allow_lan,omitempty"`
+	Metrics       bool   `json:"metrics,omitempty"` // serve Prometheus metrics on /metrics
+}
+
+func webSettings() WebSettings {
+	if config.Web == nil {
+		return WebSettings{}
+	}
+	return *config.Web
+}
+
+func validateWebSettings(ws *WebSettings) error {
+	if ws == nil {
+		return nil
+	}
+	if ws.Port < 0 || ws.Port > 65535 {
+		return fmt.Errorf("port %d out of range", ws.Port)
+	}
+	if ws.ListenAddress != "" && ws.ListenAddress != "localhost" && net.ParseIP(ws.ListenAddress) == nil {
+		return fmt.Errorf("listen_address %q is not an IP address", ws.ListenAddress)
+	}
+	if !ws.AllowLAN && ws.ListenAddress != "" && !isLoopbackHost(ws.ListenAddress) {
+		return fmt.Errorf("listen_address %q is not a loopback address, set allow_lan to listen on the network", ws.ListenAddress)
+	}
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (ws WebSettings) listenAddress() string {
+	if ws.ListenAddress != "" {
+		return ws.ListenAddress
+	}
+	if ws.AllowLAN {
+		return "0.0.0.0"
+	}
+	return "127.0.0.1"
+}
+
+func (ws WebSettings) scheme() string {
+	if ws.TLS {
+		return "https"
+	}
+	return "http"
+}
+
+// listenWebServer opens the dashboard listener. An explicitly configured port
+// must be available; the default port falls back to a random one so that a
+// second instance can still start.
+func listenWebServer(ws WebSettings) (net.Listener, error) {
+	addr := ws.listenAddress()
+	port := ws.Port
+	if port == 0 {
+		port = defaultWebPort
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(port)))
+	if err != nil && ws.Port == 0 {
+		slog.Warn("Port unavailable, using a random port", "port", port, "error", err)
+		listener, err = net.Listen("tcp", net.JoinHostPort(addr, "0"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	webServerPort = listener.Addr().(*net.TCPAddr).Port
+	urlHost := addr
+	if ip := net.ParseIP(addr); ip != nil && ip.IsUnspecified() {
+		urlHost = "127.0.0.1"
+	}
+	webServerURL = fmt.Sprintf("%s://%s", ws.scheme(), net.JoinHostPort(urlHost, strconv.Itoa(webServerPort)))
+	return listener, nil
+}
+
+// lanAddresses returns the non-loopback unicast addresses of this machine.
+func lanAddresses() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipnet.IP)
+	}
+	return ips
+}
+
+// lanDashboardURL is the sign-in link for other devices, using the first IPv4
+// address of this machine.
+func lanDashboardURL() string {
+	for _, ip := range lanAddresses() {
+		if ip.To4() != nil {
+			return fmt.Sprintf("%s://%s/auth?token=%s", webSettings().scheme(), net.JoinHostPort(ip.String(), strconv.Itoa(webServerPort)), apiToken)
+		}
+	}
+	return ""
+}
+
+func webCertFiles() (string, string) {
+	return filepath.Join(appDir, "web_cert.pem"), filepath.Join(appDir, "web_key.pem")
+}
+
+// ensureWebCertificate generates a self-signed certificate on first run. It is
+// regenerated when it is about to expire or, in LAN mode, when it does not
+// cover one of the current network addresses.
+func ensureWebCertificate(ws WebSettings) (string, string, error) {
+	certFile, keyFile := webCertFiles()
+
+	var hosts []string
+	hosts = append(hosts, "localhost", "127.0.0.1", "::1")
+	if ws.AllowLAN {
+		for _, ip := range lanAddresses() {
+			hosts = append(hosts, ip.String())
+		}
+	}
+
+	if pair, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if cert, err := x509.ParseCertificate(pair.Certificate[0]); err == nil && certCovers(cert, hosts) {
+			return certFile, keyFile, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate serial number: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Email Monitor"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(2, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode key: %v", err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", fmt.Errorf("failed to write key: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return "", "", fmt.Errorf("failed to write certificate: %v", err)
+	}
+
+	slog.Info("Created self-signed dashboard certificate", "path", certFile)
+	return certFile, keyFile, nil
+}
+
+func certCovers(cert *x509.Certificate, hosts []string) bool {
+	if time.Until(cert.NotAfter) < 30*24*time.Hour {
+		return false
+	}
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func setupLANMenu() {
+	if !webSettings().AllowLAN {
+		return
+	}
+	mLink := systray.AddMenuItem("📱 Copy Network Dashboard Link", "Copy a sign-in link for other devices on your network")
+
+	go func() {
+		for {
+			<-mLink.ClickedCh
+			link := lanDashboardURL()
+			if link == "" {
+				slog.Warn("No network address found for the dashboard")
+				continue
+			}
+			if err := copyToClipboard(link); err != nil {
+				slog.Warn("Failed to copy dashboard link", "error", err)
+				continue
+			}
+			slog.Info("Copied network dashboard link to clipboard")
+		}
+	}()
+}